@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/genuinetools/netns/daemon"
+	"github.com/genuinetools/netns/metrics"
+	"github.com/genuinetools/netns/network"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+// withTimeout returns a context canceled after the --timeout, if it is set.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// timeoutError makes the error of an operation that ran out of time say so.
+func timeoutError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+	return err
+}
+
+// The operations below go through the daemon when it is running so the
+// hooks do not contend on the database, and fall back to using it directly
+// otherwise. They give up after the --timeout.
+
+func createNetwork(ctx context.Context, hook configs.HookState, staticip string) (net.IP, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	ip, err := daemon.NewClient(socket).Create(ctx, hook, staticip)
+	if err != daemon.ErrUnavailable {
+		return ip, timeoutError(err)
+	}
+	logrus.Debugf("daemon is not running on %s, creating the network directly", socket)
+	ip, err = networks.Create(ctx, hook, staticip)
+	return ip, timeoutError(err)
+}
+
+func deleteNetwork(ctx context.Context, target string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	err := daemon.NewClient(socket).Delete(ctx, target)
+	if err != daemon.ErrUnavailable {
+		return timeoutError(err)
+	}
+	logrus.Debugf("daemon is not running on %s, deleting the network directly", socket)
+	return timeoutError(networks.Delete(ctx, target))
+}
+
+// listNetworks lists the networks, through the daemon if it is running. The
+// namespace handles are only opened for a direct listing, they are closed
+// with closeNamespaces.
+func listNetworks(ctx context.Context) ([]network.Network, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	list, err := daemon.NewClient(socket).List(ctx)
+	if err != daemon.ErrUnavailable {
+		return list, timeoutError(err)
+	}
+	list, err = networks.List(ctx)
+	if err != nil {
+		return list, timeoutError(err)
+	}
+
+	// Try to get the namespace handle, the pid is in another one if it was
+	// reused.
+	for i, n := range list {
+		if n.Status == network.StatusRunning {
+			list[i].FD, _ = netns.GetFromPid(n.PID)
+		}
+	}
+	return list, nil
+}
+
+// closeNamespaces closes the namespace handles of the networks.
+func closeNamespaces(networks []network.Network) {
+	for _, n := range networks {
+		if n.FD > 0 {
+			n.FD.Close()
+		}
+	}
+}
+
+func inspectNetwork(ctx context.Context, target string) (*network.Inspection, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	i, err := daemon.NewClient(socket).Inspect(ctx, target)
+	if err != daemon.ErrUnavailable {
+		return i, timeoutError(err)
+	}
+	i, err = networks.Inspect(ctx, target)
+	return i, timeoutError(err)
+}
+
+func gcNetworks(ctx context.Context) ([]network.Allocation, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	reclaimed, err := daemon.NewClient(socket).GC(ctx)
+	if err != daemon.ErrUnavailable {
+		return reclaimed, timeoutError(err)
+	}
+	reclaimed, err = networks.GC(ctx)
+	return reclaimed, timeoutError(err)
+}
+
+// metricsText returns the metrics of the daemon. Without the daemon only
+// the pool usage can be reported since the counters live in the processes
+// doing the operations.
+func metricsText(ctx context.Context) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	b, err := daemon.NewClient(socket).Metrics(ctx)
+	if err != daemon.ErrUnavailable {
+		return b, timeoutError(err)
+	}
+
+	if err := networks.UpdatePoolMetrics(ctx); err != nil {
+		return nil, timeoutError(err)
+	}
+	var buf bytes.Buffer
+	if err := metrics.Default.WriteText(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -4,9 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"strings"
+	"time"
 
-	"github.com/docker/libnetwork/iptables"
+	"github.com/genuinetools/netns/kernel"
 	"github.com/genuinetools/netns/netutils"
 	"github.com/vishvananda/netlink"
 )
@@ -21,38 +21,140 @@ var (
 	ErrIPAddrEmpty = errors.New("ip address cannot be empty")
 	// ErrNameEmpty holds the error for when the name is empty.
 	ErrNameEmpty = errors.New("name cannot be empty")
+	// ErrSubnetConflict holds the error for when the subnet of the bridge
+	// overlaps with a network already on the host.
+	ErrSubnetConflict = errors.New("subnet conflicts with the host")
 )
 
+// SubnetConflictError is the error for a bridge subnet overlapping with the
+// network of another interface or the destination of a route.
+type SubnetConflictError struct {
+	Subnet    *net.IPNet
+	Interface string
+	Network   *net.IPNet
+	// Route is set when the network is the destination of a route rather
+	// than the network of an address.
+	Route bool
+}
+
+func (e *SubnetConflictError) Error() string {
+	if e.Route {
+		if len(e.Interface) < 1 {
+			return fmt.Sprintf("subnet %s overlaps with the route to %s on the host", e.Subnet.String(), e.Network.String())
+		}
+		return fmt.Sprintf("subnet %s overlaps with the route to %s via interface %s", e.Subnet.String(), e.Network.String(), e.Interface)
+	}
+	if len(e.Interface) < 1 {
+		return fmt.Sprintf("subnet %s overlaps with %s on the host", e.Subnet.String(), e.Network.String())
+	}
+	return fmt.Sprintf("subnet %s overlaps with %s of interface %s", e.Subnet.String(), e.Network.String(), e.Interface)
+}
+
+// Is makes the error match ErrSubnetConflict.
+func (e *SubnetConflictError) Is(target error) bool {
+	return target == ErrSubnetConflict
+}
+
 // Opt holds the options for the bridge interface.
 type Opt struct {
-	MTU    int
+	MTU int
+	// IPAddr is the address of the bridge with the prefix length of its
+	// subnet, or Auto to pick the subnet from the AddressPools.
 	IPAddr string
 	Name   string
+
+	// AddressPools are the subnets to pick from when IPAddr is Auto,
+	// DefaultAddressPools if empty.
+	AddressPools []AddressPool
+
+	// DisableICC blocks the traffic between containers on the bridge,
+	// except for the traffic matching one of the ICCAllow rules. Traffic to
+	// the gateway and out of the bridge is not affected.
+	DisableICC bool
+	ICCAllow   []ICCRule
+
+	// NAT is the address translation applied to the traffic leaving the
+	// bridge.
+	NAT NAT
+	// IPForward enables forwarding in the kernel so the traffic from the
+	// bridge is routed to the other interfaces.
+	IPForward bool
+
+	// Kernel is the kernel the bridge is set up in, kernel.Host if nil.
+	Kernel kernel.Kernel
+}
+
+// Address returns the address of the bridge with the prefix length of its
+// subnet. It is the address of the existing bridge when the options use
+// Auto.
+func Address(opt Opt) (string, error) {
+	if opt.IPAddr != Auto {
+		return opt.IPAddr, nil
+	}
+	if opt.Kernel == nil {
+		opt.Kernel = kernel.Host
+	}
+	addr, err := kernel.InterfaceAddr(opt.Kernel, opt.Name)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
 }
 
 // Init creates a bridge with the name specified if it does not exist.
 func Init(opt Opt) (*net.Interface, error) {
+	start := time.Now()
+	bridge, created, err := initBridge(opt)
+	observeInit(opt.Name, created, err, time.Since(start))
+	return bridge, err
+}
+
+// initBridge creates the bridge if it does not exist and sets up the host
+// for it. It returns whether the bridge was created.
+func initBridge(opt Opt) (*net.Interface, bool, error) {
 	// Validate the options.
 	if len(opt.IPAddr) < 1 {
-		return nil, ErrIPAddrEmpty
+		return nil, false, ErrIPAddrEmpty
 	}
 	if len(opt.Name) < 1 {
-		return nil, ErrNameEmpty
+		return nil, false, ErrNameEmpty
 	}
 
 	// Set the defaults.
 	if opt.MTU < 1 {
 		opt.MTU = DefaultMTU
 	}
+	if opt.Kernel == nil {
+		opt.Kernel = kernel.Host
+	}
+	k := opt.Kernel
 
-	bridge, err := net.InterfaceByName(opt.Name)
+	link, err := k.LinkByName(opt.Name)
 	if err == nil {
-		// Bridge already exists, return early.
-		return bridge, nil
+		// Bridge already exists, make sure the host is setup for it and
+		// return early.
+		if opt.IPAddr, err = Address(opt); err != nil {
+			return nil, false, err
+		}
+		if err := setupHost(opt); err != nil {
+			return nil, false, err
+		}
+		return kernel.Interface(link), false, nil
 	}
 
-	if !strings.Contains(err.Error(), "no such network interface") {
-		return nil, fmt.Errorf("getting interface %s failed: %v", opt.Name, err)
+	if !kernel.IsLinkNotFound(err) {
+		return nil, false, fmt.Errorf("getting interface %s failed: %v", opt.Name, err)
+	}
+
+	// Make sure the subnet is not used by another interface or route, or
+	// pick one that is not.
+	if opt.IPAddr == Auto {
+		opt.IPAddr, err = pickSubnet(k, opt.AddressPools)
+		if err != nil {
+			return nil, false, err
+		}
+	} else if err := checkSubnet(k, opt.IPAddr); err != nil {
+		return nil, false, err
 	}
 
 	// Create *netlink.Bridge object.
@@ -60,49 +162,297 @@ func Init(opt Opt) (*net.Interface, error) {
 	la.Name = opt.Name
 	la.MTU = opt.MTU
 	br := &netlink.Bridge{LinkAttrs: la}
-	if err := netlink.LinkAdd(br); err != nil {
-		return nil, fmt.Errorf("bridge creation for %s failed: %v", opt.Name, err)
+	if err := k.LinkAdd(br); err != nil {
+		return nil, false, fmt.Errorf("bridge creation for %s failed: %v", opt.Name, err)
 	}
 
 	// Setup ip address for bridge.
 	addr, err := netlink.ParseAddr(opt.IPAddr)
 	if err != nil {
-		return nil, fmt.Errorf("parsing address %s failed: %v", opt.IPAddr, err)
+		return nil, false, fmt.Errorf("parsing address %s failed: %v", opt.IPAddr, err)
 	}
-	if err := netlink.AddrAdd(br, addr); err != nil {
-		return nil, fmt.Errorf("adding address %s to bridge %s failed: %v", addr.String(), opt.Name, err)
+	if err := k.AddrAdd(br, addr); err != nil {
+		return nil, false, fmt.Errorf("adding address %s to bridge %s failed: %v", addr.String(), opt.Name, err)
 	}
 
 	// Validate that the IPAddress is there!
-	if _, err := netutils.GetInterfaceAddr(opt.Name); err != nil {
-		return nil, err
+	if _, err := kernel.InterfaceAddr(k, opt.Name); err != nil {
+		return nil, false, err
 	}
 
-	// Add NAT rules for iptables.
-	if err := netutils.SetupNATOut(opt.IPAddr, iptables.Insert); err != nil {
-		return nil, fmt.Errorf("setting up NAT outbound for %s failed: %v", opt.Name, err)
+	if err := setupHost(opt); err != nil {
+		return nil, false, err
 	}
 
 	// Bring the bridge up.
-	if err := netlink.LinkSetUp(br); err != nil {
-		return nil, fmt.Errorf("bringing bridge %s up failed: %v", opt.Name, err)
+	if err := k.LinkSetUp(br); err != nil {
+		return nil, false, fmt.Errorf("bringing bridge %s up failed: %v", opt.Name, err)
+	}
+
+	link, err = k.LinkByName(opt.Name)
+	if err != nil {
+		return nil, true, fmt.Errorf("getting interface %s failed: %v", opt.Name, err)
 	}
+	return kernel.Interface(link), true, nil
+}
+
+// CheckRenumber returns an error if the existing bridge cannot be renumbered
+// to ipAddr because the new subnet overlaps with the other networks of the
+// host.
+func CheckRenumber(opt Opt, ipAddr string) error {
+	if opt.Kernel == nil {
+		opt.Kernel = kernel.Host
+	}
+	k := opt.Kernel
+
+	if _, err := k.LinkByName(opt.Name); err != nil {
+		return fmt.Errorf("getting interface %s failed: %v", opt.Name, err)
+	}
+	_, subnet, err := net.ParseCIDR(ipAddr)
+	if err != nil {
+		return fmt.Errorf("parsing address %s failed: %v", ipAddr, err)
+	}
+
+	// The old subnet of the bridge is going away.
+	networks, err := hostNetworks(k)
+	if err != nil {
+		return err
+	}
+	others := networks[:0]
+	for _, n := range networks {
+		if n.iface != opt.Name {
+			others = append(others, n)
+		}
+	}
+	return conflict(subnet, others)
+}
+
+// Renumber changes the address of the existing bridge to ipAddr and moves
+// the nat rules of its old subnet to the new one.
+func Renumber(opt Opt, ipAddr string) error {
+	if opt.Kernel == nil {
+		opt.Kernel = kernel.Host
+	}
+	k := opt.Kernel
 
-	return net.InterfaceByName(opt.Name)
+	if err := CheckRenumber(opt, ipAddr); err != nil {
+		return err
+	}
+
+	link, err := k.LinkByName(opt.Name)
+	if err != nil {
+		return fmt.Errorf("getting interface %s failed: %v", opt.Name, err)
+	}
+	old, err := kernel.InterfaceAddr(k, opt.Name)
+	if err != nil {
+		return err
+	}
+	addr, err := netlink.ParseAddr(ipAddr)
+	if err != nil {
+		return fmt.Errorf("parsing address %s failed: %v", ipAddr, err)
+	}
+
+	// Add the new address before removing the old one so the bridge is
+	// never left without an address.
+	if err := k.AddrAdd(link, addr); err != nil {
+		return fmt.Errorf("adding address %s to bridge %s failed: %v", addr.String(), opt.Name, err)
+	}
+	if err := k.AddrDel(link, &netlink.Addr{IPNet: old}); err != nil {
+		return fmt.Errorf("removing address %s from bridge %s failed: %v", old.String(), opt.Name, err)
+	}
+
+	if err := k.RemoveNATOut(old.String()); err != nil {
+		return fmt.Errorf("removing NAT outbound for %s failed: %v", old.String(), err)
+	}
+	opt.IPAddr = ipAddr
+	return setupHost(opt)
+}
+
+// checkSubnet returns a SubnetConflictError if the subnet of the address
+// overlaps with the network of an interface of the host or the destination
+// of one of its routes.
+func checkSubnet(k kernel.Kernel, ipAddr string) error {
+	_, subnet, err := net.ParseCIDR(ipAddr)
+	if err != nil {
+		return fmt.Errorf("parsing address %s failed: %v", ipAddr, err)
+	}
+
+	networks, err := hostNetworks(k)
+	if err != nil {
+		return err
+	}
+	return conflict(subnet, networks)
+}
+
+// hostNetwork is a network of an interface of the host or the destination of
+// one of its routes.
+type hostNetwork struct {
+	network *net.IPNet
+	iface   string
+	isRoute bool
+}
+
+// hostNetworks returns the networks of the interfaces and the destinations of
+// the routes of the host.
+func hostNetworks(k kernel.Kernel) ([]hostNetwork, error) {
+	var networks []hostNetwork
+
+	addrs, err := k.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("listing addresses failed: %v", err)
+	}
+	for _, addr := range addrs {
+		if addr.IPNet == nil || addr.IP.IsLoopback() || addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		networks = append(networks, hostNetwork{
+			network: &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask},
+			iface:   addr.Label,
+		})
+	}
+
+	routes, err := k.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("listing routes failed: %v", err)
+	}
+	for _, r := range routes {
+		// The default routes overlap with everything.
+		if r.Dst == nil || r.Dst.IP.IsLoopback() || r.Dst.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+			continue
+		}
+		n := hostNetwork{
+			network: &net.IPNet{IP: r.Dst.IP.Mask(r.Dst.Mask), Mask: r.Dst.Mask},
+			isRoute: true,
+		}
+		if link, err := k.LinkByIndex(r.LinkIndex); err == nil {
+			n.iface = link.Attrs().Name
+		}
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+// conflict returns a SubnetConflictError for the first of the host networks
+// the subnet overlaps with, or nil.
+func conflict(subnet *net.IPNet, networks []hostNetwork) error {
+	for _, n := range networks {
+		if subnet.Contains(n.network.IP) || n.network.Contains(subnet.IP) {
+			return &SubnetConflictError{
+				Subnet:    subnet,
+				Interface: n.iface,
+				Network:   n.network,
+				Route:     n.isRoute,
+			}
+		}
+	}
+	return nil
+}
+
+// setupHost configures forwarding and the NAT and filter rules for the
+// bridge. The kernel of the options must be set.
+func setupHost(opt Opt) error {
+	k := opt.Kernel
+
+	// Enable forwarding.
+	if opt.IPForward {
+		key := "net/ipv4/ip_forward"
+		if ip, _, err := net.ParseCIDR(opt.IPAddr); err == nil && ip.To4() == nil {
+			key = "net/ipv6/conf/all/forwarding"
+		}
+		if err := k.SetSysctl(key, "1"); err != nil {
+			return fmt.Errorf("enabling forwarding for %s failed: %v", opt.Name, err)
+		}
+	}
+
+	// Add NAT rules for iptables.
+	if err := setupNAT(k, opt); err != nil {
+		return err
+	}
+
+	allow := make([][]string, 0, len(opt.ICCAllow))
+	for _, r := range opt.ICCAllow {
+		allow = append(allow, r.iptablesArgs())
+	}
+
+	if err := k.SetupICC(opt.Name, !opt.DisableICC, allow); err != nil {
+		return fmt.Errorf("setting up inter-container communication rules for %s failed: %v", opt.Name, err)
+	}
+
+	return nil
+}
+
+// setupNAT installs the nat rule of the mode for the traffic leaving the
+// bridge. The rules of the network are only replaced when they differ, so a
+// change of mode or source leaves no other rule behind.
+func setupNAT(k kernel.Kernel, opt Opt) error {
+	var rule string
+	switch opt.NAT.Mode {
+	case "", NATMasquerade:
+		rule = netutils.NATOutRule(opt.IPAddr, nil)
+	case NATSNAT:
+		rule = netutils.NATOutRule(opt.IPAddr, opt.NAT.Source)
+	case NATNone:
+	default:
+		return fmt.Errorf("unknown nat mode %q for %s", opt.NAT.Mode, opt.Name)
+	}
+
+	rules, err := k.NATRules()
+	if err != nil {
+		// Without iptables there are no rules to remove.
+		if len(rule) < 1 {
+			return nil
+		}
+		return err
+	}
+	current := netutils.NATOutRules(rules, opt.IPAddr)
+	if (len(current) == 0 && len(rule) < 1) || (len(current) == 1 && current[0] == rule) {
+		return nil
+	}
+
+	if len(current) > 0 {
+		if err := k.RemoveNATOut(opt.IPAddr); err != nil {
+			return fmt.Errorf("removing NAT outbound for %s failed: %v", opt.Name, err)
+		}
+	}
+	switch opt.NAT.Mode {
+	case "", NATMasquerade:
+		if err := k.SetupNATOut(opt.IPAddr); err != nil {
+			return fmt.Errorf("setting up NAT outbound for %s failed: %v", opt.Name, err)
+		}
+	case NATSNAT:
+		if err := k.SetupSNATOut(opt.IPAddr, opt.NAT.Source); err != nil {
+			return fmt.Errorf("setting up SNAT outbound to %s for %s failed: %v", opt.NAT.Source, opt.Name, err)
+		}
+	}
+
+	return nil
 }
 
 // Delete removes the bridge by the specified name.
 func Delete(name string) error {
+	return deleteBridge(kernel.Host, name)
+}
+
+func deleteBridge(k kernel.Kernel, name string) error {
 	// Get the link.
-	l, err := netlink.LinkByName(name)
+	l, err := k.LinkByName(name)
 	if err != nil {
 		return fmt.Errorf("getting bridge %s failed: %v", name, err)
 	}
 
 	// Delete the link.
-	if err := netlink.LinkDel(l); err != nil {
+	if err := k.LinkDel(l); err != nil {
 		return fmt.Errorf("deleting bridge %s failed: %v", name, err)
 	}
 
+	// Remove the inter-container communication rules.
+	if err := k.SetupICC(name, true, nil); err != nil {
+		return fmt.Errorf("removing inter-container communication rules for %s failed: %v", name, err)
+	}
+
 	return nil
 }
@@ -1,6 +1,14 @@
 package bridge
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/genuinetools/netns/kernel"
+	"github.com/vishvananda/netlink"
+)
 
 const (
 	defaultBridgeIP   = "172.19.0.1/16"
@@ -34,10 +42,11 @@ func TestInitBridgeNameEmpty(t *testing.T) {
 }
 
 func TestInitBridgeDefaults(t *testing.T) {
-	defer Delete(defaultBridgeName)
+	k := kernel.NewFake()
 	br, err := Init(Opt{
 		IPAddr: defaultBridgeIP,
 		Name:   defaultBridgeName,
+		Kernel: k,
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -46,13 +55,32 @@ func TestInitBridgeDefaults(t *testing.T) {
 	if br.MTU != DefaultMTU {
 		t.Fatalf("expected bridge MTU to be %d got %d", DefaultMTU, br.MTU)
 	}
+	if br.Flags&net.FlagUp == 0 {
+		t.Fatal("expected bridge to be up")
+	}
+
+	addr, err := kernel.InterfaceAddr(k, defaultBridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != defaultBridgeIP {
+		t.Fatalf("expected bridge address to be %s got %s", defaultBridgeIP, addr)
+	}
+
+	if nat := fmt.Sprint(k.NAT[defaultBridgeIP]); nat != "[masquerade]" {
+		t.Fatalf("expected masquerading for %s, got %s", defaultBridgeIP, nat)
+	}
+	if !k.ICC[defaultBridgeName] {
+		t.Fatal("expected inter-container communication to be allowed")
+	}
 }
 
 func TestInitBridgeExists(t *testing.T) {
-	defer Delete(defaultBridgeName)
+	k := kernel.NewFake()
 	br, err := Init(Opt{
 		IPAddr: defaultBridgeIP,
 		Name:   defaultBridgeName,
+		Kernel: k,
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -62,16 +90,355 @@ func TestInitBridgeExists(t *testing.T) {
 		t.Fatalf("expected bridge name to be %s got %s", defaultBridgeName, br.Name)
 	}
 
-	// Initialize the bridge again.
-	br, err = Init(Opt{
+	// Initialize the bridge again, the host setup is updated.
+	br2, err := Init(Opt{
+		IPAddr:     defaultBridgeIP,
+		Name:       defaultBridgeName,
+		DisableICC: true,
+		Kernel:     k,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if br2.Name != defaultBridgeName || br2.Index != br.Index {
+		t.Fatalf("expected bridge %s with index %d got %s with index %d", defaultBridgeName, br.Index, br2.Name, br2.Index)
+	}
+	if k.ICC[defaultBridgeName] {
+		t.Fatal("expected inter-container communication to be blocked")
+	}
+
+	if err := deleteBridge(k, defaultBridgeName); err != nil {
+		t.Fatal(err)
+	}
+	if links := k.Links(0); len(links) != 0 {
+		t.Fatalf("expected no links after deleting the bridge, got %v", links)
+	}
+}
+
+func TestInitBridgeNATModes(t *testing.T) {
+	k := kernel.NewFake()
+
+	// Every change of mode or source leaves only the rule of the new mode.
+	for _, tc := range []struct {
+		nat      string
+		expected string
+	}{
+		{"masquerade", "[masquerade]"},
+		{"snat:192.0.2.10", "[192.0.2.10]"},
+		{"snat:192.0.2.11", "[192.0.2.11]"},
+		{"masquerade", "[masquerade]"},
+		{"none", "[]"},
+		{"snat:192.0.2.10", "[192.0.2.10]"},
+		{"snat:192.0.2.10", "[192.0.2.10]"},
+		{"none", "[]"},
+	} {
+		nat, err := ParseNAT(tc.nat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Init(Opt{
+			IPAddr: defaultBridgeIP,
+			Name:   defaultBridgeName,
+			NAT:    nat,
+			Kernel: k,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := fmt.Sprint(k.NAT[defaultBridgeIP]); got != tc.expected {
+			t.Fatalf("expected nat rules %s after switching to %s, got %s", tc.expected, tc.nat, got)
+		}
+	}
+
+	// The rules are left alone when they are already right.
+	k.Fail("RemoveNATOut", errors.New("iptables not found"))
+	if _, err := Init(Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+		NAT:    NAT{Mode: NATNone},
+		Kernel: k,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenumberNAT(t *testing.T) {
+	k := kernel.NewFake()
+
+	// The rules of the old subnet are replaced by the ones of the new
+	// subnet, whatever the mode.
+	for _, tc := range []struct {
+		nat      string
+		expected string
+	}{
+		{"masquerade", "[masquerade]"},
+		{"snat:192.0.2.10", "[192.0.2.10]"},
+		{"none", "[]"},
+	} {
+		nat, err := ParseNAT(tc.nat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt := Opt{
+			IPAddr: defaultBridgeIP,
+			Name:   defaultBridgeName,
+			NAT:    nat,
+			Kernel: k,
+		}
+		if _, err := Init(opt); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Renumber(opt, "10.20.0.1/24"); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(k.NAT[defaultBridgeIP]); got != "[]" {
+			t.Fatalf("expected no nat rules for the old subnet with %s, got %s", tc.nat, got)
+		}
+		if got := fmt.Sprint(k.NAT["10.20.0.1/24"]); got != tc.expected {
+			t.Fatalf("expected nat rules %s for the new subnet with %s, got %s", tc.expected, tc.nat, got)
+		}
+
+		opt.IPAddr = "10.20.0.1/24"
+		if err := Renumber(opt, defaultBridgeIP); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInitBridgeFailure(t *testing.T) {
+	k := kernel.NewFake()
+	k.Fail("SetupICC", errors.New("iptables not found"))
+
+	if _, err := Init(Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+		Kernel: k,
+	}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestInitBridgeSubnetConflict(t *testing.T) {
+	k := kernel.NewFake()
+
+	// A VPN interface routing a network overlapping with the bridge.
+	la := netlink.NewLinkAttrs()
+	la.Name = "tun0"
+	tun := &netlink.Device{LinkAttrs: la}
+	if err := k.LinkAdd(tun); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := netlink.ParseAddr("172.19.4.1/24")
+	if err := k.AddrAdd(tun, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Init(Opt{
 		IPAddr: defaultBridgeIP,
 		Name:   defaultBridgeName,
+		Kernel: k,
 	})
+	var conflict *SubnetConflictError
+	if !errors.As(err, &conflict) || conflict.Interface != "tun0" {
+		t.Fatalf("expected a conflict with tun0 got %v", err)
+	}
+	if !errors.Is(err, ErrSubnetConflict) {
+		t.Fatalf("expected %v got %v", ErrSubnetConflict, err)
+	}
+
+	// A network next to it does not conflict.
+	if _, err := Init(Opt{
+		IPAddr: "172.20.0.1/16",
+		Name:   defaultBridgeName,
+		Kernel: k,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitBridgeRouteConflict(t *testing.T) {
+	k := kernel.NewFake()
+
+	// A VPN interface with a route to a corporate range that contains the
+	// subnet of the bridge.
+	la := netlink.NewLinkAttrs()
+	la.Name = "wg0"
+	wg := &netlink.Device{LinkAttrs: la}
+	if err := k.LinkAdd(wg); err != nil {
+		t.Fatal(err)
+	}
+	link, _ := k.LinkByName("wg0")
+	_, dst, _ := net.ParseCIDR("172.16.0.0/12")
+	if err := k.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Init(Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+		Kernel: k,
+	})
+	var conflict *SubnetConflictError
+	if !errors.As(err, &conflict) || !conflict.Route || conflict.Interface != "wg0" {
+		t.Fatalf("expected a conflict with the route of wg0 got %v", err)
+	}
+	if expected := "subnet 172.19.0.0/16 overlaps with the route to 172.16.0.0/12 via interface wg0"; err.Error() != expected {
+		t.Fatalf("expected %q got %q", expected, err.Error())
+	}
+
+	// Auto skips the default pool overlapping with the route.
+	i, err := Init(Opt{
+		IPAddr: Auto,
+		Name:   defaultBridgeName,
+		Kernel: k,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := kernel.InterfaceAddr(k, i.Name)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if addr.String() != "192.168.0.1/20" {
+		t.Fatalf("expected 192.168.0.1/20 got %s", addr.String())
+	}
+}
 
-	if br.Name != defaultBridgeName {
-		t.Fatalf("expected bridge name to be %s got %s", defaultBridgeName, br.Name)
+func TestInitBridgeAuto(t *testing.T) {
+	k := kernel.NewFake()
+	pool, err := ParseAddressPool("base=10.10.0.0/16,size=24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each bridge gets the next free subnet of the pool.
+	for i, expected := range []string{"10.10.0.1/24", "10.10.1.1/24"} {
+		name := fmt.Sprintf("netns%d", i)
+		if _, err := Init(Opt{
+			IPAddr:       Auto,
+			AddressPools: []AddressPool{pool},
+			Name:         name,
+			Kernel:       k,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		addr, err := kernel.InterfaceAddr(k, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != expected {
+			t.Fatalf("expected %s for %s got %s", expected, name, addr.String())
+		}
+	}
+
+	// The subnet of an existing bridge is kept.
+	if _, err := Init(Opt{
+		IPAddr:       Auto,
+		AddressPools: []AddressPool{pool},
+		Name:         "netns0",
+		Kernel:       k,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := Address(Opt{IPAddr: Auto, Name: "netns0", Kernel: k}); addr != "10.10.0.1/24" {
+		t.Fatalf("expected the bridge to keep 10.10.0.1/24 got %s", addr)
+	}
+
+	// A pool without free subnets is a conflict.
+	full, _ := ParseAddressPool("base=10.10.0.0/23,size=24")
+	if _, err := Init(Opt{
+		IPAddr:       Auto,
+		AddressPools: []AddressPool{full},
+		Name:         "netns2",
+		Kernel:       k,
+	}); !errors.Is(err, ErrSubnetConflict) {
+		t.Fatalf("expected %v got %v", ErrSubnetConflict, err)
+	}
+}
+
+func TestParseAddressPool(t *testing.T) {
+	p, err := ParseAddressPool("base=172.17.0.0/12,size=16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "base=172.16.0.0/12,size=16" {
+		t.Fatalf("expected base=172.16.0.0/12,size=16 got %s", p.String())
+	}
+
+	for _, s := range []string{
+		"size=16",
+		"base=172.17.0.0/16,size=8",
+		"base=172.17.0.0/16,size=31",
+		"base=fd00::/8,size=64",
+		"base=172.17.0.0/16,mask=24",
+	} {
+		if _, err := ParseAddressPool(s); err == nil {
+			t.Fatalf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestParseICCRule(t *testing.T) {
+	testcases := map[string]string{
+		"src=172.19.0.2,dst=172.19.0.3":             "src=172.19.0.2/32,dst=172.19.0.3/32",
+		"dst=172.19.0.3,port=80":                    "dst=172.19.0.3/32,port=80/tcp",
+		"src=172.19.0.0/24,port=53/UDP":             "src=172.19.0.0/24,port=53/udp",
+		"src=172.19.0.2, dst=172.19.0.3, port=8080": "src=172.19.0.2/32,dst=172.19.0.3/32,port=8080/tcp",
+	}
+
+	for in, expected := range testcases {
+		r, err := ParseICCRule(in)
+		if err != nil {
+			t.Fatalf("parsing %q failed: %v", in, err)
+		}
+
+		if r.String() != expected {
+			t.Fatalf("expected %q to parse as %q got %q", in, expected, r.String())
+		}
+	}
+}
+
+func TestParseICCRuleInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"src",
+		"src=foo",
+		"src=2001:db8::1",
+		"dst=2001:db8::/64",
+		"port=80/icmp",
+		"port=70000",
+		"proto=tcp",
+	} {
+		if _, err := ParseICCRule(in); err == nil {
+			t.Fatalf("expected an error parsing %q", in)
+		}
+	}
+}
+
+func TestParseNAT(t *testing.T) {
+	testcases := map[string]NAT{
+		"":                {Mode: NATMasquerade},
+		"masquerade":      {Mode: NATMasquerade},
+		"none":            {Mode: NATNone},
+		"snat:192.0.2.10": {Mode: NATSNAT, Source: net.ParseIP("192.0.2.10")},
+	}
+
+	for in, expected := range testcases {
+		n, err := ParseNAT(in)
+		if err != nil {
+			t.Fatalf("parsing %q failed: %v", in, err)
+		}
+
+		if n.Mode != expected.Mode || !n.Source.Equal(expected.Source) {
+			t.Fatalf("expected %q to parse as %s got %s", in, expected, n)
+		}
+	}
+
+	for _, in := range []string{"snat", "snat:foo", "snat:2001:db8::1", "none:192.0.2.10", "dnat"} {
+		if _, err := ParseNAT(in); err == nil {
+			t.Fatalf("expected an error parsing %q", in)
+		}
 	}
 }
@@ -0,0 +1,122 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ICCRule describes traffic that is allowed between containers on the bridge
+// when inter-container communication is disabled. Empty fields match
+// anything.
+type ICCRule struct {
+	Src   *net.IPNet
+	Dst   *net.IPNet
+	Proto string
+	Port  int
+}
+
+// ParseICCRule parses a rule in the form of "src=IP,dst=IP,port=PORT/PROTO".
+// The addresses must be IPv4, they may also be given in CIDR notation, and
+// the protocol defaults to tcp.
+func ParseICCRule(s string) (ICCRule, error) {
+	var r ICCRule
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) != 2 {
+			return r, fmt.Errorf("parsing icc rule %q failed: expected key=value, got %q", s, kv)
+		}
+
+		var err error
+		switch parts[0] {
+		case "src":
+			r.Src, err = parseIPNet(parts[1])
+		case "dst":
+			r.Dst, err = parseIPNet(parts[1])
+		case "port":
+			r.Port, r.Proto, err = parsePort(parts[1])
+		default:
+			err = fmt.Errorf("unknown key %q", parts[0])
+		}
+		if err != nil {
+			return r, fmt.Errorf("parsing icc rule %q failed: %v", s, err)
+		}
+	}
+
+	if r.Src == nil && r.Dst == nil && r.Port == 0 {
+		return r, fmt.Errorf("parsing icc rule %q failed: rule matches all traffic", s)
+	}
+
+	return r, nil
+}
+
+// String returns the rule in the form accepted by ParseICCRule.
+func (r ICCRule) String() string {
+	var parts []string
+	if r.Src != nil {
+		parts = append(parts, "src="+r.Src.String())
+	}
+	if r.Dst != nil {
+		parts = append(parts, "dst="+r.Dst.String())
+	}
+	if r.Port > 0 {
+		parts = append(parts, fmt.Sprintf("port=%d/%s", r.Port, r.Proto))
+	}
+	return strings.Join(parts, ",")
+}
+
+// iptablesArgs returns the iptables match arguments for the rule.
+func (r ICCRule) iptablesArgs() []string {
+	var args []string
+	if r.Src != nil {
+		args = append(args, "-s", r.Src.String())
+	}
+	if r.Dst != nil {
+		args = append(args, "-d", r.Dst.String())
+	}
+	if r.Port > 0 {
+		args = append(args, "-p", r.Proto, "--dport", strconv.Itoa(r.Port))
+	}
+	return args
+}
+
+func parseIPNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %q", s)
+		}
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("ip address %q is not an IPv4 address", s)
+		}
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	ip, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("network %q is not an IPv4 network", s)
+	}
+	return ipNet, nil
+}
+
+func parsePort(s string) (int, string, error) {
+	proto := "tcp"
+	if i := strings.Index(s, "/"); i >= 0 {
+		s, proto = s[:i], strings.ToLower(s[i+1:])
+	}
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return 0, "", fmt.Errorf("unsupported protocol %q", proto)
+	}
+
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, "", fmt.Errorf("invalid port %q", s)
+	}
+
+	return port, proto, nil
+}
@@ -0,0 +1,29 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/genuinetools/netns/metrics"
+)
+
+var (
+	initsTotal = metrics.NewCounterVec("netns_bridge_inits_total",
+		"Number of bridge initializations by result.", "bridge", "result")
+	initDuration = metrics.NewHistogramVec("netns_bridge_init_duration_seconds",
+		"Duration of the bridge initializations.", metrics.DefaultBuckets, "bridge")
+)
+
+// observeInit records the result and the duration of a bridge
+// initialization.
+func observeInit(name string, created bool, err error, d time.Duration) {
+	result := "existing"
+	switch {
+	case err != nil:
+		result = "error"
+	case created:
+		result = "created"
+	}
+
+	initsTotal.Inc(name, result)
+	initDuration.Observe(d.Seconds(), name)
+}
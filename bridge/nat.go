@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// NATMasquerade masquerades the traffic leaving the bridge behind the
+	// address of the outgoing interface.
+	NATMasquerade = "masquerade"
+	// NATSNAT rewrites the source of the traffic leaving the bridge to a
+	// fixed address.
+	NATSNAT = "snat"
+	// NATNone routes the traffic leaving the bridge without any translation.
+	NATNone = "none"
+)
+
+// NAT holds the network address translation settings for the traffic
+// leaving the bridge. The zero value masquerades the traffic.
+type NAT struct {
+	Mode   string
+	Source net.IP
+}
+
+// ParseNAT parses a NAT mode in the form of "masquerade", "snat:<ip>" or
+// "none".
+func ParseNAT(s string) (NAT, error) {
+	parts := strings.SplitN(s, ":", 2)
+
+	switch parts[0] {
+	case "", NATMasquerade, NATNone:
+		if len(parts) > 1 {
+			return NAT{}, fmt.Errorf("nat mode %s does not take an address", parts[0])
+		}
+		if parts[0] == "" {
+			return NAT{Mode: NATMasquerade}, nil
+		}
+		return NAT{Mode: parts[0]}, nil
+	case NATSNAT:
+		if len(parts) < 2 {
+			return NAT{}, fmt.Errorf("nat mode %s requires a source address, ex. snat:192.0.2.1", NATSNAT)
+		}
+		ip := net.ParseIP(parts[1])
+		if ip == nil {
+			return NAT{}, fmt.Errorf("parsing snat source address %q failed", parts[1])
+		}
+		// The rule is installed with iptables, which only handles IPv4.
+		if ip.To4() == nil {
+			return NAT{}, fmt.Errorf("snat source address %s is not an IPv4 address", parts[1])
+		}
+		return NAT{Mode: NATSNAT, Source: ip}, nil
+	}
+
+	return NAT{}, fmt.Errorf("unknown nat mode %q, must be one of %s, %s:<ip> or %s", s, NATMasquerade, NATSNAT, NATNone)
+}
+
+// String returns the NAT mode in the form accepted by ParseNAT.
+func (n NAT) String() string {
+	switch n.Mode {
+	case "":
+		return NATMasquerade
+	case NATSNAT:
+		return fmt.Sprintf("%s:%s", NATSNAT, n.Source)
+	}
+	return n.Mode
+}
@@ -0,0 +1,129 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/genuinetools/netns/kernel"
+)
+
+// Auto is the address of a bridge whose subnet is the first one of the
+// address pools that does not overlap with the host.
+const Auto = "auto"
+
+// DefaultAddressPools are the address pools used when none is given, the
+// same as the default pools of docker: 172.17.0.0/16 to 172.31.0.0/16 and
+// the /20 subnets of 192.168.0.0/16.
+var DefaultAddressPools = defaultAddressPools()
+
+func defaultAddressPools() []AddressPool {
+	var pools []AddressPool
+	for i := byte(17); i <= 31; i++ {
+		pools = append(pools, AddressPool{
+			Base: &net.IPNet{IP: net.IPv4(172, i, 0, 0).To4(), Mask: net.CIDRMask(16, 32)},
+			Size: 16,
+		})
+	}
+	return append(pools, AddressPool{
+		Base: &net.IPNet{IP: net.IPv4(192, 168, 0, 0).To4(), Mask: net.CIDRMask(16, 32)},
+		Size: 20,
+	})
+}
+
+// AddressPool is a range of IPv4 subnets of the same size a bridge subnet
+// can be picked from.
+type AddressPool struct {
+	// Base is the network the subnets are carved from.
+	Base *net.IPNet
+	// Size is the prefix length of the subnets.
+	Size int
+}
+
+// ParseAddressPool parses an address pool in the form of
+// "base=172.17.0.0/12,size=16".
+func ParseAddressPool(s string) (AddressPool, error) {
+	var p AddressPool
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("invalid address pool field %q, must be key=value", field)
+		}
+
+		switch kv[0] {
+		case "base":
+			_, base, err := net.ParseCIDR(kv[1])
+			if err != nil {
+				return p, fmt.Errorf("parsing base %s failed: %v", kv[1], err)
+			}
+			if base.IP.To4() == nil {
+				return p, fmt.Errorf("base %s is not an IPv4 network", kv[1])
+			}
+			base.IP = base.IP.To4()
+			p.Base = base
+		case "size":
+			size, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return p, fmt.Errorf("parsing size %s failed: %v", kv[1], err)
+			}
+			p.Size = size
+		default:
+			return p, fmt.Errorf("unknown address pool field %q", kv[0])
+		}
+	}
+
+	if p.Base == nil {
+		return p, fmt.Errorf("address pool %q has no base", s)
+	}
+	ones, _ := p.Base.Mask.Size()
+	if p.Size < ones || p.Size > 30 {
+		return p, fmt.Errorf("address pool size %d must be between the prefix length of the base %d and 30", p.Size, ones)
+	}
+
+	return p, nil
+}
+
+func (p AddressPool) String() string {
+	return fmt.Sprintf("base=%s,size=%d", p.Base.String(), p.Size)
+}
+
+// pickSubnet returns the address of the gateway, with the prefix length, of
+// the first subnet of the pools that does not overlap with the host.
+func pickSubnet(k kernel.Kernel, pools []AddressPool) (string, error) {
+	if len(pools) < 1 {
+		pools = DefaultAddressPools
+	}
+
+	networks, err := hostNetworks(k)
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range pools {
+		ones, _ := p.Base.Mask.Size()
+		mask := net.CIDRMask(p.Size, 32)
+		step := uint32(1) << uint(32-p.Size)
+		count := uint64(1) << uint(p.Size-ones)
+
+		ip := ipToUint32(p.Base.IP.Mask(p.Base.Mask))
+		for i := uint64(0); i < count; i, ip = i+1, ip+step {
+			subnet := &net.IPNet{IP: uint32ToIP(ip), Mask: mask}
+			if conflict(subnet, networks) == nil {
+				gateway := &net.IPNet{IP: uint32ToIP(ip + 1), Mask: mask}
+				return gateway.String(), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%w: every subnet of the address pools overlaps with a network of the host", ErrSubnetConflict)
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uint32ToIP(n uint32) net.IP {
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).To4()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/config"
+	"github.com/genuinetools/netns/network"
+)
+
+var (
+	// globalFlags are the names of the global flags, the ones that can be
+	// set in the environment.
+	globalFlags []string
+	// repeatedFlags are the flags that can be passed several times.
+	repeatedFlags = map[string]bool{
+		"address-pool": true,
+		"dns":          true,
+		"dns-opt":      true,
+		"dns-search":   true,
+		"icc-allow":    true,
+	}
+
+	// networkNames are the names of the networks the containers are
+	// attached to by default, from --network.
+	networkNames []string
+)
+
+// flagNames returns the names of the flags defined in fs.
+func flagNames(fs *flag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	return names
+}
+
+// loadConfig sets the flags that were not passed on the command line from
+// the environment and then from the config file of the first network, and
+// returns the config file.
+func loadConfig(fs *flag.FlagSet) (*config.File, error) {
+	if err := config.Apply(fs, config.Environment(globalFlags, repeatedFlags, os.Getenv)); err != nil {
+		return nil, fmt.Errorf("applying the environment failed: %v", err)
+	}
+
+	var (
+		f   *config.File
+		err error
+	)
+	if len(configFile) > 0 {
+		f, err = config.Load(configFile)
+	} else {
+		f, err = config.LoadDefault()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(networkName) < 1 {
+		networkName = f.NetworkName()
+	}
+	networkNames = splitNames(networkName)
+	if len(networkNames) > 0 {
+		netOpt.Network = networkNames[0]
+		netOpt.DefaultNetwork = netOpt.Network == f.NetworkName()
+	}
+	values, err := f.Values(netOpt.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.Apply(fs, values); err != nil {
+		return nil, fmt.Errorf("applying the config file failed: %v", err)
+	}
+	return f, nil
+}
+
+// loadNetworks returns the set of the networks of the config file. The
+// first network uses the global options, the others only take their
+// settings from the config file. When no network of the config file is
+// used the set only has the network of the global options, named after its
+// bridge.
+func loadNetworks(fs *flag.FlagSet, f *config.File) (*network.Set, error) {
+	primary := network.Member{
+		Name:   netOpt.Network,
+		Client: client,
+		Bridge: brOpt,
+	}
+	if isSet(fs, "iface") {
+		primary.Interface = netOpt.ContainerInterface
+	}
+	if len(primary.Name) < 1 {
+		primary.Name = brOpt.Name
+		return network.NewSet([]string{primary.Name}, defaultRoute, primary)
+	}
+
+	members := []network.Member{primary}
+	for _, name := range f.Names() {
+		if name == primary.Name {
+			continue
+		}
+		m, err := configNetwork(f, name)
+		if err != nil {
+			return nil, fmt.Errorf("setting up network %s failed: %v", name, err)
+		}
+		members = append(members, m)
+	}
+
+	return network.NewSet(networkNames, defaultRoute, members...)
+}
+
+// configNetwork returns the member of the set for a network of the config
+// file.
+func configNetwork(f *config.File, name string) (network.Member, error) {
+	var (
+		opt   network.Opt
+		brOpt bridge.Opt
+		icc   bool
+		nat   string
+	)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	registerNetworkFlags(fs, &opt, &brOpt, &icc, &nat)
+
+	values, err := f.Values(name)
+	if err != nil {
+		return network.Member{}, err
+	}
+	if err := config.Apply(fs, values); err != nil {
+		return network.Member{}, err
+	}
+
+	opt.Network = name
+	opt.DefaultNetwork = name == f.NetworkName()
+	opt.StateDir = netOpt.StateDir
+	opt.BridgeName = brOpt.Name
+	brOpt.DisableICC = !icc
+	brOpt.NAT, err = bridge.ParseNAT(nat)
+	if err != nil {
+		return network.Member{}, err
+	}
+
+	c, err := network.New(opt)
+	if err != nil {
+		return network.Member{}, err
+	}
+
+	m := network.Member{
+		Name:   name,
+		Client: c,
+		Bridge: brOpt,
+	}
+	if isSet(fs, "iface") {
+		m.Interface = opt.ContainerInterface
+	}
+	return m, nil
+}
+
+// isSet returns whether the flag was set on the command line, in the
+// environment or in the config file.
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitNames splits a list of names separated by commas.
+func splitNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
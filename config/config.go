@@ -0,0 +1,275 @@
+// Package config reads the settings of netns from a configuration file and
+// the environment and applies them to the command line flags.
+//
+// The settings are applied in this order of precedence, the first one set
+// wins:
+//
+//  1. the command line flags
+//  2. the NETNS_* environment variables
+//  3. the configuration file
+//  4. the defaults of the flags
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultPath is the configuration file read when none is given. It is
+	// not an error for it to not exist.
+	DefaultPath = "/etc/netns/config.json"
+	// EnvPrefix is the prefix of the environment variables setting flags.
+	EnvPrefix = "NETNS_"
+)
+
+// File is the configuration file.
+type File struct {
+	StateDir  string `json:"state_dir,omitempty"`
+	Socket    string `json:"socket,omitempty"`
+	Timeout   string `json:"timeout,omitempty"`
+	Debug     *bool  `json:"debug,omitempty"`
+	LogFile   string `json:"log_file,omitempty"`
+	LogFormat string `json:"log_format,omitempty"`
+	IPFile    string `json:"ipfile,omitempty"`
+
+	// DefaultNetwork is the network used when none is given. It can be
+	// omitted when there is a single network.
+	DefaultNetwork string `json:"default_network,omitempty"`
+	// Networks are the networks by name.
+	Networks map[string]Network `json:"networks,omitempty"`
+}
+
+// Network holds the settings of a named network.
+type Network struct {
+	Bridge    string   `json:"bridge,omitempty"`
+	MTU       int      `json:"mtu,omitempty"`
+	Interface string   `json:"interface,omitempty"`
+	ICC       *bool    `json:"icc,omitempty"`
+	ICCAllow  []string `json:"icc_allow,omitempty"`
+	IPForward *bool    `json:"ip_forward,omitempty"`
+	NAT       string   `json:"nat,omitempty"`
+
+	// PortPrefix is the prefix of the names of the veths on the host, it
+	// defaults to netnsv<n> with n the index of the network in the sorted
+	// names so the veths of the networks do not collide.
+	PortPrefix string `json:"port_prefix,omitempty"`
+
+	IPAM IPAM `json:"ipam,omitempty"`
+	DNS  DNS  `json:"dns,omitempty"`
+
+	Egress  string `json:"egress,omitempty"`
+	Ingress string `json:"ingress,omitempty"`
+	Netem   string `json:"netem,omitempty"`
+
+	// TxQueueLen and Offloads are the settings of the veth pairs, the veths
+	// get the MTU of the bridge.
+	TxQueueLen int    `json:"txqueuelen,omitempty"`
+	Offloads   string `json:"offloads,omitempty"`
+}
+
+// IPAM holds the address management settings of a network.
+type IPAM struct {
+	// Subnet is the address of the bridge with the prefix length of the
+	// subnet the containers get their address from, ex. 172.19.0.1/16, or
+	// auto to pick one of the pools that is free on the host.
+	Subnet string `json:"subnet,omitempty"`
+	// Pools are the subnets to pick from, ex. base=172.17.0.0/12,size=16.
+	Pools []string `json:"pools,omitempty"`
+}
+
+// DNS holds the name resolution settings of a network.
+type DNS struct {
+	Resolv      string   `json:"resolv,omitempty"`
+	Nameservers []string `json:"nameservers,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Load reads the configuration file at path.
+func Load(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file %s failed: %v", path, err)
+	}
+
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parsing config file %s failed: %v", path, err)
+	}
+
+	return &f, nil
+}
+
+// LoadDefault reads the configuration file at DefaultPath, it returns an
+// empty configuration if it does not exist.
+func LoadDefault() (*File, error) {
+	if _, err := os.Stat(DefaultPath); os.IsNotExist(err) {
+		return &File{}, nil
+	}
+	return Load(DefaultPath)
+}
+
+// NetworkName returns the name of the network to use when none is given.
+func (f *File) NetworkName() string {
+	if len(f.DefaultNetwork) > 0 || len(f.Networks) != 1 {
+		return f.DefaultNetwork
+	}
+	for name := range f.Networks {
+		return name
+	}
+	return ""
+}
+
+// Values returns the values of the flags set by the configuration for the
+// network with the given name. An empty name only returns the global
+// settings.
+func (f *File) Values(network string) (map[string][]string, error) {
+	v := map[string][]string{}
+	add := func(name, value string) {
+		if len(value) > 0 {
+			v[name] = append(v[name], value)
+		}
+	}
+	addBool := func(name string, value *bool) {
+		if value != nil {
+			add(name, strconv.FormatBool(*value))
+		}
+	}
+
+	add("state-dir", f.StateDir)
+	add("socket", f.Socket)
+	add("timeout", f.Timeout)
+	addBool("d", f.Debug)
+	add("log-file", f.LogFile)
+	add("log-format", f.LogFormat)
+	add("ipfile", f.IPFile)
+
+	if len(network) < 1 {
+		return v, nil
+	}
+
+	n, ok := f.Networks[network]
+	if !ok {
+		return nil, fmt.Errorf("network %s is not defined in the config file, must be one of %s", network, strings.Join(f.Names(), ", "))
+	}
+
+	// The bridge is named after the network by default.
+	bridge := n.Bridge
+	if len(bridge) < 1 {
+		bridge = network
+	}
+	add("bridge", bridge)
+	if n.MTU > 0 {
+		add("mtu", strconv.Itoa(n.MTU))
+	}
+	add("iface", n.Interface)
+	portPrefix := n.PortPrefix
+	if len(portPrefix) < 1 {
+		portPrefix = fmt.Sprintf("netnsv%d", sort.SearchStrings(f.Names(), network))
+	}
+	add("port-prefix", portPrefix)
+	addBool("icc", n.ICC)
+	for _, r := range n.ICCAllow {
+		add("icc-allow", r)
+	}
+	addBool("ip-forward", n.IPForward)
+	add("nat", n.NAT)
+
+	add("ip", n.IPAM.Subnet)
+	for _, p := range n.IPAM.Pools {
+		add("address-pool", p)
+	}
+
+	add("resolv", n.DNS.Resolv)
+	for _, s := range n.DNS.Nameservers {
+		add("dns", s)
+	}
+	for _, s := range n.DNS.Search {
+		add("dns-search", s)
+	}
+	for _, s := range n.DNS.Options {
+		add("dns-opt", s)
+	}
+
+	add("egress", n.Egress)
+	add("ingress", n.Ingress)
+	add("netem", n.Netem)
+
+	if n.TxQueueLen > 0 {
+		add("txqueuelen", strconv.Itoa(n.TxQueueLen))
+	}
+	add("offloads", n.Offloads)
+
+	return v, nil
+}
+
+// Names returns the names of the networks, sorted.
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Networks))
+	for name := range f.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EnvName returns the name of the environment variable for a flag, ex.
+// NETNS_STATE_DIR for --state-dir.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Environment returns the values of the flags with the given names that are
+// set in the environment looked up with getenv. The flags that can be
+// repeated take several values separated by spaces.
+func Environment(names []string, repeated map[string]bool, getenv func(string) string) map[string][]string {
+	v := map[string][]string{}
+	for _, name := range names {
+		value := getenv(EnvName(name))
+		if len(value) < 1 {
+			continue
+		}
+		if repeated[name] {
+			v[name] = strings.Fields(value)
+			continue
+		}
+		v[name] = []string{value}
+	}
+	return v
+}
+
+// Apply sets the flags that have not been set yet to the values, all the
+// values of a flag are set in order. The flags that are not defined are
+// ignored.
+func Apply(fs *flag.FlagSet, values map[string][]string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if set[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		for _, value := range values[name] {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for flag -%s: %v", value, name, err)
+			}
+		}
+	}
+
+	return nil
+}
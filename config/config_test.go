@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+const testConfig = `{
+	"state_dir": "/var/lib/netns",
+	"log_format": "json",
+	"default_network": "backend",
+	"networks": {
+		"frontend": {
+			"ipam": {"subnet": "172.20.0.1/16"}
+		},
+		"backend": {
+			"bridge": "netns1",
+			"mtu": 9000,
+			"icc": false,
+			"ipam": {"subnet": "172.21.0.1/16"},
+			"dns": {"nameservers": ["1.1.1.1", "8.8.8.8"]}
+		}
+	}
+}`
+
+func TestPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		stateDir, bridge, ip, logFormat string
+		mtu                             int
+		icc                             bool
+		dns                             stringSlice
+	)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&stateDir, "state-dir", "/run/netns", "")
+	fs.StringVar(&bridge, "bridge", "netns0", "")
+	fs.StringVar(&ip, "ip", "172.19.0.1/16", "")
+	fs.StringVar(&logFormat, "log-format", "text", "")
+	fs.IntVar(&mtu, "mtu", 1500, "")
+	fs.BoolVar(&icc, "icc", true, "")
+	fs.Var(&dns, "dns", "")
+
+	// The command line sets the bridge.
+	if err := fs.Parse([]string{"-bridge", "br-cli"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment sets the bridge and the state directory.
+	env := map[string]string{
+		"NETNS_BRIDGE":    "br-env",
+		"NETNS_STATE_DIR": "/env/netns",
+		"NETNS_DNS":       "9.9.9.9 149.112.112.112",
+	}
+	if err := Apply(fs, Environment([]string{"state-dir", "bridge", "dns"}, map[string]bool{"dns": true}, func(k string) string { return env[k] })); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config file sets everything.
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := f.Values(f.NetworkName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(fs, values); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config file fills in the flags set nowhere else.
+	if bridge != "br-cli" {
+		t.Fatalf("expected the bridge of the command line, got %s", bridge)
+	}
+	if stateDir != "/env/netns" {
+		t.Fatalf("expected the state directory of the environment, got %s", stateDir)
+	}
+	if !reflect.DeepEqual([]string(dns), []string{"9.9.9.9", "149.112.112.112"}) {
+		t.Fatalf("expected the nameservers of the environment, got %v", dns)
+	}
+	if ip != "172.21.0.1/16" || mtu != 9000 || icc || logFormat != "json" {
+		t.Fatalf("expected the settings of the backend network, got ip %s, mtu %d, icc %t, log format %s", ip, mtu, icc, logFormat)
+	}
+}
+
+func TestValues(t *testing.T) {
+	var f File
+	if err := json.Unmarshal([]byte(testConfig), &f); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := f.Values("frontend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"state-dir":   {"/var/lib/netns"},
+		"log-format":  {"json"},
+		"bridge":      {"frontend"},
+		"port-prefix": {"netnsv1"},
+		"ip":          {"172.20.0.1/16"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected %v got %v", expected, values)
+	}
+
+	if _, err := f.Values("database"); err == nil {
+		t.Fatal("expected an error for an unknown network")
+	}
+}
+
+func TestNetworkName(t *testing.T) {
+	f := File{Networks: map[string]Network{"frontend": {}}}
+	if name := f.NetworkName(); name != "frontend" {
+		t.Fatalf("expected the single network to be the default, got %q", name)
+	}
+
+	f.Networks["backend"] = Network{}
+	if name := f.NetworkName(); name != "" {
+		t.Fatalf("expected no default network, got %q", name)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if name := EnvName("dns-search"); name != "NETNS_DNS_SEARCH" {
+		t.Fatalf("expected NETNS_DNS_SEARCH got %s", name)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/genuinetools/netns/daemon"
+	"github.com/sirupsen/logrus"
+)
+
+const daemonHelp = `Run a daemon serving the networks on a unix socket.
+
+The daemon handles the requests one at a time, so the hooks, ls, inspect and
+delete commands talk to it instead of contending on the database. They use
+the database directly when the daemon is not running. While the daemon runs
+the networks are created with its options, not the options of the hooks.
+
+The metrics are served in the Prometheus text format on /metrics, on the
+socket and on --metrics-listen if set.`
+
+func (cmd *daemonCommand) Name() string      { return "daemon" }
+func (cmd *daemonCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *daemonCommand) ShortHelp() string { return `Run a daemon serving the networks.` }
+func (cmd *daemonCommand) LongHelp() string  { return daemonHelp }
+func (cmd *daemonCommand) Hidden() bool      { return false }
+
+func (cmd *daemonCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.metricsListen, "metrics-listen", "", "tcp address to also serve the metrics on, e.g. :9453 (default: only on the socket)")
+	fs.DurationVar(&cmd.gcInterval, "gc-interval", 0, "interval between releases of the networks of the containers that are gone (default: never)")
+}
+
+type daemonCommand struct {
+	metricsListen string
+	gcInterval    time.Duration
+}
+
+func (cmd *daemonCommand) Run(ctx context.Context, args []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Stop on a signal.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	if cmd.gcInterval < 0 {
+		return fmt.Errorf("gc interval must be positive, got %s", cmd.gcInterval)
+	}
+
+	s := daemon.NewServer(socket, networks)
+	s.MetricsAddr = cmd.metricsListen
+	s.GCInterval = cmd.gcInterval
+	s.Timeout = timeout
+
+	logrus.Infof("serving the networks on %s", socket)
+	return s.ListenAndServe(ctx)
+}
@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/genuinetools/netns/network"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// ErrUnavailable holds the error for when the daemon is not running.
+var ErrUnavailable = errors.New("daemon is not running")
+
+// RemoteError is the error of a request the daemon failed. It matches the
+// errors of the network package and context.DeadlineExceeded with
+// errors.Is.
+type RemoteError struct {
+	Code    string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Is makes the error match the error of its code.
+func (e *RemoteError) Is(target error) bool {
+	err := codeError(e.Code)
+	return err != nil && err == target
+}
+
+// Client talks to the daemon over its unix socket.
+type Client struct {
+	socket string
+	http   *http.Client
+}
+
+// NewClient returns a client for the daemon listening on socket.
+func NewClient(socket string) *Client {
+	return &Client{
+		socket: socket,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Create creates the network for the container of the hook and returns its
+// ip address.
+func (c *Client) Create(ctx context.Context, hook configs.HookState, staticip string) (net.IP, error) {
+	var resp CreateResponse
+	if err := c.do(ctx, http.MethodPost, networksPath, CreateRequest{
+		Hook:     hook,
+		StaticIP: staticip,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.IP, nil
+}
+
+// Delete releases the network of the container identified by its container
+// ID, PID or IP address.
+func (c *Client) Delete(ctx context.Context, target string) error {
+	return c.do(ctx, http.MethodDelete, networksPath+"/"+url.PathEscape(target), nil, nil)
+}
+
+// List returns the networks.
+func (c *Client) List(ctx context.Context) ([]network.Network, error) {
+	networks := []network.Network{}
+	if err := c.do(ctx, http.MethodGet, networksPath, nil, &networks); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+// Inspect returns the network of the container identified by its container
+// ID, PID or IP address.
+func (c *Client) Inspect(ctx context.Context, target string) (*network.Inspection, error) {
+	var i network.Inspection
+	if err := c.do(ctx, http.MethodGet, networksPath+"/"+url.PathEscape(target), nil, &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// GC releases the networks of the containers whose process is gone and
+// returns their allocations.
+func (c *Client) GC(ctx context.Context) ([]network.Allocation, error) {
+	reclaimed := []network.Allocation{}
+	if err := c.do(ctx, http.MethodPost, gcPath, nil, &reclaimed); err != nil {
+		return nil, err
+	}
+	return reclaimed, nil
+}
+
+// Metrics returns the metrics of the daemon in the Prometheus text format.
+func (c *Client) Metrics(ctx context.Context) ([]byte, error) {
+	resp, err := c.send(ctx, http.MethodGet, metricsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading metrics failed: %v", err)
+	}
+	return b, nil
+}
+
+// do sends a request with body encoded as JSON and decodes the response in
+// v. It returns ErrUnavailable if the daemon could not be reached.
+func (c *Client) do(ctx context.Context, method, path string, body, v interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding response failed: %v", err)
+	}
+	return nil
+}
+
+// send sends a request with body encoded as JSON. The response is returned
+// only if the request succeeded.
+func (c *Client) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encoding request failed: %v", err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	// The host is ignored since we always dial the socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://netns"+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// A dial failing because the context is done does not mean the
+		// daemon is not running.
+		var opErr *net.OpError
+		if ctx.Err() == nil && errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, ErrUnavailable
+		}
+		return nil, fmt.Errorf("%s %s on %s failed: %w", method, path, c.socket, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || len(e.Error) < 1 {
+			return nil, fmt.Errorf("%s %s on %s failed: %s", method, path, c.socket, resp.Status)
+		}
+		return nil, &RemoteError{Code: e.Code, Message: e.Error}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,211 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/genuinetools/netns/network"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/vishvananda/netlink"
+)
+
+type fakeBackend struct {
+	networks map[string]network.Network
+}
+
+func (f *fakeBackend) Create(ctx context.Context, hook configs.HookState, staticip string) (net.IP, error) {
+	ip := net.ParseIP(staticip)
+	if ip == nil {
+		ip = net.ParseIP("172.19.0.2")
+	}
+	f.networks[hook.ID] = network.Network{
+		VethPair:    &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "netnsv0-1234"}},
+		IP:          ip,
+		PID:         hook.Pid,
+		ContainerID: hook.ID,
+	}
+	return ip, nil
+}
+
+func (f *fakeBackend) Delete(ctx context.Context, target string) error {
+	if _, ok := f.networks[target]; !ok {
+		return fmt.Errorf("%w for %s", network.ErrNotFound, target)
+	}
+	delete(f.networks, target)
+	return nil
+}
+
+func (f *fakeBackend) List(ctx context.Context) ([]network.Network, error) {
+	networks := []network.Network{}
+	for _, n := range f.networks {
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+func (f *fakeBackend) Inspect(ctx context.Context, target string) (*network.Inspection, error) {
+	n, ok := f.networks[target]
+	if !ok {
+		return nil, errors.New("no network found for " + target)
+	}
+	return &network.Inspection{IP: n.IP, Status: "running"}, nil
+}
+
+// GC releases the networks with a pid of 0.
+func (f *fakeBackend) GC(ctx context.Context) ([]network.Allocation, error) {
+	reclaimed := []network.Allocation{}
+	for id, n := range f.networks {
+		if n.PID == 0 {
+			reclaimed = append(reclaimed, network.Allocation{IP: n.IP, ContainerID: id})
+			delete(f.networks, id)
+		}
+	}
+	return reclaimed, nil
+}
+
+func (f *fakeBackend) UpdateMetrics(ctx context.Context) error {
+	return nil
+}
+
+func startServer(t *testing.T) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "netns-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newServer(filepath.Join(dir, DefaultSocket), &fakeBackend{networks: map[string]network.Network{}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServe(ctx) }()
+
+	// Wait for the socket.
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(s.Socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return NewClient(s.Socket), func() {
+		cancel()
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestClientServer(t *testing.T) {
+	c, stop := startServer(t)
+	defer stop()
+
+	ctx := context.Background()
+
+	ip, err := c.Create(ctx, configs.HookState{ID: "web", Pid: 1234}, "172.19.0.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("172.19.0.9")) {
+		t.Fatalf("expected ip 172.19.0.9 got %s", ip)
+	}
+
+	networks, err := c.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 1 || networks[0].ContainerID != "web" || networks[0].Veth() != "netnsv0-1234" {
+		t.Fatalf("expected the network of web got %#v", networks)
+	}
+
+	i, err := c.Inspect(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !i.IP.Equal(ip) || i.Status != "running" {
+		t.Fatalf("expected a running network with ip %s got %#v", ip, i)
+	}
+
+	if err := c.Delete(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Delete(ctx, "web")
+	if err == nil || err.Error() != "no network found for web" {
+		t.Fatalf("expected the error of the backend got %v", err)
+	}
+	if !errors.Is(err, network.ErrNotFound) {
+		t.Fatalf("expected the error to match %v", network.ErrNotFound)
+	}
+}
+
+func TestClientCreateWithoutPid(t *testing.T) {
+	c, stop := startServer(t)
+	defer stop()
+
+	if _, err := c.Create(context.Background(), configs.HookState{ID: "web"}, ""); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestClientGC(t *testing.T) {
+	c, stop := startServer(t)
+	defer stop()
+
+	reclaimed, err := c.GC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reclaimed) != 0 {
+		t.Fatalf("expected no allocations to be released got %#v", reclaimed)
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	c, stop := startServer(t)
+	defer stop()
+
+	b, err := c.Metrics(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "# TYPE netns_pool_used gauge") {
+		t.Fatalf("expected the pool metrics got:\n%s", b)
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	s := newServer("", &fakeBackend{networks: map[string]network.Network{}})
+	s.Timeout = 50 * time.Millisecond
+
+	_, release, err := s.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A request waiting on a hung one gives up after the timeout.
+	if _, _, err := s.acquire(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v got %v", context.DeadlineExceeded, err)
+	}
+
+	release()
+	_, release, err = s.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestClientUnavailable(t *testing.T) {
+	c := NewClient(filepath.Join(os.TempDir(), "netns-does-not-exist.sock"))
+	if _, err := c.List(context.Background()); err != ErrUnavailable {
+		t.Fatalf("expected %v got %v", ErrUnavailable, err)
+	}
+}
@@ -0,0 +1,431 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/genuinetools/netns/metrics"
+	"github.com/genuinetools/netns/network"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultSocket is the name of the socket in the state directory.
+	DefaultSocket = "netns.sock"
+
+	// networksPath is the path of the networks resource.
+	networksPath = "/v1/networks"
+	// gcPath is the path releasing the networks of the containers that are
+	// gone.
+	gcPath = "/v1/gc"
+	// metricsPath is the path of the metrics in the Prometheus text format.
+	metricsPath = "/metrics"
+	// shutdownTimeout is the time we wait for the requests in flight when
+	// stopping.
+	shutdownTimeout = 10 * time.Second
+)
+
+// backend is the part of the network client served by the daemon.
+type backend interface {
+	Create(ctx context.Context, hook configs.HookState, staticip string) (net.IP, error)
+	Delete(ctx context.Context, target string) error
+	List(ctx context.Context) ([]network.Network, error)
+	Inspect(ctx context.Context, target string) (*network.Inspection, error)
+	GC(ctx context.Context) ([]network.Allocation, error)
+	UpdateMetrics(ctx context.Context) error
+}
+
+// networkBackend serves a set of networks.
+type networkBackend struct {
+	networks *network.Set
+}
+
+func (b networkBackend) Create(ctx context.Context, hook configs.HookState, staticip string) (net.IP, error) {
+	return b.networks.Create(ctx, hook, staticip)
+}
+
+func (b networkBackend) Delete(ctx context.Context, target string) error {
+	return b.networks.Delete(ctx, target)
+}
+
+func (b networkBackend) List(ctx context.Context) ([]network.Network, error) {
+	return b.networks.List(ctx)
+}
+
+func (b networkBackend) Inspect(ctx context.Context, target string) (*network.Inspection, error) {
+	return b.networks.Inspect(ctx, target)
+}
+
+func (b networkBackend) GC(ctx context.Context) ([]network.Allocation, error) {
+	return b.networks.GC(ctx)
+}
+
+func (b networkBackend) UpdateMetrics(ctx context.Context) error {
+	return b.networks.UpdatePoolMetrics(ctx)
+}
+
+// CreateRequest is the body of a request creating a network.
+type CreateRequest struct {
+	Hook     configs.HookState `json:"hook"`
+	StaticIP string            `json:"static_ip,omitempty"`
+}
+
+// CreateResponse is the body of the response to a request creating a
+// network.
+type CreateResponse struct {
+	IP net.IP `json:"ip"`
+}
+
+// errorResponse is the body of the response to a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+	// Code is the kind of the error, see network.ErrorCode.
+	Code string `json:"code,omitempty"`
+}
+
+// timeoutCode is the code of the errors of the requests that ran out of
+// time.
+const timeoutCode = "timeout"
+
+// errorCode returns the code of the kind of the error.
+func errorCode(err error) string {
+	if code := network.ErrorCode(err); len(code) > 0 {
+		return code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return timeoutCode
+	}
+	return ""
+}
+
+// codeError returns the error for a code returned by errorCode.
+func codeError(code string) error {
+	if code == timeoutCode {
+		return context.DeadlineExceeded
+	}
+	return network.CodeError(code)
+}
+
+// Server serves the networks over http on a unix socket. The requests are
+// handled one at a time so the hooks do not contend on the database.
+type Server struct {
+	// Socket is the path of the unix socket to listen on.
+	Socket string
+	// MetricsAddr is the tcp address the metrics are also served on, if
+	// set.
+	MetricsAddr string
+	// GCInterval is the interval between the releases of the networks of
+	// the containers that are gone. The networks are not released
+	// periodically if it is zero.
+	GCInterval time.Duration
+	// Timeout is the maximum duration of each request, including the time
+	// waiting for the other requests. There is no limit if it is zero.
+	Timeout time.Duration
+
+	backend backend
+	// lock serializes the requests, it is a channel so waiting for it can
+	// be canceled.
+	lock chan struct{}
+}
+
+// NewServer returns a server for the set of networks.
+func NewServer(socket string, networks *network.Set) *Server {
+	return newServer(socket, networkBackend{networks: networks})
+}
+
+func newServer(socket string, b backend) *Server {
+	return &Server{
+		Socket:  socket,
+		backend: b,
+		lock:    make(chan struct{}, 1),
+	}
+}
+
+// acquire waits for the other requests to finish. It returns a context
+// bounded by the timeout of the server and a function releasing the lock and
+// the context.
+func (s *Server) acquire(ctx context.Context) (context.Context, func(), error) {
+	cancel := func() {}
+	if s.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+	}
+
+	select {
+	case s.lock <- struct{}{}:
+	case <-ctx.Done():
+		cancel()
+		return nil, nil, fmt.Errorf("waiting for the other requests failed: %w", ctx.Err())
+	}
+
+	return ctx, func() {
+		<-s.lock
+		cancel()
+	}, nil
+}
+
+// ListenAndServe listens on the socket and serves the requests until the
+// context is canceled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	l, err := listen(s.Socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(s.Socket)
+
+	srv := &http.Server{Handler: s.Handler()}
+	servers := []*http.Server{srv}
+
+	if len(s.MetricsAddr) > 0 {
+		ml, err := net.Listen("tcp", s.MetricsAddr)
+		if err != nil {
+			return fmt.Errorf("listening on %s failed: %v", s.MetricsAddr, err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc(metricsPath, s.handleMetrics)
+		msrv := &http.Server{Handler: mux}
+		servers = append(servers, msrv)
+
+		go func() {
+			if err := msrv.Serve(ml); err != nil && err != http.ErrServerClosed {
+				logrus.Errorf("[daemon] serving metrics on %s failed: %v", s.MetricsAddr, err)
+			}
+		}()
+	}
+
+	if s.GCInterval > 0 {
+		go s.collect(ctx)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, srv := range servers {
+			srv.Shutdown(ctx)
+		}
+	}()
+
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("serving on %s failed: %v", s.Socket, err)
+	}
+	return nil
+}
+
+// gc releases the networks of the containers that are gone.
+func (s *Server) gc(ctx context.Context) ([]network.Allocation, error) {
+	ctx, release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return s.backend.GC(ctx)
+}
+
+// collect releases the networks of the containers that are gone every
+// GCInterval until the context is canceled.
+func (s *Server) collect(ctx context.Context) {
+	ticker := time.NewTicker(s.GCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reclaimed, err := s.gc(ctx)
+			if err != nil {
+				logrus.Errorf("[daemon] gc failed: %v", err)
+				continue
+			}
+			for _, a := range reclaimed {
+				logrus.Infof("[daemon] released ip %s of pid %d", a.IP.String(), a.PID)
+			}
+		}
+	}
+}
+
+// listen listens on a unix socket only accessible to the owner, removing the
+// socket left behind by a daemon that is not running anymore.
+func listen(socket string) (net.Listener, error) {
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", socket)
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, fmt.Errorf("removing stale socket %s failed: %v", socket, err)
+		}
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s failed: %v", socket, err)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("setting permissions of %s failed: %v", socket, err)
+	}
+
+	return l, nil
+}
+
+// Handler returns the http handler for the api.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(networksPath, s.handleNetworks)
+	mux.HandleFunc(networksPath+"/", s.handleNetwork)
+	mux.HandleFunc(gcPath, s.handleGC)
+	mux.HandleFunc(metricsPath, s.handleMetrics)
+	return mux
+}
+
+func (s *Server) handleNetworks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, release, err := s.acquire(r.Context())
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		networks, err := s.backend.List(ctx)
+		release()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, networks)
+	case http.MethodPost:
+		var req CreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request failed: %v", err))
+			return
+		}
+		if req.Hook.Pid < 1 {
+			writeError(w, http.StatusBadRequest, errors.New("the hook state must have a pid"))
+			return
+		}
+
+		ctx, release, err := s.acquire(r.Context())
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		ip, err := s.backend.Create(ctx, req.Hook, req.StaticIP)
+		release()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, CreateResponse{IP: ip})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleNetwork(w http.ResponseWriter, r *http.Request) {
+	target := strings.TrimPrefix(r.URL.Path, networksPath+"/")
+	if len(target) < 1 || strings.Contains(target, "/") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ctx, release, err := s.acquire(r.Context())
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		i, err := s.backend.Inspect(ctx, target)
+		release()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, i)
+	case http.MethodDelete:
+		ctx, release, err := s.acquire(r.Context())
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		err = s.backend.Delete(ctx, target)
+		release()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleGC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	reclaimed, err := s.gc(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reclaimed)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	// Refresh the pool gauges before they are scraped.
+	if ctx, release, err := s.acquire(r.Context()); err != nil {
+		logrus.Warnf("[daemon] updating the pool metrics failed: %v", err)
+	} else {
+		if err := s.backend.UpdateMetrics(ctx); err != nil {
+			logrus.Warnf("[daemon] updating the pool metrics failed: %v", err)
+		}
+		release()
+	}
+
+	metrics.Default.Handler().ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Debugf("[daemon] writing response failed: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	code := errorCode(err)
+
+	// Give the failures of the backend a more precise status.
+	if status == http.StatusInternalServerError {
+		switch code {
+		case "not_found":
+			status = http.StatusNotFound
+		case "ip_conflict", "link_exists", "subnet_conflict":
+			status = http.StatusConflict
+		case "database_locked", timeoutCode:
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error(), Code: code})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+const deleteHelp = `Release the network of a container.
+
+The container is identified by its container ID, PID or IP address. Without
+an argument the hook state is read from stdin, so this can be used as a
+poststop hook.`
+
+func (cmd *deleteCommand) Name() string      { return "delete" }
+func (cmd *deleteCommand) Args() string      { return "[<container-id|pid|ip>]" }
+func (cmd *deleteCommand) ShortHelp() string { return `Release the network of a container.` }
+func (cmd *deleteCommand) LongHelp() string  { return deleteHelp }
+func (cmd *deleteCommand) Hidden() bool      { return false }
+
+func (cmd *deleteCommand) Register(fs *flag.FlagSet) {}
+
+type deleteCommand struct{}
+
+func (cmd *deleteCommand) Run(ctx context.Context, args []string) error {
+	var target string
+	if len(args) > 0 {
+		target = args[0]
+	} else {
+		hook, err := readHookData()
+		if err != nil {
+			return err
+		}
+		target = hook.ID
+	}
+	if len(target) < 1 {
+		return errors.New("must pass a container id, pid or ip")
+	}
+
+	if err := deleteNetwork(ctx, target); err != nil {
+		return err
+	}
+	fmt.Printf("deleted network for %s\n", target)
+	return nil
+}
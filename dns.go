@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/dns"
+	"github.com/genuinetools/netns/netutils"
+	"github.com/genuinetools/netns/resolvconf"
+	"github.com/sirupsen/logrus"
+)
+
+const dnsHelp = `Run a DNS server on the bridge resolving the container names.
+
+The server answers A, AAAA and PTR queries for the container hostnames and
+IDs and forwards everything else to the upstream nameservers of the host.`
+
+func (cmd *dnsCommand) Name() string      { return "dns" }
+func (cmd *dnsCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *dnsCommand) ShortHelp() string { return `Run a DNS server resolving the container names.` }
+func (cmd *dnsCommand) LongHelp() string  { return dnsHelp }
+func (cmd *dnsCommand) Hidden() bool      { return false }
+
+func (cmd *dnsCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.listen, "listen", "", "address to listen on (default: the bridge ip on port 53)")
+	fs.StringVar(&cmd.domain, "domain", "", "domain to also serve the container names under")
+	fs.Var(&cmd.upstreams, "upstream", "upstream nameserver, can be repeated, defaults to the upstream nameservers of the host")
+	fs.DurationVar(&cmd.refresh, "refresh", 2*time.Second, "interval for refreshing the records from the allocations")
+}
+
+type dnsCommand struct {
+	listen    string
+	domain    string
+	upstreams stringSlice
+	refresh   time.Duration
+}
+
+func (cmd *dnsCommand) Run(ctx context.Context, args []string) error {
+	// Initialize the bridge so we can listen on its address.
+	if _, err := bridge.Init(brOpt); err != nil {
+		return err
+	}
+
+	addr := cmd.listen
+	if len(addr) < 1 {
+		brNet, err := netutils.GetInterfaceAddr(brOpt.Name)
+		if err != nil {
+			return err
+		}
+		addr = net.JoinHostPort(brNet.IP.String(), "53")
+	}
+
+	upstreams := []string(cmd.upstreams)
+	if len(upstreams) < 1 {
+		conf, err := resolvconf.Host()
+		if err != nil {
+			return err
+		}
+		upstreams = conf.Nameservers
+	}
+	for i, u := range upstreams {
+		if _, _, err := net.SplitHostPort(u); err != nil {
+			upstreams[i] = net.JoinHostPort(u, "53")
+		}
+	}
+
+	s := &dns.Server{
+		Addr:      addr,
+		Domain:    cmd.domain,
+		TTL:       dns.DefaultTTL,
+		Upstreams: upstreams,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Stop on a signal.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	// Keep the records in sync with the allocations.
+	refreshRecords(ctx, s)
+	go func() {
+		ticker := time.NewTicker(cmd.refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshRecords(ctx, s)
+			}
+		}
+	}()
+
+	logrus.Infof("serving dns on %s, forwarding to %v", addr, upstreams)
+	return s.ListenAndServe(ctx)
+}
+
+// refreshRecords replaces the records of the dns server with the current
+// allocations.
+func refreshRecords(ctx context.Context, s *dns.Server) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	allocations, err := client.Allocations(ctx)
+	if err != nil {
+		logrus.Warnf("refreshing dns records failed: %v", err)
+		return
+	}
+
+	records := make([]dns.Record, 0, len(allocations))
+	for _, a := range allocations {
+		records = append(records, dns.Record{
+			Names: a.Names(),
+			IP:    a.IP,
+		})
+	}
+	s.SetRecords(records)
+}
@@ -0,0 +1,218 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	headerLen = 12
+
+	typeA    = 1
+	typePTR  = 12
+	typeAAAA = 28
+	typeANY  = 255
+
+	classINET = 1
+
+	rcodeSuccess  = 0
+	rcodeFormat   = 1
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+
+	opcodeQuery = 0
+
+	flagQR = 1 << 15
+	flagAA = 1 << 10
+	flagTC = 1 << 9
+	flagRD = 1 << 8
+	flagRA = 1 << 7
+)
+
+var (
+	errShortMessage = errors.New("dns message too short")
+	errPointerLoop  = errors.New("too many compression pointers in dns name")
+)
+
+// question is the first question of a dns message.
+type question struct {
+	id     uint16
+	flags  uint16
+	name   string
+	qtype  uint16
+	qclass uint16
+	// end is the offset of the end of the question in the message.
+	end int
+}
+
+// opcode returns the opcode from the flags of the message.
+func (q question) opcode() uint16 {
+	return (q.flags >> 11) & 0xf
+}
+
+// parseQuestion parses the header and the first question of a message.
+func parseQuestion(msg []byte) (question, error) {
+	var q question
+	if len(msg) < headerLen {
+		return q, errShortMessage
+	}
+
+	q.id = binary.BigEndian.Uint16(msg[0:2])
+	q.flags = binary.BigEndian.Uint16(msg[2:4])
+	if binary.BigEndian.Uint16(msg[4:6]) < 1 {
+		return q, errors.New("dns message has no question")
+	}
+
+	name, off, err := readName(msg, headerLen)
+	if err != nil {
+		return q, err
+	}
+	if off+4 > len(msg) {
+		return q, errShortMessage
+	}
+
+	q.name = name
+	q.qtype = binary.BigEndian.Uint16(msg[off : off+2])
+	q.qclass = binary.BigEndian.Uint16(msg[off+2 : off+4])
+	q.end = off + 4
+	return q, nil
+}
+
+// readName reads a possibly compressed name from msg at off and returns it
+// in lower case without the trailing dot, as well as the offset following
+// the name.
+func readName(msg []byte, off int) (string, int, error) {
+	var (
+		labels   []string
+		end      = -1
+		pointers = 0
+	)
+
+	for {
+		if off >= len(msg) {
+			return "", 0, errShortMessage
+		}
+
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errShortMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			pointers++
+			if pointers > 10 {
+				return "", 0, errPointerLoop
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:off+2]) & 0x3fff)
+		case l&0xc0 != 0:
+			return "", 0, fmt.Errorf("invalid dns label length %#x", l)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errShortMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// appendName appends the uncompressed wire format of name to b.
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) < 1 {
+			continue
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// answer is a resource record in a response.
+type answer struct {
+	rtype uint16
+	rdata []byte
+}
+
+// buildResponse builds the response to the query q from the raw query msg,
+// echoing its question and pointing the answers to the question name.
+func buildResponse(msg []byte, q question, rcode uint16, ttl uint32, answers []answer) []byte {
+	b := make([]byte, headerLen, q.end+len(answers)*32)
+	binary.BigEndian.PutUint16(b[0:2], q.id)
+	binary.BigEndian.PutUint16(b[2:4], flagQR|flagAA|flagRA|(q.flags&flagRD)|(q.opcode()<<11)|rcode)
+	binary.BigEndian.PutUint16(b[4:6], 1)
+	binary.BigEndian.PutUint16(b[6:8], uint16(len(answers)))
+
+	// Copy the question.
+	b = append(b, msg[headerLen:q.end]...)
+
+	for _, a := range answers {
+		var rr [12]byte
+		// Compression pointer to the question name.
+		binary.BigEndian.PutUint16(rr[0:2], 0xc000|headerLen)
+		binary.BigEndian.PutUint16(rr[2:4], a.rtype)
+		binary.BigEndian.PutUint16(rr[4:6], classINET)
+		binary.BigEndian.PutUint32(rr[6:10], ttl)
+		binary.BigEndian.PutUint16(rr[10:12], uint16(len(a.rdata)))
+		b = append(b, rr[:]...)
+		b = append(b, a.rdata...)
+	}
+
+	return b
+}
+
+// buildError builds an error response with only the header of the query.
+func buildError(msg []byte, rcode uint16) []byte {
+	b := make([]byte, headerLen)
+	copy(b[0:2], msg[0:2])
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	binary.BigEndian.PutUint16(b[2:4], flagQR|flagRA|(flags&flagRD)|(flags&(0xf<<11))|rcode)
+	return b
+}
+
+// reverseName returns the ip address for a reverse lookup name in the
+// in-addr.arpa or ip6.arpa domains.
+func reverseName(name string) net.IP {
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(labels) != 4 {
+			return nil
+		}
+		for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+			labels[i], labels[j] = labels[j], labels[i]
+		}
+		return net.ParseIP(strings.Join(labels, ".")).To4()
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(labels) != 32 {
+			return nil
+		}
+		var s strings.Builder
+		for i := len(labels) - 1; i >= 0; i-- {
+			if len(labels[i]) != 1 {
+				return nil
+			}
+			s.WriteString(labels[i])
+			if i > 0 && i%4 == 0 {
+				s.WriteByte(':')
+			}
+		}
+		return net.ParseIP(s.String())
+	}
+	return nil
+}
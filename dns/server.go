@@ -0,0 +1,313 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultTTL is the default time to live in seconds of the records
+	// served for the containers.
+	DefaultTTL = 10
+
+	// maxUDPSize is the size of the buffer for udp messages.
+	maxUDPSize = 4096
+	// forwardTimeout is the time we wait for an upstream server.
+	forwardTimeout = 2 * time.Second
+)
+
+// Record maps the names of a container to its ip address.
+type Record struct {
+	Names []string
+	IP    net.IP
+}
+
+// Server is a dns server answering the queries for the names of the
+// containers and forwarding the other queries to upstream servers.
+type Server struct {
+	// Addr is the address to listen on for udp and tcp, ex. 172.19.0.1:53.
+	Addr string
+	// Domain is an optional domain the container names are also served
+	// under, ex. "netns" for web.netns.
+	Domain string
+	// TTL is the time to live in seconds of the container records.
+	TTL uint32
+	// Upstreams are the addresses of the servers the other queries are
+	// forwarded to, ex. 8.8.8.8:53.
+	Upstreams []string
+
+	mu    sync.RWMutex
+	names map[string][]net.IP
+	ptrs  map[string]string
+}
+
+// SetRecords replaces the container records served.
+func (s *Server) SetRecords(records []Record) {
+	names := map[string][]net.IP{}
+	ptrs := map[string]string{}
+
+	for _, r := range records {
+		for _, name := range r.Names {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if len(name) < 1 {
+				continue
+			}
+			names[name] = append(names[name], r.IP)
+		}
+		if len(r.Names) > 0 {
+			ptrs[r.IP.String()] = s.fqdn(r.Names[0])
+		}
+	}
+
+	s.mu.Lock()
+	s.names = names
+	s.ptrs = ptrs
+	s.mu.Unlock()
+}
+
+// ListenAndServe listens on Addr for udp and tcp and serves the queries until
+// the context is canceled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if s.TTL < 1 {
+		s.TTL = DefaultTTL
+	}
+
+	pc, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("listening on udp %s failed: %v", s.Addr, err)
+	}
+	defer pc.Close()
+
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("listening on tcp %s failed: %v", s.Addr, err)
+	}
+	defer l.Close()
+
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+		l.Close()
+	}()
+
+	errc := make(chan error, 2)
+	go func() { errc <- s.serveUDP(pc) }()
+	go func() { errc <- s.serveTCP(l) }()
+
+	err = <-errc
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) serveUDP(pc net.PacketConn) error {
+	for {
+		buf := make([]byte, maxUDPSize)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("reading udp message failed: %v", err)
+		}
+
+		go func() {
+			resp := s.handle(buf[:n], "udp")
+			if resp == nil {
+				return
+			}
+			if _, err := pc.WriteTo(resp, addr); err != nil {
+				logrus.Debugf("[dns] writing response to %s failed: %v", addr, err)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("accepting tcp connection failed: %v", err)
+		}
+
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				msg, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				resp := s.handle(msg, "tcp")
+				if resp == nil {
+					return
+				}
+				if err := writeTCPMessage(conn, resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle returns the response for a query, it returns nil when the query
+// should be dropped.
+func (s *Server) handle(msg []byte, network string) []byte {
+	if len(msg) < headerLen {
+		return nil
+	}
+
+	// Responses are dropped so they cannot be bounced off the upstream
+	// servers, and only standard queries are served or forwarded.
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&flagQR != 0 {
+		return nil
+	}
+	if (flags>>11)&0xf != opcodeQuery {
+		return buildError(msg, rcodeNotImp)
+	}
+
+	q, err := parseQuestion(msg)
+	if err != nil {
+		logrus.Debugf("[dns] parsing query failed: %v", err)
+		return buildError(msg, rcodeFormat)
+	}
+
+	// Only the queries for the internet class are answered locally.
+	if q.qclass == classINET {
+		if resp := s.answer(msg, q); resp != nil {
+			return resp
+		}
+	}
+
+	resp, err := s.forward(msg, network)
+	if err != nil {
+		logrus.Debugf("[dns] forwarding query for %s failed: %v", q.name, err)
+		return nil
+	}
+	return resp
+}
+
+// answer returns the response for the queries about containers, or nil if
+// the query is not about a container.
+func (s *Server) answer(msg []byte, q question) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if q.qtype == typePTR {
+		ip := reverseName(q.name)
+		if ip == nil {
+			return nil
+		}
+		name, ok := s.ptrs[ip.String()]
+		if !ok {
+			return nil
+		}
+		return buildResponse(msg, q, rcodeSuccess, s.TTL, []answer{{rtype: typePTR, rdata: appendName(nil, name)}})
+	}
+
+	name := q.name
+	inDomain := false
+	if len(s.Domain) > 0 && strings.HasSuffix(name, "."+s.Domain) {
+		name = strings.TrimSuffix(name, "."+s.Domain)
+		inDomain = true
+	}
+
+	ips, ok := s.names[name]
+	if !ok {
+		if inDomain {
+			// We are authoritative for our domain.
+			return buildResponse(msg, q, rcodeNXDomain, s.TTL, nil)
+		}
+		return nil
+	}
+
+	var answers []answer
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil && (q.qtype == typeA || q.qtype == typeANY) {
+			answers = append(answers, answer{rtype: typeA, rdata: []byte(ip4)})
+		} else if ip4 == nil && (q.qtype == typeAAAA || q.qtype == typeANY) {
+			answers = append(answers, answer{rtype: typeAAAA, rdata: []byte(ip.To16())})
+		}
+	}
+
+	return buildResponse(msg, q, rcodeSuccess, s.TTL, answers)
+}
+
+// forward sends the query to the upstream servers and returns the first
+// response.
+func (s *Server) forward(msg []byte, network string) ([]byte, error) {
+	if len(s.Upstreams) < 1 {
+		return nil, fmt.Errorf("no upstream servers")
+	}
+
+	var err error
+	for _, upstream := range s.Upstreams {
+		var resp []byte
+		resp, err = exchange(upstream, network, msg)
+		if err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+// exchange sends a message to a server and returns the response.
+func exchange(addr, network string, msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, addr, forwardTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(forwardTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, msg); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxUDPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// fqdn returns the name of a container in the domain.
+func (s *Server) fqdn(name string) string {
+	if len(s.Domain) > 0 {
+		return name + "." + s.Domain + "."
+	}
+	return name + "."
+}
+
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	b := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	_, err := w.Write(append(b, msg...))
+	return err
+}
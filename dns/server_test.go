@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func newQuery(name string, qtype uint16) []byte {
+	b := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(b[0:2], 0xbeef)
+	binary.BigEndian.PutUint16(b[2:4], flagRD)
+	binary.BigEndian.PutUint16(b[4:6], 1)
+	b = appendName(b, name)
+	var q [4]byte
+	binary.BigEndian.PutUint16(q[0:2], qtype)
+	binary.BigEndian.PutUint16(q[2:4], classINET)
+	return append(b, q[:]...)
+}
+
+func newTestServer() *Server {
+	s := &Server{Domain: "netns", TTL: DefaultTTL}
+	s.SetRecords([]Record{
+		{Names: []string{"web", "abc123"}, IP: net.ParseIP("172.19.0.2")},
+		{Names: []string{"db"}, IP: net.ParseIP("172.19.0.3")},
+	})
+	return s
+}
+
+func TestAnswerA(t *testing.T) {
+	s := newTestServer()
+
+	for _, name := range []string{"web", "WEB.", "abc123", "web.netns"} {
+		resp := s.handle(newQuery(name, typeA), "udp")
+		if resp == nil {
+			t.Fatalf("expected a response for %s", name)
+		}
+
+		if id := binary.BigEndian.Uint16(resp[0:2]); id != 0xbeef {
+			t.Fatalf("expected id %#x got %#x", 0xbeef, id)
+		}
+		if rcode := binary.BigEndian.Uint16(resp[2:4]) & 0xf; rcode != rcodeSuccess {
+			t.Fatalf("expected rcode %d for %s got %d", rcodeSuccess, name, rcode)
+		}
+		if n := binary.BigEndian.Uint16(resp[6:8]); n != 1 {
+			t.Fatalf("expected 1 answer for %s got %d", name, n)
+		}
+
+		ip := net.IP(resp[len(resp)-4:])
+		if !ip.Equal(net.ParseIP("172.19.0.2")) {
+			t.Fatalf("expected 172.19.0.2 for %s got %s", name, ip)
+		}
+	}
+}
+
+func TestAnswerAAAANoData(t *testing.T) {
+	resp := newTestServer().handle(newQuery("db", typeAAAA), "udp")
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+
+	if rcode := binary.BigEndian.Uint16(resp[2:4]) & 0xf; rcode != rcodeSuccess {
+		t.Fatalf("expected rcode %d got %d", rcodeSuccess, rcode)
+	}
+	if n := binary.BigEndian.Uint16(resp[6:8]); n != 0 {
+		t.Fatalf("expected no answers got %d", n)
+	}
+}
+
+func TestAnswerNXDomain(t *testing.T) {
+	resp := newTestServer().handle(newQuery("cache.netns", typeA), "udp")
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+
+	if rcode := binary.BigEndian.Uint16(resp[2:4]) & 0xf; rcode != rcodeNXDomain {
+		t.Fatalf("expected rcode %d got %d", rcodeNXDomain, rcode)
+	}
+}
+
+func TestAnswerPTR(t *testing.T) {
+	msg := newQuery("3.0.19.172.in-addr.arpa", typePTR)
+	resp := newTestServer().handle(msg, "udp")
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+
+	if n := binary.BigEndian.Uint16(resp[6:8]); n != 1 {
+		t.Fatalf("expected 1 answer got %d", n)
+	}
+
+	// The answer follows the question, its data starts after the name
+	// pointer, type, class, ttl and length.
+	name, _, err := readName(resp, len(msg)+12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "db.netns" {
+		t.Fatalf("expected db.netns got %s", name)
+	}
+}
+
+func TestHandleNotForwarded(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	s := newTestServer()
+	s.Upstreams = []string{upstream.LocalAddr().String()}
+
+	// A response is dropped instead of being bounced off the upstream.
+	resp := newQuery("example.com", typeA)
+	binary.BigEndian.PutUint16(resp[2:4], flagQR|flagRD)
+	if b := s.handle(resp, "udp"); b != nil {
+		t.Fatalf("expected the response to be dropped, got %v", b)
+	}
+
+	// The other opcodes are not implemented.
+	status := newQuery("example.com", typeA)
+	binary.BigEndian.PutUint16(status[2:4], 2<<11)
+	b := s.handle(status, "udp")
+	if b == nil {
+		t.Fatal("expected a response")
+	}
+	if rcode := binary.BigEndian.Uint16(b[2:4]) & 0xf; rcode != rcodeNotImp {
+		t.Fatalf("expected rcode %d got %d", rcodeNotImp, rcode)
+	}
+
+	upstream.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := upstream.ReadFrom(make([]byte, maxUDPSize)); err == nil {
+		t.Fatal("expected nothing to be forwarded upstream")
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	testcases := map[string]string{
+		"3.0.19.172.in-addr.arpa": "172.19.0.3",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": "2001:db8::1",
+	}
+
+	for in, expected := range testcases {
+		ip := reverseName(in)
+		if !ip.Equal(net.ParseIP(expected)) {
+			t.Fatalf("expected %s for %s got %s", expected, in, ip)
+		}
+	}
+
+	if ip := reverseName("example.com"); ip != nil {
+		t.Fatalf("expected no ip got %s", ip)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/genuinetools/netns/doctor"
+)
+
+const doctorHelp = `Check that the host is set up for the container networks.
+
+This checks the kernel modules, the sysctls, iptables, the bridge, the nat and
+isolation rules, the state directory and the database against the options
+netns is run with. Each check passes, warns or fails with a hint on how to
+fix the problem. With --fix the problems that are safe to repair are fixed.`
+
+func (cmd *doctorCommand) Name() string      { return "doctor" }
+func (cmd *doctorCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *doctorCommand) ShortHelp() string { return `Check the host setup for problems.` }
+func (cmd *doctorCommand) LongHelp() string  { return doctorHelp }
+func (cmd *doctorCommand) Hidden() bool      { return false }
+
+func (cmd *doctorCommand) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.fix, "fix", false, "fix the problems that are safe to repair")
+	fs.StringVar(&cmd.format, "format", "table", "output format (table, json)")
+}
+
+type doctorCommand struct {
+	fix    bool
+	format string
+}
+
+func (cmd *doctorCommand) Run(ctx context.Context, args []string) error {
+	if cmd.format != "table" && cmd.format != "json" {
+		return fmt.Errorf("unknown format %q, must be one of table or json", cmd.format)
+	}
+
+	d := doctor.New(doctor.Opt{
+		Bridge:  brOpt,
+		Network: netOpt,
+	}, client)
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	results := d.Run(ctx, cmd.fix)
+
+	if cmd.format == "json" {
+		if err := writeJSON(os.Stdout, results); err != nil {
+			return err
+		}
+	} else {
+		printResults(results)
+	}
+
+	if n := doctor.Failed(results); n > 0 {
+		return fmt.Errorf("%d of %d checks failed", n, len(results))
+	}
+	return nil
+}
+
+func printResults(results []doctor.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprint(w, "STATUS\tCHECK\tMESSAGE\n")
+	for _, r := range results {
+		status := string(r.Status)
+		if r.Fixed {
+			status += " (fixed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", status, r.Check, r.Message)
+		if r.Status != doctor.Pass && len(r.Hint) > 0 {
+			fmt.Fprintf(w, "\t\thint: %s\n", r.Hint)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,406 @@
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/netutils"
+	"github.com/genuinetools/netns/network"
+	"github.com/vishvananda/netlink"
+)
+
+// dbTimeout is how long to wait for the database lock before reporting it
+// as locked.
+const dbTimeout = time.Second
+
+func (d *Doctor) defaultChecks() []Check {
+	br := d.opt.Bridge
+	checks := []Check{
+		d.moduleCheck("bridge", Warn),
+		d.moduleCheck("veth", Warn),
+	}
+	if br.DisableICC {
+		// The module is not loaded on demand and without it the traffic
+		// between containers is not isolated.
+		checks = append(checks, d.moduleCheck("br_netfilter", Fail))
+	}
+	if br.NAT.Mode != bridge.NATNone {
+		checks = append(checks, d.moduleCheck("nf_nat", Warn))
+	}
+	if d.opt.Network.Limits.Egress != nil || d.opt.Network.Limits.Ingress != nil {
+		checks = append(checks, d.moduleCheck("sch_tbf", Warn))
+	}
+	if d.opt.Network.Limits.Egress != nil {
+		// The traffic sent by the containers is redirected to an ifb
+		// device on the host.
+		checks = append(checks,
+			d.moduleCheck("ifb", Warn),
+			d.moduleCheck("sch_ingress", Warn),
+			d.moduleCheck("cls_u32", Warn),
+			d.moduleCheck("act_mirred", Warn),
+		)
+	}
+	if d.opt.Network.Netem != nil {
+		checks = append(checks, d.moduleCheck("sch_netem", Warn))
+	}
+
+	checks = append(checks,
+		Check{Name: "forwarding", Run: d.checkForwarding},
+	)
+	if br.DisableICC {
+		checks = append(checks, Check{Name: "bridge netfilter", Run: d.checkBridgeNetfilter})
+	}
+
+	return append(checks,
+		Check{Name: "firewall", Run: d.checkFirewall},
+		Check{Name: "bridge", Run: d.checkBridge},
+		Check{Name: "nat", Run: d.checkNAT},
+		Check{Name: "icc", Run: d.checkICC},
+		Check{Name: "state directory", Run: d.checkStateDir},
+		Check{Name: "database", Run: d.checkDatabase},
+		Check{Name: "consistency", Run: d.checkConsistency},
+	)
+}
+
+// moduleCheck returns a check for a kernel module, status is the status when
+// the module is not loaded.
+func (d *Doctor) moduleCheck(name string, status Status) Check {
+	return Check{
+		Name: "module " + name,
+		Run: func(ctx context.Context) Result {
+			if moduleLoaded(name) {
+				return pass("%s is loaded", name)
+			}
+
+			r := Result{
+				Status:  status,
+				Message: fmt.Sprintf("%s is not loaded", name),
+				Hint:    fmt.Sprintf("load it with: modprobe %s", name),
+			}
+			if status == Warn {
+				r.Message += ", it is loaded on demand if the kernel allows it"
+			}
+			return r.withFix(func() error {
+				out, err := exec.Command("modprobe", name).CombinedOutput()
+				if err != nil {
+					return fmt.Errorf("modprobe %s failed: %s %v", name, strings.TrimSpace(string(out)), err)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// moduleLoaded returns true if the kernel module is loaded or built in.
+func moduleLoaded(name string) bool {
+	if _, err := os.Stat(filepath.Join("/sys/module", name)); err == nil {
+		return true
+	}
+
+	b, err := ioutil.ReadFile("/proc/modules")
+	if err != nil {
+		return false
+	}
+	return parseModules(b)[name]
+}
+
+// parseModules returns the names of the modules in the content of
+// /proc/modules.
+func parseModules(b []byte) map[string]bool {
+	modules := map[string]bool{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 0 {
+			modules[fields[0]] = true
+		}
+	}
+	return modules
+}
+
+func (d *Doctor) checkForwarding(ctx context.Context) Result {
+	key := "net/ipv4/ip_forward"
+	if ip, _, err := net.ParseCIDR(d.opt.Bridge.IPAddr); err == nil && ip.To4() == nil {
+		key = "net/ipv6/conf/all/forwarding"
+	}
+	name := strings.Replace(key, "/", ".", -1)
+
+	v, err := netutils.GetSysctl(key)
+	if err != nil {
+		return fail("check that /proc/sys is mounted", "%v", err)
+	}
+	if v == "1" {
+		return pass("%s is enabled", name)
+	}
+
+	if !d.opt.Bridge.IPForward {
+		return warn(fmt.Sprintf("enable it with: sysctl -w %s=1", name), "%s is disabled and --ip-forward is false, containers cannot reach other networks", name)
+	}
+	return fail(fmt.Sprintf("enable it with: sysctl -w %s=1", name), "%s is disabled, containers cannot reach other networks", name).withFix(func() error {
+		return netutils.SetSysctl(key, "1")
+	})
+}
+
+func (d *Doctor) checkBridgeNetfilter(ctx context.Context) Result {
+	const key = "net/bridge/bridge-nf-call-iptables"
+
+	v, err := netutils.GetSysctl(key)
+	if err != nil {
+		return fail("load the br_netfilter module", "bridged traffic does not pass through iptables: %v", err)
+	}
+	if v == "1" {
+		return pass("bridged traffic passes through iptables")
+	}
+	return fail("enable it with: sysctl -w net.bridge.bridge-nf-call-iptables=1", "bridged traffic does not pass through iptables, containers are not isolated").withFix(func() error {
+		return netutils.SetSysctl(key, "1")
+	})
+}
+
+func (d *Doctor) checkFirewall(ctx context.Context) Result {
+	// iptables is only needed for nat and isolating containers.
+	required := d.opt.Bridge.NAT.Mode != bridge.NATNone || d.opt.Bridge.DisableICC
+
+	path, err := exec.LookPath("iptables")
+	if err != nil {
+		if !required {
+			return warn("install iptables", "iptables was not found, it is not needed with the current configuration")
+		}
+		return fail("install iptables", "iptables was not found")
+	}
+
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	version := strings.TrimSpace(string(out))
+	if err != nil {
+		return fail("check the iptables installation", "running %s --version failed: %s %v", path, version, err)
+	}
+
+	if _, err := netutils.NATRules(); err != nil {
+		return fail("run netns as root", "%s cannot read the nat table: %v", version, err)
+	}
+
+	return pass("%s at %s", version, path)
+}
+
+func (d *Doctor) checkBridge(ctx context.Context) Result {
+	opt := d.opt.Bridge
+
+	link, err := netlink.LinkByName(opt.Name)
+	if err != nil {
+		return warn("the bridge is created when the next container starts, or with netns doctor --fix", "bridge %s does not exist", opt.Name).withFix(d.setupHost)
+	}
+	if link.Type() != "bridge" {
+		return fail("delete the interface or use another name with --bridge", "%s is a %s, not a bridge", opt.Name, link.Type())
+	}
+
+	var (
+		problems []string
+		fixes    []func() error
+	)
+
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		problems = append(problems, "it is down")
+		fixes = append(fixes, func() error {
+			return netlink.LinkSetUp(link)
+		})
+	}
+
+	addr, err := netlink.ParseAddr(opt.IPAddr)
+	if err != nil {
+		return fail("pass a valid --ip", "parsing address %s failed: %v", opt.IPAddr, err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fail("", "listing addresses of %s failed: %v", opt.Name, err)
+	}
+	if !hasAddr(addrs, addr) {
+		problems = append(problems, fmt.Sprintf("it does not have the address %s", addr.IPNet.String()))
+		fixes = append(fixes, func() error {
+			return netlink.AddrAdd(link, addr)
+		})
+	}
+
+	mtu := opt.MTU
+	if mtu < 1 {
+		mtu = bridge.DefaultMTU
+	}
+	if link.Attrs().MTU != mtu {
+		problems = append(problems, fmt.Sprintf("its mtu is %d instead of %d", link.Attrs().MTU, mtu))
+		fixes = append(fixes, func() error {
+			return netlink.LinkSetMTU(link, mtu)
+		})
+	}
+
+	if len(problems) == 0 {
+		return pass("bridge %s is up with address %s", opt.Name, addr.IPNet.String())
+	}
+
+	return fail("run netns doctor --fix", "bridge %s does not match the configuration: %s", opt.Name, strings.Join(problems, ", ")).withFix(func() error {
+		for _, fix := range fixes {
+			if err := fix(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func hasAddr(addrs []netlink.Addr, addr *netlink.Addr) bool {
+	for _, a := range addrs {
+		if a.IP.Equal(addr.IP) && a.Mask.String() == addr.Mask.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// setupHost applies the host setup for the bridge again, which is safe to
+// do at any time.
+func (d *Doctor) setupHost() error {
+	_, err := bridge.Init(d.opt.Bridge)
+	return err
+}
+
+func (d *Doctor) checkNAT(ctx context.Context) Result {
+	opt := d.opt.Bridge
+	if _, err := exec.LookPath("iptables"); err != nil {
+		if opt.NAT.Mode == bridge.NATNone {
+			return pass("nat is disabled")
+		}
+		return fail("install iptables", "cannot check the nat rules without iptables")
+	}
+
+	masquerade := netutils.NATOutExists(opt.IPAddr, nil)
+	switch opt.NAT.Mode {
+	case bridge.NATNone:
+		if rules, err := netutils.NATRules(); err == nil && len(netutils.NATOutRules(rules, opt.IPAddr)) > 0 {
+			return warn("run netns doctor --fix", "nat is disabled but a nat rule for %s exists", opt.IPAddr).withFix(d.setupHost)
+		}
+		return pass("nat is disabled")
+	case bridge.NATSNAT:
+		if !netutils.NATOutExists(opt.IPAddr, opt.NAT.Source) {
+			return fail("run netns doctor --fix", "the snat rule for %s to %s is missing, containers cannot reach other networks", opt.IPAddr, opt.NAT.Source).withFix(d.setupHost)
+		}
+		return pass("traffic from %s is translated to %s", opt.IPAddr, opt.NAT.Source)
+	}
+
+	if !masquerade {
+		return fail("run netns doctor --fix", "the masquerade rule for %s is missing, containers cannot reach other networks", opt.IPAddr).withFix(d.setupHost)
+	}
+	return pass("traffic from %s is masqueraded", opt.IPAddr)
+}
+
+func (d *Doctor) checkICC(ctx context.Context) Result {
+	opt := d.opt.Bridge
+	if _, err := exec.LookPath("iptables"); err != nil {
+		if !opt.DisableICC {
+			return pass("containers on %s can talk to each other", opt.Name)
+		}
+		return fail("install iptables", "cannot check the isolation rules without iptables")
+	}
+
+	exists := netutils.ICCExists(opt.Name)
+	switch {
+	case opt.DisableICC && !exists:
+		return fail("run netns doctor --fix", "the rules isolating the containers on %s are missing", opt.Name).withFix(d.setupHost)
+	case !opt.DisableICC && exists:
+		return warn("run netns doctor --fix", "containers on %s are isolated but --icc is true", opt.Name).withFix(d.setupHost)
+	case exists:
+		return pass("containers on %s are isolated", opt.Name)
+	}
+	return pass("containers on %s can talk to each other", opt.Name)
+}
+
+func (d *Doctor) checkStateDir(ctx context.Context) Result {
+	dir := d.opt.Network.StateDir
+
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return warn("run netns doctor --fix", "state directory %s does not exist", dir).withFix(func() error {
+			return os.MkdirAll(dir, 0755)
+		})
+	}
+	if err != nil {
+		return fail("", "getting state directory %s failed: %v", dir, err)
+	}
+	if !fi.IsDir() {
+		return fail("remove it or use another --state-dir", "state directory %s is not a directory", dir)
+	}
+
+	// Make sure we can write to it.
+	f, err := ioutil.TempFile(dir, ".doctor")
+	if err != nil {
+		return fail("run netns as root or use another --state-dir", "state directory %s is not writable: %v", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	if fi.Mode().Perm()&0002 != 0 {
+		return warn("run netns doctor --fix", "state directory %s is writable by everyone (%s)", dir, fi.Mode().Perm()).withFix(func() error {
+			return os.Chmod(dir, 0755)
+		})
+	}
+
+	return pass("state directory %s is writable", dir)
+}
+
+func (d *Doctor) checkDatabase(ctx context.Context) Result {
+	path := d.client.DatabasePath()
+
+	err := d.client.CheckDB(dbTimeout)
+	switch err {
+	case nil:
+		return pass("database %s is healthy", path)
+	case network.ErrDatabaseDoesNotExist:
+		return pass("database %s has not been created yet", path)
+	case network.ErrDatabaseLocked:
+		pids, _ := d.client.DatabaseHolders()
+		if len(pids) == 0 {
+			return fail("check for stuck netns processes", "database %s is locked by another process", path)
+		}
+		return fail(fmt.Sprintf("check that the processes are not stuck and stop them: kill %s", joinInts(pids)), "database %s is locked by pid %s", path, joinInts(pids))
+	}
+	return fail("move the database away, the allocations will be lost", "%v", err)
+}
+
+func (d *Doctor) checkConsistency(ctx context.Context) Result {
+	// Do not block on a locked database, that is reported by the database
+	// check.
+	if err := d.client.CheckDB(dbTimeout); err != nil {
+		if err == network.ErrDatabaseDoesNotExist {
+			return pass("no networks have been allocated")
+		}
+		return warn("fix the database first", "skipped, the database is not available: %v", err)
+	}
+
+	problems, err := d.client.Check(ctx)
+	if err != nil {
+		return fail("", "%v", err)
+	}
+	if len(problems) == 0 {
+		return pass("the allocations match the host")
+	}
+
+	messages := make([]string, 0, len(problems))
+	for _, p := range problems {
+		messages = append(messages, p.String())
+	}
+	return warn("stale allocations can be released with: netns gc, unallocated veths can be deleted with: ip link del <veth>", "%s", strings.Join(messages, "; "))
+}
+
+func joinInts(a []int) string {
+	s := make([]string, 0, len(a))
+	for _, i := range a {
+		s = append(s, fmt.Sprintf("%d", i))
+	}
+	return strings.Join(s, " ")
+}
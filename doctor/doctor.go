@@ -0,0 +1,125 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/network"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	// Pass is the status of a check that found no problem.
+	Pass Status = "PASS"
+	// Warn is the status of a check that found a problem that does not
+	// prevent containers from getting a network.
+	Warn Status = "WARN"
+	// Fail is the status of a check that found a problem that breaks the
+	// networks of containers.
+	Fail Status = "FAIL"
+)
+
+// Result is the result of a check.
+type Result struct {
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+	Fixed   bool   `json:"fixed,omitempty"`
+
+	// fix repairs the problem. It is only set when doing so is safe.
+	fix func() error
+}
+
+// Check is a single check of the host.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) Result
+}
+
+// Opt holds the configuration the host is checked against.
+type Opt struct {
+	Bridge  bridge.Opt
+	Network network.Opt
+}
+
+// Doctor checks that the host is set up for the networks.
+type Doctor struct {
+	opt    Opt
+	client *network.Client
+	checks []Check
+}
+
+// New returns a Doctor with the checks for the configuration.
+func New(opt Opt, client *network.Client) *Doctor {
+	// Check the subnet the bridge was given if it was picked automatically.
+	if addr, err := bridge.Address(opt.Bridge); err == nil {
+		opt.Bridge.IPAddr = addr
+	}
+
+	d := &Doctor{
+		opt:    opt,
+		client: client,
+	}
+	d.checks = d.defaultChecks()
+	return d
+}
+
+// Run runs all the checks. When fix is true the problems that are safe to
+// repair are fixed and checked again.
+func (d *Doctor) Run(ctx context.Context, fix bool) []Result {
+	return run(ctx, d.checks, fix)
+}
+
+func run(ctx context.Context, checks []Check, fix bool) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		r := c.Run(ctx)
+		r.Check = c.Name
+
+		if fix && r.Status != Pass && r.fix != nil {
+			if err := r.fix(); err != nil {
+				r.Message = fmt.Sprintf("%s (fixing failed: %v)", r.Message, err)
+			} else {
+				r = c.Run(ctx)
+				r.Check = c.Name
+				r.Fixed = true
+			}
+		}
+
+		results = append(results, r)
+	}
+	return results
+}
+
+// Failed returns the number of results with the Fail status.
+func Failed(results []Result) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == Fail {
+			n++
+		}
+	}
+	return n
+}
+
+func pass(format string, a ...interface{}) Result {
+	return Result{Status: Pass, Message: fmt.Sprintf(format, a...)}
+}
+
+func warn(hint, format string, a ...interface{}) Result {
+	return Result{Status: Warn, Message: fmt.Sprintf(format, a...), Hint: hint}
+}
+
+func fail(hint, format string, a ...interface{}) Result {
+	return Result{Status: Fail, Message: fmt.Sprintf(format, a...), Hint: hint}
+}
+
+// withFix returns the result with a fix for the problem.
+func (r Result) withFix(fix func() error) Result {
+	r.fix = fix
+	return r
+}
@@ -0,0 +1,98 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunFix(t *testing.T) {
+	broken := true
+	fixCalls := 0
+
+	checks := []Check{
+		{
+			Name: "ok",
+			Run: func(ctx context.Context) Result {
+				return pass("fine")
+			},
+		},
+		{
+			Name: "fixable",
+			Run: func(ctx context.Context) Result {
+				if !broken {
+					return pass("fixed")
+				}
+				return fail("fix it", "broken").withFix(func() error {
+					fixCalls++
+					broken = false
+					return nil
+				})
+			},
+		},
+		{
+			Name: "unfixable",
+			Run: func(ctx context.Context) Result {
+				return warn("do it by hand", "still broken")
+			},
+		},
+		{
+			Name: "fix fails",
+			Run: func(ctx context.Context) Result {
+				return fail("", "broken").withFix(func() error {
+					return errors.New("nope")
+				})
+			},
+		},
+	}
+
+	results := run(context.Background(), checks, false)
+	if fixCalls != 0 {
+		t.Fatalf("expected no fixes without fix mode, got %d", fixCalls)
+	}
+	if Failed(results) != 2 {
+		t.Fatalf("expected 2 failures got %d", Failed(results))
+	}
+
+	results = run(context.Background(), checks, true)
+	if fixCalls != 1 {
+		t.Fatalf("expected 1 fix got %d", fixCalls)
+	}
+
+	expected := []struct {
+		check  string
+		status Status
+		fixed  bool
+	}{
+		{"ok", Pass, false},
+		{"fixable", Pass, true},
+		{"unfixable", Warn, false},
+		{"fix fails", Fail, false},
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.Check != e.check || r.Status != e.status || r.Fixed != e.fixed {
+			t.Fatalf("expected result %d to be %s %s fixed=%t got %s %s fixed=%t", i, e.check, e.status, e.fixed, r.Check, r.Status, r.Fixed)
+		}
+	}
+	if results[3].Message != "broken (fixing failed: nope)" {
+		t.Fatalf("expected the fix error in the message got %q", results[3].Message)
+	}
+}
+
+func TestParseModules(t *testing.T) {
+	b := []byte(`br_netfilter 32768 0 - Live 0x0000000000000000
+bridge 176128 1 br_netfilter, Live 0x0000000000000000
+veth 32768 0 - Live 0x0000000000000000
+`)
+
+	modules := parseModules(b)
+	for _, name := range []string{"br_netfilter", "bridge", "veth"} {
+		if !modules[name] {
+			t.Fatalf("expected %s to be loaded", name)
+		}
+	}
+	if modules["sch_netem"] {
+		t.Fatal("expected sch_netem not to be loaded")
+	}
+}
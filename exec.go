@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+const execHelp = `Run a command in the network namespace of a container.
+
+The command is a binary from the host, for example tcpdump, ss or curl. Only
+the network namespace is changed, the filesystem and the other namespaces are
+the ones of the host. The exit code of the command is passed through.
+
+The -h and --help arguments are taken by netns, use sh -c to pass them to the
+command.`
+
+func (cmd *execCommand) Name() string { return "exec" }
+func (cmd *execCommand) Args() string { return "<container-id|pid|ip> -- <cmd> [args...]" }
+func (cmd *execCommand) ShortHelp() string {
+	return `Run a command in the network namespace of a container.`
+}
+func (cmd *execCommand) LongHelp() string { return execHelp }
+func (cmd *execCommand) Hidden() bool     { return false }
+
+func (cmd *execCommand) Register(fs *flag.FlagSet) {}
+
+type execCommand struct{}
+
+func (cmd *execCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("must pass a container id, pid or ip")
+	}
+	target, args := args[0], args[1:]
+
+	// Strip the separator between the container and the command.
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) < 1 {
+		return errors.New("must pass a command to run")
+	}
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	// Catch the signals before starting the command so they are forwarded
+	// to it instead of killing us.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	// Only finding the container is bounded by the timeout, the command runs
+	// for as long as it needs.
+	lctx, cancel := withTimeout(ctx)
+	err := networks.Exec(lctx, target, c)
+	cancel()
+	if err != nil {
+		return timeoutError(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+
+	for {
+		select {
+		case sig := <-signals:
+			c.Process.Signal(sig)
+		case err := <-done:
+			// The exit code of the command is passed through by
+			// exitOnError.
+			return err
+		}
+	}
+}
+
+// exitCode returns the exit code of a command, following the convention of
+// shells for commands killed by a signal.
+func exitCode(err *exec.ExitError) int {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return err.ExitCode()
+}
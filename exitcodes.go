@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/network"
+	"github.com/genuinetools/pkg/cli"
+)
+
+// The exit codes of the errors scripts may want to handle, the other errors
+// exit with 1.
+const (
+	exitNotFound       = 3
+	exitPoolExhausted  = 4
+	exitIPConflict     = 5
+	exitNamespaceGone  = 6
+	exitSubnetConflict = 7
+	exitLinkExists     = 8
+	exitDatabaseLocked = 9
+	exitUnknownSchema  = 10
+	exitTimeout        = 124
+)
+
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	// The database being locked is checked before the timeout since it is
+	// the reason we ran out of time.
+	{network.ErrDatabaseLocked, exitDatabaseLocked},
+	{network.ErrNotFound, exitNotFound},
+	{network.ErrPoolExhausted, exitPoolExhausted},
+	{network.ErrIPConflict, exitIPConflict},
+	{network.ErrNamespaceGone, exitNamespaceGone},
+	{bridge.ErrSubnetConflict, exitSubnetConflict},
+	{network.ErrLinkExists, exitLinkExists},
+	{network.ErrUnknownSchema, exitUnknownSchema},
+	{context.DeadlineExceeded, exitTimeout},
+}
+
+// exitStatus returns the exit code for the error. The exit code of a command
+// run by netns is passed through.
+func exitStatus(err error) int {
+	var cmdErr *exec.ExitError
+	if errors.As(err, &cmdErr) {
+		return exitCode(cmdErr)
+	}
+	for _, e := range exitCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return 1
+}
+
+// exitError is an error exiting the program with a specific code.
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// lastExitError is the error main exits with once the program has run. The
+// cli exits with 1 on the errors it is returned, the errors with a specific
+// code are kept here instead.
+var lastExitError *exitError
+
+// exitCommand is a command exiting with the code of its error.
+type exitCommand struct {
+	cli.Command
+}
+
+func (c exitCommand) Run(ctx context.Context, args []string) error {
+	return exitOnError(c.Command.Run(ctx, args))
+}
+
+// exitOnError keeps the error to exit with its code once the program has
+// run. The errors without a specific code are returned so they are handled
+// as usual.
+func exitOnError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	code := exitStatus(err)
+	if code == 1 {
+		return err
+	}
+
+	lastExitError = &exitError{err: err, code: code}
+	return nil
+}
+
+// exit prints the error kept by exitOnError and exits with its code. The
+// errors of the commands run by netns are not printed, the command reported
+// them already.
+func exit() {
+	if lastExitError == nil {
+		return
+	}
+
+	var cmdErr *exec.ExitError
+	if !errors.As(lastExitError, &cmdErr) {
+		fmt.Fprintln(os.Stderr, lastExitError.Error())
+	}
+	os.Exit(lastExitError.code)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+const gcHelp = `Release the networks of the containers whose process is gone.
+
+A pid given to another process counts as gone, a network namespace kept
+alive by another process or a bind mount does not.
+
+The veth pair, the ip address and the name resolution files of each of them
+are released, as if the container had been deleted.`
+
+func (cmd *gcCommand) Name() string      { return "gc" }
+func (cmd *gcCommand) Args() string      { return "" }
+func (cmd *gcCommand) ShortHelp() string { return `Release the networks of stopped containers.` }
+func (cmd *gcCommand) LongHelp() string  { return gcHelp }
+func (cmd *gcCommand) Hidden() bool      { return false }
+
+func (cmd *gcCommand) Register(fs *flag.FlagSet) {}
+
+type gcCommand struct{}
+
+func (cmd *gcCommand) Run(ctx context.Context, args []string) error {
+	reclaimed, err := gcNetworks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range reclaimed {
+		fmt.Printf("released ip %s of pid %d\n", a.IP.String(), a.PID)
+	}
+	return nil
+}
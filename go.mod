@@ -1,23 +1,39 @@
 module github.com/genuinetools/netns
 
+go 1.27.1
+
 require (
-	github.com/boltdb/bolt v1.3.1 // indirect
-	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/docker/libnetwork v0.0.0-20180914141841-20461b853933
 	github.com/erikh/ping v0.0.0-20141209185752-d731d249e12a
 	github.com/genuinetools/pkg v0.0.0-20180910213200-1c141f661797
-	github.com/godbus/dbus v4.1.0+incompatible // indirect
-	github.com/onsi/gomega v1.4.2 // indirect
 	github.com/opencontainers/runc v0.0.0-20180920170208-00dc70017d22
-	github.com/opencontainers/runtime-spec v1.0.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/opencontainers/runtime-spec v1.0.1
 	github.com/sirupsen/logrus v1.0.6
-	github.com/stretchr/testify v1.2.2 // indirect
 	github.com/vishvananda/netlink v1.0.0
 	github.com/vishvananda/netns v0.0.0-20180720170159-13995c7128cc
 	go.etcd.io/bbolt v1.3.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/boltdb/bolt v1.3.1 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/godbus/dbus v4.1.0+incompatible // indirect
+	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/hpcloud/tail v1.0.0 // indirect
+	github.com/onsi/ginkgo v1.6.0 // indirect
+	github.com/onsi/gomega v1.4.2 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/testify v1.2.2 // indirect
 	golang.org/x/crypto v0.0.0-20180910181607-0e37d006457b // indirect
+	golang.org/x/net v0.0.0-20180906233101-161cd47e91fd // indirect
+	golang.org/x/sync v0.0.0-20180314180146-1d60e4601c6f // indirect
 	golang.org/x/sys v0.0.0-20180925112736-b09afc3d579e // indirect
+	golang.org/x/text v0.3.0 // indirect
 	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
 	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
 )
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/genuinetools/netns/network"
+)
+
+const impairHelp = `Change or clear the network impairment profile of a running container.
+
+The profile is applied with netem to the traffic going to the container, for
+example: delay=100ms,jitter=10ms,loss=1%,duplicate=0.5%,reorder=25%,rate=1mbit`
+
+func (cmd *impairCommand) Name() string      { return "impair" }
+func (cmd *impairCommand) Args() string      { return "[OPTIONS] <container-id|pid|ip> [PROFILE]" }
+func (cmd *impairCommand) ShortHelp() string { return `Change the network impairment of a container.` }
+func (cmd *impairCommand) LongHelp() string  { return impairHelp }
+func (cmd *impairCommand) Hidden() bool      { return false }
+
+func (cmd *impairCommand) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.clear, "clear", false, "clear the impairment profile")
+}
+
+type impairCommand struct {
+	clear bool
+}
+
+func (cmd *impairCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("must pass a container id, pid or ip")
+	}
+
+	var profile *network.Netem
+	switch {
+	case cmd.clear && len(args) > 1:
+		return errors.New("cannot pass a profile with --clear")
+	case !cmd.clear && len(args) < 2:
+		return errors.New("must pass a profile or --clear")
+	case !cmd.clear:
+		var err error
+		profile, err = network.ParseNetem(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if err := networks.Impair(ctx, args[0], profile); err != nil {
+		return timeoutError(err)
+	}
+
+	if profile == nil {
+		fmt.Printf("cleared impairment for %s\n", args[0])
+		return nil
+	}
+	fmt.Printf("impaired %s: %s\n", args[0], profile.String())
+	return nil
+}
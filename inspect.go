@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const inspectHelp = `Show the network of a container.
+
+This includes the interface, routes and neighbors inside the network
+namespace, the local side of the veth pair and its bridge port, the nat rules
+and port mappings for the container and the stored allocation record.
+
+The output format is json, yaml or a go template, for example
+--format '{{.Interface.MAC}}'.`
+
+func (cmd *inspectCommand) Name() string      { return "inspect" }
+func (cmd *inspectCommand) Args() string      { return "[OPTIONS] <container-id|pid|ip>" }
+func (cmd *inspectCommand) ShortHelp() string { return `Show the network of a container.` }
+func (cmd *inspectCommand) LongHelp() string  { return inspectHelp }
+func (cmd *inspectCommand) Hidden() bool      { return false }
+
+func (cmd *inspectCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.format, "format", "json", "output format (json, yaml) or a go template")
+}
+
+type inspectCommand struct {
+	format string
+}
+
+func (cmd *inspectCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("must pass a container id, pid or ip")
+	}
+
+	i, err := inspectNetwork(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	switch cmd.format {
+	case "json", "":
+		return writeJSON(os.Stdout, i)
+	case "yaml":
+		return writeYAML(os.Stdout, i)
+	}
+
+	tmpl, err := parseTemplate(cmd.format)
+	if err != nil {
+		return fmt.Errorf("parsing format template failed: %v", err)
+	}
+	if err := tmpl.Execute(os.Stdout, i); err != nil {
+		return fmt.Errorf("executing format template failed: %v", err)
+	}
+	fmt.Println()
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package kernel
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/vishvananda/netlink"
+)
+
+// The offloads of a network interface that can be turned on or off.
+const (
+	OffloadTSO        = "tso"
+	OffloadGSO        = "gso"
+	OffloadTxChecksum = "tx-checksum"
+	OffloadRxChecksum = "rx-checksum"
+)
+
+const (
+	// siocEthtool is the ioctl of the ethtool commands.
+	siocEthtool = 0x8946
+
+	ethtoolSRxCsum = 0x15
+	ethtoolSTxCsum = 0x17
+	ethtoolSTSO    = 0x1f
+	ethtoolSGSO    = 0x24
+)
+
+// offloadCommands are the ethtool commands setting each offload.
+var offloadCommands = map[string]uint32{
+	OffloadTSO:        ethtoolSTSO,
+	OffloadGSO:        ethtoolSGSO,
+	OffloadTxChecksum: ethtoolSTxCsum,
+	OffloadRxChecksum: ethtoolSRxCsum,
+}
+
+// ethtoolValue is struct ethtool_value.
+type ethtoolValue struct {
+	cmd  uint32
+	data uint32
+}
+
+// ifreq is struct ifreq with the pointer to the ethtool command. The pointer
+// is kept as an unsafe.Pointer so the garbage collector sees the command is
+// referenced during the ioctl.
+type ifreq struct {
+	name [syscall.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [16]byte
+}
+
+func (host) LinkSetOffload(link netlink.Link, offload string, on bool) error {
+	cmd, ok := offloadCommands[offload]
+	if !ok {
+		return fmt.Errorf("unknown offload %q", offload)
+	}
+	name := link.Attrs().Name
+	if len(name) >= syscall.IFNAMSIZ {
+		return fmt.Errorf("interface name %s is too long", name)
+	}
+
+	// The socket is in the network namespace of the thread, the one of the
+	// link.
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("opening socket for ethtool failed: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	value := ethtoolValue{cmd: cmd}
+	if on {
+		value.data = 1
+	}
+	var req ifreq
+	copy(req.name[:], name)
+	req.data = unsafe.Pointer(&value)
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("setting %s of %s failed: %v", offload, name, errno)
+	}
+	return nil
+}
@@ -0,0 +1,1002 @@
+package kernel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/genuinetools/netns/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+// ErrLinkNotFound is the error of Fake for the links that do not exist.
+var ErrLinkNotFound = errors.New("link not found")
+
+// IsLinkNotFound returns true if the error is the one of a kernel for a link
+// that does not exist.
+func IsLinkNotFound(err error) bool {
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		return true
+	}
+	return errors.Is(err, ErrLinkNotFound)
+}
+
+// Fake is a kernel keeping its state in memory, for testing without
+// privileges. The host namespace is the namespace of pid 0, the other
+// processes are added with AddProcess.
+type Fake struct {
+	mu sync.Mutex
+
+	namespaces map[int]*namespace
+	current    *namespace
+	lastIndex  int
+	peers      map[int]int
+
+	// lastProcess numbers the start times and the namespaces of the
+	// processes, held are the namespaces kept alive after their process.
+	lastProcess uint64
+	held        map[Namespace]*namespace
+
+	answering map[string]bool
+	failures  map[string]error
+
+	// Sysctls are the kernel parameters that were set.
+	Sysctls map[string]string
+	// NAT are the nat rules added for each network like iptables would,
+	// masquerade or the source address of snat.
+	NAT map[string][]string
+	// ICC is whether the traffic between the interfaces of each bridge is
+	// allowed.
+	ICC map[string]bool
+	// Pinged are the ip addresses that were pinged, in order.
+	Pinged []string
+	// HolderLookups is how many times the holders of the namespaces were
+	// looked up.
+	HolderLookups int
+}
+
+// namespace is the state of a network namespace.
+type namespace struct {
+	pid       int
+	links     map[int]netlink.Link
+	addrs     map[int][]netlink.Addr
+	routes    []netlink.Route
+	neighbors []netlink.Neigh
+	qdiscs    map[int][]netlink.Qdisc
+	filters   map[int][]netlink.Filter
+	offloads  map[int]map[string]bool
+
+	// process is the start time of the process and the id of the
+	// namespace, hold keeps the namespace when the process stops.
+	process Process
+	hold    bool
+}
+
+func newNamespace(pid int) *namespace {
+	return &namespace{
+		pid:      pid,
+		links:    map[int]netlink.Link{},
+		addrs:    map[int][]netlink.Addr{},
+		qdiscs:   map[int][]netlink.Qdisc{},
+		filters:  map[int][]netlink.Filter{},
+		offloads: map[int]map[string]bool{},
+	}
+}
+
+// NewFake returns a fake kernel with an empty host namespace.
+func NewFake() *Fake {
+	host := newNamespace(0)
+	return &Fake{
+		namespaces: map[int]*namespace{0: host},
+		current:    host,
+		peers:      map[int]int{},
+		held:       map[Namespace]*namespace{},
+		answering:  map[string]bool{},
+		failures:   map[string]error{},
+		Sysctls:    map[string]string{},
+		NAT:        map[string][]string{},
+		ICC:        map[string]bool{},
+	}
+}
+
+// AddProcess adds a running process with its own network namespace.
+func (f *Fake) AddProcess(pid int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastProcess++
+	ns := newNamespace(pid)
+	ns.process = Process{
+		StartTime: f.lastProcess,
+		NetNS:     Namespace{Dev: 4, Inode: f.lastProcess},
+	}
+	f.namespaces[pid] = ns
+}
+
+// HoldNamespace keeps the network namespace of the process alive with its
+// links when the process stops, as a bind mount would.
+func (f *Fake) HoldNamespace(pid int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ns, ok := f.namespaces[pid]; ok {
+		ns.hold = true
+	}
+}
+
+// RemoveProcess stops a process. Its network namespace is destroyed with
+// the links in it, and the peers of its veth pairs.
+func (f *Fake) RemoveProcess(pid int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ns, ok := f.namespaces[pid]
+	if !ok || pid == 0 {
+		return
+	}
+	delete(f.namespaces, pid)
+	if ns.hold {
+		f.held[ns.process.NetNS] = ns
+		return
+	}
+	for index := range ns.links {
+		f.deleteLink(ns, index)
+	}
+}
+
+// AddNeighbor adds an entry to the neighbor table of the host namespace.
+func (f *Fake) AddNeighbor(neigh netlink.Neigh) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.namespaces[0].neighbors = append(f.namespaces[0].neighbors, neigh)
+}
+
+// Answer makes ip answer the pings.
+func (f *Fake) Answer(ip net.IP) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.answering[ip.String()] = true
+}
+
+// Fail makes the operation with the given name, for example "RouteAdd",
+// return err. A nil err makes it succeed again.
+func (f *Fake) Fail(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.failures, operation)
+		return
+	}
+	f.failures[operation] = err
+}
+
+// Links returns the names of the links in the namespace of pid, sorted.
+func (f *Fake) Links(pid int) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := []string{}
+	if ns, ok := f.namespaces[pid]; ok {
+		for _, l := range ns.links {
+			names = append(names, l.Attrs().Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Routes returns the routes of the namespace of pid.
+func (f *Fake) Routes(pid int) []netlink.Route {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ns, ok := f.namespaces[pid]; ok {
+		return append([]netlink.Route(nil), ns.routes...)
+	}
+	return nil
+}
+
+// failure returns the error set for the operation. It must be called with
+// the lock held.
+func (f *Fake) failure(operation string) error {
+	return f.failures[operation]
+}
+
+// find returns the link of the current namespace by index, or by name if
+// the index is not set. It must be called with the lock held.
+func (f *Fake) find(link netlink.Link) (netlink.Link, error) {
+	attrs := link.Attrs()
+	if attrs.Index > 0 {
+		if l, ok := f.current.links[attrs.Index]; ok {
+			return l, nil
+		}
+		return nil, fmt.Errorf("%w: index %d", ErrLinkNotFound, attrs.Index)
+	}
+	return f.byName(attrs.Name)
+}
+
+// byName returns the link of the current namespace with the name. It must
+// be called with the lock held.
+func (f *Fake) byName(name string) (netlink.Link, error) {
+	for _, l := range f.current.links {
+		if l.Attrs().Name == name {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrLinkNotFound, name)
+}
+
+// add adds a copy of the link to the namespace with a new index. It must be
+// called with the lock held.
+func (f *Fake) add(ns *namespace, link netlink.Link) netlink.Link {
+	f.lastIndex++
+	l := copyLink(link)
+	l.Attrs().Index = f.lastIndex
+	ns.links[f.lastIndex] = l
+	return l
+}
+
+// deleteLink deletes the link from the namespace, and its peer if it is a
+// veth. It must be called with the lock held.
+func (f *Fake) deleteLink(ns *namespace, index int) {
+	delete(ns.links, index)
+	delete(ns.addrs, index)
+	delete(ns.qdiscs, index)
+	delete(ns.filters, index)
+	delete(ns.offloads, index)
+
+	routes := ns.routes[:0]
+	for _, r := range ns.routes {
+		if r.LinkIndex != index {
+			routes = append(routes, r)
+		}
+	}
+	ns.routes = routes
+
+	peer, ok := f.peers[index]
+	if !ok {
+		return
+	}
+	delete(f.peers, index)
+	delete(f.peers, peer)
+	for _, other := range f.namespaces {
+		if _, ok := other.links[peer]; ok {
+			f.deleteLink(other, peer)
+		}
+	}
+}
+
+func copyLink(link netlink.Link) netlink.Link {
+	attrs := *link.Attrs()
+	switch l := link.(type) {
+	case *netlink.Veth:
+		return &netlink.Veth{LinkAttrs: attrs, PeerName: l.PeerName}
+	case *netlink.Bridge:
+		return &netlink.Bridge{LinkAttrs: attrs}
+	case *netlink.Ifb:
+		return &netlink.Ifb{LinkAttrs: attrs}
+	}
+	return &netlink.Device{LinkAttrs: attrs}
+}
+
+// LinkAdd adds the link to the current namespace. The peer of a veth pair is
+// added as well.
+func (f *Fake) LinkAdd(link netlink.Link) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("LinkAdd"); err != nil {
+		return err
+	}
+	if _, err := f.byName(link.Attrs().Name); err == nil {
+		return syscall.EEXIST
+	}
+
+	l := f.add(f.current, link)
+	link.Attrs().Index = l.Attrs().Index
+
+	if veth, ok := link.(*netlink.Veth); ok {
+		if _, err := f.byName(veth.PeerName); err == nil {
+			f.deleteLink(f.current, l.Attrs().Index)
+			return syscall.EEXIST
+		}
+
+		la := netlink.NewLinkAttrs()
+		la.Name = veth.PeerName
+		la.MTU = veth.MTU
+		la.TxQLen = veth.TxQLen
+		peer := f.add(f.current, &netlink.Veth{LinkAttrs: la, PeerName: veth.Name})
+		f.peers[l.Attrs().Index] = peer.Attrs().Index
+		f.peers[peer.Attrs().Index] = l.Attrs().Index
+	}
+
+	return nil
+}
+
+// LinkDel deletes the link from the current namespace.
+func (f *Fake) LinkDel(link netlink.Link) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("LinkDel"); err != nil {
+		return err
+	}
+	l, err := f.find(link)
+	if err != nil {
+		return err
+	}
+	f.deleteLink(f.current, l.Attrs().Index)
+	return nil
+}
+
+// LinkByName returns the link of the current namespace with the name.
+func (f *Fake) LinkByName(name string) (netlink.Link, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.byName(name)
+	if err != nil {
+		return nil, err
+	}
+	return copyLink(l), nil
+}
+
+// LinkByIndex returns the link of the current namespace with the index.
+func (f *Fake) LinkByIndex(index int) (netlink.Link, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, ok := f.current.links[index]
+	if !ok {
+		return nil, fmt.Errorf("%w: index %d", ErrLinkNotFound, index)
+	}
+	return copyLink(l), nil
+}
+
+// LinkList returns the links of the current namespace, sorted by index.
+func (f *Fake) LinkList() ([]netlink.Link, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	indexes := make([]int, 0, len(f.current.links))
+	for index := range f.current.links {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	links := make([]netlink.Link, 0, len(indexes))
+	for _, index := range indexes {
+		links = append(links, copyLink(f.current.links[index]))
+	}
+	return links, nil
+}
+
+// LinkSetUp brings the link up.
+func (f *Fake) LinkSetUp(link netlink.Link) error {
+	return f.update("LinkSetUp", link, func(attrs *netlink.LinkAttrs) error {
+		attrs.Flags |= net.FlagUp
+		attrs.OperState = netlink.OperUp
+		return nil
+	})
+}
+
+// LinkSetDown brings the link down.
+func (f *Fake) LinkSetDown(link netlink.Link) error {
+	return f.update("LinkSetDown", link, func(attrs *netlink.LinkAttrs) error {
+		attrs.Flags &^= net.FlagUp
+		attrs.OperState = netlink.OperDown
+		return nil
+	})
+}
+
+// LinkSetName renames the link.
+func (f *Fake) LinkSetName(link netlink.Link, name string) error {
+	return f.update("LinkSetName", link, func(attrs *netlink.LinkAttrs) error {
+		if _, err := f.byName(name); err == nil {
+			return syscall.EEXIST
+		}
+		attrs.Name = name
+		return nil
+	})
+}
+
+// update changes the attributes of the link in the current namespace.
+func (f *Fake) update(operation string, link netlink.Link, fn func(*netlink.LinkAttrs) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure(operation); err != nil {
+		return err
+	}
+	l, err := f.find(link)
+	if err != nil {
+		return err
+	}
+	return fn(l.Attrs())
+}
+
+// LinkSetNsPid moves the link to the namespace of the process with pid.
+func (f *Fake) LinkSetNsPid(link netlink.Link, pid int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("LinkSetNsPid"); err != nil {
+		return err
+	}
+	l, err := f.find(link)
+	if err != nil {
+		return err
+	}
+	ns, ok := f.namespaces[pid]
+	if !ok {
+		return syscall.ESRCH
+	}
+
+	index := l.Attrs().Index
+	delete(f.current.links, index)
+	delete(f.current.addrs, index)
+	delete(f.current.qdiscs, index)
+	delete(f.current.filters, index)
+	l.Attrs().MasterIndex = 0
+	ns.links[index] = l
+
+	// The offloads are kept in the new namespace.
+	if offloads, ok := f.current.offloads[index]; ok {
+		delete(f.current.offloads, index)
+		ns.offloads[index] = offloads
+	}
+	return nil
+}
+
+// LinkSetOffload records the offload of the link.
+func (f *Fake) LinkSetOffload(link netlink.Link, offload string, on bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("LinkSetOffload"); err != nil {
+		return err
+	}
+	if _, ok := offloadCommands[offload]; !ok {
+		return fmt.Errorf("unknown offload %q", offload)
+	}
+	l, err := f.find(link)
+	if err != nil {
+		return err
+	}
+
+	index := l.Attrs().Index
+	if f.current.offloads[index] == nil {
+		f.current.offloads[index] = map[string]bool{}
+	}
+	f.current.offloads[index][offload] = on
+	return nil
+}
+
+// Offloads returns the offloads set on the link with the name in the
+// namespace of pid.
+func (f *Fake) Offloads(pid int, name string) map[string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ns, ok := f.namespaces[pid]
+	if !ok {
+		return nil
+	}
+	for index, l := range ns.links {
+		if l.Attrs().Name == name {
+			return ns.offloads[index]
+		}
+	}
+	return nil
+}
+
+// LinkGetProtinfo returns the bridge port settings of the link.
+func (f *Fake) LinkGetProtinfo(link netlink.Link) (netlink.Protinfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.find(link)
+	if err != nil {
+		return netlink.Protinfo{}, err
+	}
+	if l.Attrs().MasterIndex == 0 {
+		return netlink.Protinfo{}, syscall.EINVAL
+	}
+	return netlink.Protinfo{}, nil
+}
+
+// AddrAdd adds the address to the link.
+func (f *Fake) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("AddrAdd"); err != nil {
+		return err
+	}
+	l, err := f.find(link)
+	if err != nil {
+		return err
+	}
+
+	index := l.Attrs().Index
+	for _, a := range f.current.addrs[index] {
+		if a.IPNet.String() == addr.IPNet.String() {
+			return syscall.EEXIST
+		}
+	}
+
+	// The kernel labels the IPv4 addresses with the name of the link.
+	a := *addr
+	if len(a.Label) < 1 && a.IP.To4() != nil {
+		a.Label = l.Attrs().Name
+	}
+	f.current.addrs[index] = append(f.current.addrs[index], a)
+	return nil
+}
+
+// AddrDel removes the address from the link.
+func (f *Fake) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("AddrDel"); err != nil {
+		return err
+	}
+	l, err := f.find(link)
+	if err != nil {
+		return err
+	}
+
+	index := l.Attrs().Index
+	for i, a := range f.current.addrs[index] {
+		if a.IPNet.String() == addr.IPNet.String() {
+			f.current.addrs[index] = append(f.current.addrs[index][:i], f.current.addrs[index][i+1:]...)
+			return nil
+		}
+	}
+	return syscall.EADDRNOTAVAIL
+}
+
+// AddrList returns the addresses of the link, or of all the links of the
+// current namespace if link is nil.
+func (f *Fake) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var addrs []netlink.Addr
+	if link == nil {
+		for _, a := range f.current.addrs {
+			addrs = append(addrs, a...)
+		}
+	} else {
+		l, err := f.find(link)
+		if err != nil {
+			return nil, err
+		}
+		addrs = f.current.addrs[l.Attrs().Index]
+	}
+
+	list := []netlink.Addr{}
+	for _, a := range addrs {
+		if matchFamily(a.IP, family) {
+			list = append(list, a)
+		}
+	}
+	return list, nil
+}
+
+// RouteAdd adds the route to the current namespace.
+func (f *Fake) RouteAdd(route *netlink.Route) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("RouteAdd"); err != nil {
+		return err
+	}
+	if _, ok := f.current.links[route.LinkIndex]; !ok {
+		return syscall.ENODEV
+	}
+	f.current.routes = append(f.current.routes, *route)
+	return nil
+}
+
+// RouteDel removes the route with the same link, destination and gateway
+// from the current namespace.
+func (f *Fake) RouteDel(route *netlink.Route) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("RouteDel"); err != nil {
+		return err
+	}
+	for i, r := range f.current.routes {
+		if r.LinkIndex == route.LinkIndex && r.Dst.String() == route.Dst.String() && r.Gw.Equal(route.Gw) {
+			f.current.routes = append(f.current.routes[:i], f.current.routes[i+1:]...)
+			return nil
+		}
+	}
+	return syscall.ESRCH
+}
+
+// RouteList returns the routes of the link, or all the routes of the
+// current namespace if link is nil.
+func (f *Fake) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := 0
+	if link != nil {
+		l, err := f.find(link)
+		if err != nil {
+			return nil, err
+		}
+		index = l.Attrs().Index
+	}
+
+	routes := []netlink.Route{}
+	for _, r := range f.current.routes {
+		if index > 0 && r.LinkIndex != index {
+			continue
+		}
+		ip := r.Gw
+		if r.Dst != nil {
+			ip = r.Dst.IP
+		}
+		if ip == nil || matchFamily(ip, family) {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
+
+// NeighList returns the neighbors of the link with the index, or all the
+// neighbors of the current namespace if it is 0.
+func (f *Fake) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	neighbors := []netlink.Neigh{}
+	for _, n := range f.current.neighbors {
+		if linkIndex > 0 && n.LinkIndex != linkIndex {
+			continue
+		}
+		if matchFamily(n.IP, family) {
+			neighbors = append(neighbors, n)
+		}
+	}
+	return neighbors, nil
+}
+
+// QdiscReplace adds the qdisc, replacing the one with the same parent. It
+// fails with EINVAL if the qdisc with the same handle is of another kind.
+func (f *Fake) QdiscReplace(qdisc netlink.Qdisc) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("QdiscReplace"); err != nil {
+		return err
+	}
+	attrs := qdisc.Attrs()
+	if _, ok := f.current.links[attrs.LinkIndex]; !ok {
+		return syscall.ENODEV
+	}
+
+	for _, q := range f.current.qdiscs[attrs.LinkIndex] {
+		if q.Attrs().Parent == attrs.Parent && q.Attrs().Handle == attrs.Handle && q.Type() != qdisc.Type() {
+			return syscall.EINVAL
+		}
+	}
+
+	qdiscs := f.current.qdiscs[attrs.LinkIndex][:0]
+	for _, q := range f.current.qdiscs[attrs.LinkIndex] {
+		if q.Attrs().Parent != attrs.Parent {
+			qdiscs = append(qdiscs, q)
+		}
+	}
+	f.current.qdiscs[attrs.LinkIndex] = append(qdiscs, qdisc)
+	return nil
+}
+
+// QdiscDel deletes the qdisc with the qdiscs under it and its filters.
+func (f *Fake) QdiscDel(qdisc netlink.Qdisc) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("QdiscDel"); err != nil {
+		return err
+	}
+	attrs := qdisc.Attrs()
+	major, _ := netlink.MajorMinor(attrs.Handle)
+	qdiscs := f.current.qdiscs[attrs.LinkIndex][:0]
+	found := false
+	for _, q := range f.current.qdiscs[attrs.LinkIndex] {
+		if q.Attrs().Handle == attrs.Handle && q.Attrs().Parent == attrs.Parent {
+			found = true
+			continue
+		}
+		if parent, _ := netlink.MajorMinor(q.Attrs().Parent); parent == major && q.Attrs().Parent != netlink.HANDLE_ROOT && q.Attrs().Parent != netlink.HANDLE_INGRESS {
+			continue
+		}
+		qdiscs = append(qdiscs, q)
+	}
+	if !found {
+		return syscall.ENOENT
+	}
+	f.current.qdiscs[attrs.LinkIndex] = qdiscs
+
+	filters := f.current.filters[attrs.LinkIndex][:0]
+	for _, filter := range f.current.filters[attrs.LinkIndex] {
+		if filter.Attrs().Parent != attrs.Handle {
+			filters = append(filters, filter)
+		}
+	}
+	f.current.filters[attrs.LinkIndex] = filters
+	return nil
+}
+
+// QdiscList returns the qdiscs of the link.
+func (f *Fake) QdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.find(link)
+	if err != nil {
+		return nil, err
+	}
+	return append([]netlink.Qdisc(nil), f.current.qdiscs[l.Attrs().Index]...), nil
+}
+
+// FilterAdd adds the filter to the qdisc of its parent.
+func (f *Fake) FilterAdd(filter netlink.Filter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("FilterAdd"); err != nil {
+		return err
+	}
+	attrs := filter.Attrs()
+	found := false
+	for _, q := range f.current.qdiscs[attrs.LinkIndex] {
+		if q.Attrs().Handle == attrs.Parent {
+			found = true
+		}
+	}
+	if !found {
+		return syscall.EINVAL
+	}
+	for _, other := range f.current.filters[attrs.LinkIndex] {
+		if other.Attrs().Parent == attrs.Parent && other.Attrs().Priority == attrs.Priority {
+			return syscall.EEXIST
+		}
+	}
+	f.current.filters[attrs.LinkIndex] = append(f.current.filters[attrs.LinkIndex], filter)
+	return nil
+}
+
+// FilterDel deletes the filter with the same parent and priority.
+func (f *Fake) FilterDel(filter netlink.Filter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("FilterDel"); err != nil {
+		return err
+	}
+	attrs := filter.Attrs()
+	filters := f.current.filters[attrs.LinkIndex][:0]
+	found := false
+	for _, other := range f.current.filters[attrs.LinkIndex] {
+		if other.Attrs().Parent == attrs.Parent && other.Attrs().Priority == attrs.Priority {
+			found = true
+			continue
+		}
+		filters = append(filters, other)
+	}
+	if !found {
+		return syscall.ENOENT
+	}
+	f.current.filters[attrs.LinkIndex] = filters
+	return nil
+}
+
+// FilterList returns the filters of the link under parent.
+func (f *Fake) FilterList(link netlink.Link, parent uint32) ([]netlink.Filter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, err := f.find(link)
+	if err != nil {
+		return nil, err
+	}
+	filters := []netlink.Filter{}
+	for _, filter := range f.current.filters[l.Attrs().Index] {
+		if filter.Attrs().Parent == parent {
+			filters = append(filters, filter)
+		}
+	}
+	return filters, nil
+}
+
+// InNamespace runs fn with the namespace of the process with pid as the
+// current namespace. The calls of fn must not run in other goroutines.
+func (f *Fake) InNamespace(pid int, fn func() error) error {
+	f.mu.Lock()
+	if err := f.failure("InNamespace"); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	ns, ok := f.namespaces[pid]
+	if !ok {
+		f.mu.Unlock()
+		return fmt.Errorf("getting network namespace for pid %d failed: %v", pid, syscall.ESRCH)
+	}
+	orig := f.current
+	f.current = ns
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.current = orig
+		f.mu.Unlock()
+	}()
+	return fn()
+}
+
+// ProcessExists returns true if the process was added and not removed.
+func (f *Fake) ProcessExists(pid int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.namespaces[pid]
+	return ok && pid > 0
+}
+
+// Process returns the start time and the namespace given to the process
+// when it was added.
+func (f *Fake) Process(pid int) (Process, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ns, ok := f.namespaces[pid]
+	if !ok || pid == 0 {
+		return Process{}, fmt.Errorf("process %d: %v", pid, syscall.ESRCH)
+	}
+	return ns.process, nil
+}
+
+// NamespaceHolders returns the processes in the namespaces, or "bind mount"
+// for the ones held with HoldNamespace after their process stopped.
+func (f *Fake) NamespaceHolders() map[Namespace]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.HolderLookups++
+	holders := map[Namespace]string{}
+	for pid, ns := range f.namespaces {
+		if pid > 0 {
+			holders[ns.process.NetNS] = fmt.Sprintf("pid %d", pid)
+		}
+	}
+	for id := range f.held {
+		if _, ok := holders[id]; !ok {
+			holders[id] = "bind mount"
+		}
+	}
+	return holders
+}
+
+// SetSysctl records the value of the kernel parameter.
+func (f *Fake) SetSysctl(key, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("SetSysctl"); err != nil {
+		return err
+	}
+	f.Sysctls[key] = value
+	return nil
+}
+
+// SetupNATOut adds the masquerading of the network if it is not there.
+func (f *Fake) SetupNATOut(cidr string) error {
+	return f.addNAT("SetupNATOut", cidr, "masquerade")
+}
+
+// SetupSNATOut adds the source address of the traffic of the network if it
+// is not there.
+func (f *Fake) SetupSNATOut(cidr string, source net.IP) error {
+	return f.addNAT("SetupSNATOut", cidr, source.String())
+}
+
+func (f *Fake) addNAT(operation, cidr, rule string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure(operation); err != nil {
+		return err
+	}
+	for _, r := range f.NAT[cidr] {
+		if r == rule {
+			return nil
+		}
+	}
+	f.NAT[cidr] = append(f.NAT[cidr], rule)
+	return nil
+}
+
+// RemoveNATOut removes every nat rule of the network.
+func (f *Fake) RemoveNATOut(cidr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("RemoveNATOut"); err != nil {
+		return err
+	}
+	delete(f.NAT, cidr)
+	return nil
+}
+
+// NATRules returns the nat rules in NAT in the format of iptables -S.
+func (f *Fake) NATRules() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("NATRules"); err != nil {
+		return nil, err
+	}
+	networks := make([]string, 0, len(f.NAT))
+	for cidr := range f.NAT {
+		networks = append(networks, cidr)
+	}
+	sort.Strings(networks)
+
+	rules := []string{}
+	for _, cidr := range networks {
+		for _, r := range f.NAT[cidr] {
+			var source net.IP
+			if r != "masquerade" {
+				source = net.ParseIP(r)
+			}
+			rules = append(rules, netutils.NATOutRule(cidr, source))
+		}
+	}
+	return rules, nil
+}
+
+// SetupICC records whether the traffic between the interfaces of the bridge
+// is allowed.
+func (f *Fake) SetupICC(bridgeName string, enable bool, allow [][]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("SetupICC"); err != nil {
+		return err
+	}
+	f.ICC[bridgeName] = enable
+	return nil
+}
+
+// Ping returns true if ip was passed to Answer.
+func (f *Fake) Ping(ip net.IP, timeout time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Pinged = append(f.Pinged, ip.String())
+	return f.answering[ip.String()]
+}
+
+func matchFamily(ip net.IP, family int) bool {
+	switch family {
+	case netlink.FAMILY_V4:
+		return ip.To4() != nil
+	case netlink.FAMILY_V6:
+		return ip.To4() == nil
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package kernel
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestFakeVethPair(t *testing.T) {
+	k := NewFake()
+	k.AddProcess(1234)
+
+	la := netlink.NewLinkAttrs()
+	la.Name = "veth0"
+	veth := &netlink.Veth{LinkAttrs: la, PeerName: "veth1"}
+	if err := k.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.LinkAdd(veth); err == nil {
+		t.Fatal("expected an error adding the link twice")
+	}
+
+	peer, err := k.LinkByName("veth1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.LinkSetNsPid(peer, 1234); err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer is only found in the namespace it was moved to.
+	if _, err := k.LinkByName("veth1"); !IsLinkNotFound(err) {
+		t.Fatalf("expected the peer to be gone from the host, got %v", err)
+	}
+	if err := k.InNamespace(1234, func() error {
+		link, err := k.LinkByName("veth1")
+		if err != nil {
+			return err
+		}
+		return k.LinkSetName(link, "eth0")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if links := k.Links(1234); !reflect.DeepEqual(links, []string{"eth0"}) {
+		t.Fatalf("expected eth0 in the namespace, got %v", links)
+	}
+
+	// Deleting one side deletes the other one.
+	if err := k.LinkDel(veth); err != nil {
+		t.Fatal(err)
+	}
+	if links := k.Links(1234); len(links) != 0 {
+		t.Fatalf("expected no links in the namespace, got %v", links)
+	}
+}
+
+func TestFakeFail(t *testing.T) {
+	k := NewFake()
+	failure := errors.New("operation not permitted")
+	k.Fail("SetSysctl", failure)
+
+	if err := k.SetSysctl("net/ipv4/ip_forward", "1"); err != failure {
+		t.Fatalf("expected %v got %v", failure, err)
+	}
+
+	k.Fail("SetSysctl", nil)
+	if err := k.SetSysctl("net/ipv4/ip_forward", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if v := k.Sysctls["net/ipv4/ip_forward"]; v != "1" {
+		t.Fatalf("expected ip_forward to be 1 got %q", v)
+	}
+}
@@ -0,0 +1,183 @@
+package kernel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/erikh/ping"
+	"github.com/genuinetools/netns/netutils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// Host is the running kernel.
+var Host Kernel = host{}
+
+// host implements Kernel with netlink, the network namespaces of the
+// processes and iptables.
+type host struct{}
+
+func (host) LinkAdd(link netlink.Link) error {
+	return netlink.LinkAdd(link)
+}
+
+func (host) LinkDel(link netlink.Link) error {
+	return netlink.LinkDel(link)
+}
+
+func (host) LinkByName(name string) (netlink.Link, error) {
+	return netlink.LinkByName(name)
+}
+
+func (host) LinkByIndex(index int) (netlink.Link, error) {
+	return netlink.LinkByIndex(index)
+}
+
+func (host) LinkList() ([]netlink.Link, error) {
+	return netlink.LinkList()
+}
+
+func (host) LinkSetUp(link netlink.Link) error {
+	return netlink.LinkSetUp(link)
+}
+
+func (host) LinkSetDown(link netlink.Link) error {
+	return netlink.LinkSetDown(link)
+}
+
+func (host) LinkSetName(link netlink.Link, name string) error {
+	return netlink.LinkSetName(link, name)
+}
+
+func (host) LinkSetNsPid(link netlink.Link, pid int) error {
+	return netlink.LinkSetNsPid(link, pid)
+}
+
+func (host) LinkGetProtinfo(link netlink.Link) (netlink.Protinfo, error) {
+	return netlink.LinkGetProtinfo(link)
+}
+
+func (host) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	return netlink.AddrAdd(link, addr)
+}
+
+func (host) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	return netlink.AddrDel(link, addr)
+}
+
+func (host) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	return netlink.AddrList(link, family)
+}
+
+func (host) RouteAdd(route *netlink.Route) error {
+	return netlink.RouteAdd(route)
+}
+
+func (host) RouteDel(route *netlink.Route) error {
+	return netlink.RouteDel(route)
+}
+
+func (host) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	return netlink.RouteList(link, family)
+}
+
+func (host) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	return netlink.NeighList(linkIndex, family)
+}
+
+func (host) QdiscReplace(qdisc netlink.Qdisc) error {
+	return netlink.QdiscReplace(qdisc)
+}
+
+func (host) QdiscDel(qdisc netlink.Qdisc) error {
+	return netlink.QdiscDel(qdisc)
+}
+
+func (host) QdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
+	return netlink.QdiscList(link)
+}
+
+func (host) FilterAdd(filter netlink.Filter) error {
+	return netlink.FilterAdd(filter)
+}
+
+func (host) FilterDel(filter netlink.Filter) error {
+	return netlink.FilterDel(filter)
+}
+
+func (host) FilterList(link netlink.Link, parent uint32) ([]netlink.Filter, error) {
+	return netlink.FilterList(link, parent)
+}
+
+func (host) InNamespace(pid int, fn func() error) error {
+	// Lock the OS Thread so we don't accidentally switch namespaces.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Save the current network namespace.
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("getting current network namespace failed: %v", err)
+	}
+	defer origns.Close()
+
+	// Get the namespace from the pid.
+	newns, err := netns.GetFromPid(pid)
+	if err != nil {
+		return fmt.Errorf("getting network namespace for pid %d failed: %v", pid, err)
+	}
+	defer newns.Close()
+
+	// Enter the namespace.
+	if err := netns.Set(newns); err != nil {
+		return fmt.Errorf("entering network namespace failed: %v", err)
+	}
+
+	fnErr := fn()
+
+	// Switch back to the original namespace.
+	if err := netns.Set(origns); err != nil {
+		return fmt.Errorf("switching back to original namespace failed: %v", err)
+	}
+
+	return fnErr
+}
+
+func (host) ProcessExists(pid int) bool {
+	_, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	return err == nil
+}
+
+func (host) SetSysctl(key, value string) error {
+	return netutils.SetSysctl(key, value)
+}
+
+func (host) SetupNATOut(cidr string) error {
+	return netutils.SetupNATOut(cidr, iptables.Insert)
+}
+
+func (host) SetupSNATOut(cidr string, source net.IP) error {
+	return netutils.SetupSNATOut(cidr, source, iptables.Insert)
+}
+
+func (host) RemoveNATOut(cidr string) error {
+	return netutils.RemoveNATOut(cidr)
+}
+
+func (host) NATRules() ([]string, error) {
+	return netutils.NATRules()
+}
+
+func (host) SetupICC(bridgeName string, enable bool, allow [][]string) error {
+	return netutils.SetupICC(bridgeName, enable, allow)
+}
+
+func (host) Ping(ip net.IP, timeout time.Duration) bool {
+	return ping.Ping(&net.IPAddr{IP: ip, Zone: ""}, timeout)
+}
@@ -0,0 +1,168 @@
+// Package kernel abstracts the operations on the kernel the bridge and the
+// networks are set up with, so they can be replaced by an in-memory fake in
+// tests that do not run as root.
+package kernel
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// Kernel is the kernel the bridge and the networks are set up in. Host is
+// the running kernel and Fake keeps its state in memory.
+type Kernel interface {
+	Links
+	Addrs
+	Routes
+	Neighbors
+	Qdiscs
+	Filters
+	Namespaces
+	Firewall
+
+	// Ping returns true if ip answers an ICMP echo request within timeout.
+	Ping(ip net.IP, timeout time.Duration) bool
+}
+
+// Links are the operations on the network interfaces.
+type Links interface {
+	LinkAdd(link netlink.Link) error
+	LinkDel(link netlink.Link) error
+	LinkByName(name string) (netlink.Link, error)
+	LinkByIndex(index int) (netlink.Link, error)
+	LinkList() ([]netlink.Link, error)
+	LinkSetUp(link netlink.Link) error
+	LinkSetDown(link netlink.Link) error
+	LinkSetName(link netlink.Link, name string) error
+	LinkSetNsPid(link netlink.Link, pid int) error
+	LinkGetProtinfo(link netlink.Link) (netlink.Protinfo, error)
+	// LinkSetOffload turns an offload of the link on or off, see the
+	// Offload constants.
+	LinkSetOffload(link netlink.Link, offload string, on bool) error
+}
+
+// Addrs are the operations on the addresses of the network interfaces.
+type Addrs interface {
+	AddrAdd(link netlink.Link, addr *netlink.Addr) error
+	AddrDel(link netlink.Link, addr *netlink.Addr) error
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+}
+
+// Routes are the operations on the routing table.
+type Routes interface {
+	RouteAdd(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+	RouteList(link netlink.Link, family int) ([]netlink.Route, error)
+}
+
+// Neighbors are the operations on the neighbor table.
+type Neighbors interface {
+	NeighList(linkIndex, family int) ([]netlink.Neigh, error)
+}
+
+// Qdiscs are the operations on the queueing disciplines.
+type Qdiscs interface {
+	QdiscReplace(qdisc netlink.Qdisc) error
+	QdiscDel(qdisc netlink.Qdisc) error
+	QdiscList(link netlink.Link) ([]netlink.Qdisc, error)
+}
+
+// Filters are the operations on the traffic control filters.
+type Filters interface {
+	FilterAdd(filter netlink.Filter) error
+	FilterDel(filter netlink.Filter) error
+	FilterList(link netlink.Link, parent uint32) ([]netlink.Filter, error)
+}
+
+// Namespaces are the operations on the processes and their network
+// namespaces.
+type Namespaces interface {
+	// InNamespace runs fn in the network namespace of the process with pid.
+	// The operations fn does on the kernel apply to that namespace.
+	InNamespace(pid int, fn func() error) error
+	// ProcessExists returns true if the process with pid is running.
+	ProcessExists(pid int) bool
+	// Process returns the start time and the network namespace of the
+	// process with pid, or an error if it is not running.
+	Process(pid int) (Process, error)
+	// NamespaceHolders returns a process or a bind mount keeping each
+	// network namespace alive, the namespaces that are gone are missing.
+	NamespaceHolders() map[Namespace]string
+}
+
+// Process identifies a process beyond its pid, which the kernel reuses.
+type Process struct {
+	// StartTime is when the process started, in clock ticks after boot.
+	StartTime uint64 `json:"start_time"`
+	// NetNS is the network namespace of the process.
+	NetNS Namespace `json:"netns"`
+}
+
+// Namespace identifies a namespace by the device and the inode of its file.
+type Namespace struct {
+	Dev   uint64 `json:"dev"`
+	Inode uint64 `json:"inode"`
+}
+
+// Firewall are the operations on the kernel parameters and the iptables
+// rules.
+type Firewall interface {
+	// SetSysctl sets the value of the kernel parameter with the given key,
+	// for example "net/ipv4/ip_forward".
+	SetSysctl(key, value string) error
+
+	// SetupNATOut masquerades the traffic leaving the network cidr. The
+	// other nat rules of the network are left as they are.
+	SetupNATOut(cidr string) error
+	// SetupSNATOut rewrites the source address of the traffic leaving the
+	// network cidr to source.
+	SetupSNATOut(cidr string, source net.IP) error
+	// RemoveNATOut removes every masquerade and snat rule for the traffic
+	// leaving the network cidr.
+	RemoveNATOut(cidr string) error
+	// NATRules returns the rules of the nat table.
+	NATRules() ([]string, error)
+
+	// SetupICC allows or blocks the traffic between the interfaces of the
+	// bridge, except for the traffic matching the allow rules.
+	SetupICC(bridgeName string, enable bool, allow [][]string) error
+}
+
+// InterfaceAddr returns the first IPv4 address of the network interface.
+func InterfaceAddr(k Kernel, name string) (*net.IPNet, error) {
+	link, err := k.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("getting interface %s failed: %v", name, err)
+	}
+
+	addrs, err := k.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("listings addresses for %s failed: %v", name, err)
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("interface %s has no IP addresses", name)
+	}
+
+	if len(addrs) > 1 {
+		logrus.Debugf("interface %s has more than 1 IPv4 address, using: %s", name, addrs[0].IP.String())
+	}
+
+	return addrs[0].IPNet, nil
+}
+
+// Interface returns the network interface of a link.
+func Interface(link netlink.Link) *net.Interface {
+	attrs := link.Attrs()
+	return &net.Interface{
+		Index:        attrs.Index,
+		MTU:          attrs.MTU,
+		Name:         attrs.Name,
+		HardwareAddr: attrs.HardwareAddr,
+		Flags:        attrs.Flags,
+	}
+}
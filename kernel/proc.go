@@ -0,0 +1,92 @@
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// procDir is where the proc filesystem is mounted.
+const procDir = "/proc"
+
+// netnsDirs are the directories the network namespaces are bind mounted in
+// by ip netns.
+var netnsDirs = []string{"/run/netns", "/var/run/netns"}
+
+func (host) Process(pid int) (Process, error) {
+	var p Process
+
+	dir := filepath.Join(procDir, strconv.Itoa(pid))
+	stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return p, err
+	}
+	p.StartTime, err = parseStartTime(stat)
+	if err != nil {
+		return p, fmt.Errorf("parsing %s/stat failed: %v", dir, err)
+	}
+
+	p.NetNS, err = namespaceOf(filepath.Join(dir, "ns", "net"))
+	return p, err
+}
+
+func (host) NamespaceHolders() map[Namespace]string {
+	holders := map[Namespace]string{}
+	add := func(ns Namespace, holder string) {
+		if _, ok := holders[ns]; !ok {
+			holders[ns] = holder
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(procDir, "[0-9]*", "ns", "net"))
+	for _, file := range files {
+		if id, err := namespaceOf(file); err == nil {
+			add(id, "pid "+filepath.Base(filepath.Dir(filepath.Dir(file))))
+		}
+	}
+
+	for _, dir := range netnsDirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, file := range files {
+			if id, err := namespaceOf(file); err == nil {
+				add(id, file)
+			}
+		}
+	}
+
+	return holders
+}
+
+// namespaceOf returns the namespace of a file of the nsfs, or of a bind
+// mount of one.
+func namespaceOf(path string) (Namespace, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Namespace{}, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Namespace{}, fmt.Errorf("getting the inode of %s failed", path)
+	}
+	return Namespace{Dev: uint64(st.Dev), Inode: st.Ino}, nil
+}
+
+// parseStartTime returns the start time from the content of /proc/<pid>/stat.
+// It is the 22nd field, counted after the command name which can contain
+// spaces and parentheses.
+func parseStartTime(stat []byte) (uint64, error) {
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("no command name in %q", stat)
+	}
+	// The fields after the command name start with the 3rd one, the state.
+	fields := bytes.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("expected at least 22 fields, got %d", len(fields)+2)
+	}
+	return strconv.ParseUint(string(fields[19]), 10, 64)
+}
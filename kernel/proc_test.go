@@ -0,0 +1,26 @@
+package kernel
+
+import "testing"
+
+func TestParseStartTime(t *testing.T) {
+	testcases := map[string]uint64{
+		"1234 (sleep) S 1 1234 1234 0 -1 4194560 98 0 0 0 0 0 0 0 20 0 1 0 8421 2342912 128 18446744073709551615":        8421,
+		"1234 (a) b (c)) R 1 1234 1234 0 -1 4194560 98 0 0 0 0 0 0 0 20 0 1 0 99 2342912 128 18446744073709551615":       99,
+		"1234 (with spaces) S 1 1234 1234 0 -1 4194560 98 0 0 0 0 0 0 0 20 0 1 0 123456789 2342912 128 1844674407370955": 123456789,
+	}
+	for stat, expected := range testcases {
+		got, err := parseStartTime([]byte(stat))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Fatalf("expected %d for %q got %d", expected, stat, got)
+		}
+	}
+
+	for _, stat := range []string{"1234 sleep S 1", "1234 (sleep) S 1 1234"} {
+		if _, err := parseStartTime([]byte(stat)); err == nil {
+			t.Fatalf("expected an error for %q", stat)
+		}
+	}
+}
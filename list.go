@@ -1,38 +1,224 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+
+	"github.com/genuinetools/netns/network"
 )
 
-const listHelp = `List networks.`
+const listHelp = `List networks.
+
+The output format is one of table, json, yaml or a go template, for example
+--format '{{.IP}} {{.Status}}'.
+
+Filters are key=value pairs separated by commas, all of which have to match.
+The supported keys are status, bridge, ip (an address or a CIDR), pid and
+container (an ID or a hostname).`
 
 func (cmd *listCommand) Name() string      { return "ls" }
-func (cmd *listCommand) Args() string      { return "" }
-func (cmd *listCommand) ShortHelp() string { return listHelp }
+func (cmd *listCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *listCommand) ShortHelp() string { return `List networks.` }
 func (cmd *listCommand) LongHelp() string  { return listHelp }
 func (cmd *listCommand) Hidden() bool      { return false }
 
-func (cmd *listCommand) Register(fs *flag.FlagSet) {}
+func (cmd *listCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.format, "format", "table", "output format (table, json, yaml) or a go template")
+	fs.Var((*stringSlice)(&cmd.filters), "filter", "filter the networks by key=value, can be repeated")
+	fs.BoolVar(&cmd.quiet, "quiet", false, "only print the IP addresses")
+	fs.StringVar(&cmd.sort, "sort", "ip", "sort by ip, pid, status, container, rx or tx")
+	fs.BoolVar(&cmd.reverse, "reverse", false, "reverse the sort order")
+}
 
-type listCommand struct{}
+type listCommand struct {
+	format  string
+	filters []string
+	quiet   bool
+	sort    string
+	reverse bool
+}
 
 func (cmd *listCommand) Run(ctx context.Context, args []string) error {
-	networks, err := client.List()
+	filters, err := parseFilters(cmd.filters)
 	if err != nil {
 		return err
 	}
+	less, ok := listSorts[cmd.sort]
+	if !ok {
+		return fmt.Errorf("unknown sort key %q", cmd.sort)
+	}
+
+	networks, err := listNetworks(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeNamespaces(networks)
+
+	// Filter and sort the networks.
+	matched := []network.Network{}
+	for _, n := range networks {
+		if filters.match(n) {
+			matched = append(matched, n)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if cmd.reverse {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+
+	if cmd.quiet {
+		for _, n := range matched {
+			fmt.Println(n.IP.String())
+		}
+		return nil
+	}
+
+	switch cmd.format {
+	case "table", "":
+		printNetworks(matched)
+		return nil
+	case "json":
+		return writeJSON(os.Stdout, matched)
+	case "yaml":
+		return writeYAML(os.Stdout, matched)
+	}
 
-	// Print the networks.
+	tmpl, err := parseTemplate(cmd.format)
+	if err != nil {
+		return fmt.Errorf("parsing format template failed: %v", err)
+	}
+	for _, n := range matched {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, n); err != nil {
+			return fmt.Errorf("executing format template failed: %v", err)
+		}
+		fmt.Println(buf.String())
+	}
+
+	return nil
+}
+
+func printNetworks(networks []network.Network) {
 	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
-	fmt.Fprint(w, "IP\tLOCAL VETH\tPID\tSTATUS\tNS FD\n")
+	fmt.Fprint(w, "IP\tLOCAL VETH\tPID\tSTATUS\tNS FD\tRX\tTX\tEGRESS\tINGRESS\n")
 	for _, n := range networks {
-		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\n", n.IP.String(), n.VethPair.Attrs().Name, n.PID, n.Status, n.FD)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%s\t%s\t%s\t%s\n", n.IP.String(), n.VethPair.Attrs().Name, n.PID, n.Status, n.FD, formatBytes(float64(n.Stats.RxBytes)), formatBytes(float64(n.Stats.TxBytes)), formatRateLimit(n.Limits.Egress), formatRateLimit(n.Limits.Ingress))
 	}
 	w.Flush()
+}
 
-	return nil
+func formatRateLimit(l *network.RateLimit) string {
+	if l == nil {
+		return "-"
+	}
+	return l.String()
+}
+
+// listSorts holds the comparison for each sort key.
+var listSorts = map[string]func(a, b network.Network) bool{
+	"ip": func(a, b network.Network) bool {
+		return bytes.Compare(a.IP.To16(), b.IP.To16()) < 0
+	},
+	"pid": func(a, b network.Network) bool {
+		return a.PID < b.PID
+	},
+	"status": func(a, b network.Network) bool {
+		return a.Status < b.Status
+	},
+	"container": func(a, b network.Network) bool {
+		return containerName(a) < containerName(b)
+	},
+	"rx": func(a, b network.Network) bool {
+		return a.Stats.RxBytes < b.Stats.RxBytes
+	},
+	"tx": func(a, b network.Network) bool {
+		return a.Stats.TxBytes < b.Stats.TxBytes
+	},
+}
+
+// listFilter is a single key=value filter.
+type listFilter struct {
+	key   string
+	value string
+	cidr  *net.IPNet
+}
+
+type listFilters []listFilter
+
+func parseFilters(values []string) (listFilters, error) {
+	var filters listFilters
+	for _, value := range values {
+		for _, kv := range strings.Split(value, ",") {
+			if len(kv) == 0 {
+				continue
+			}
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("filter %q must be in the form key=value", kv)
+			}
+			f := listFilter{key: parts[0], value: parts[1]}
+
+			switch f.key {
+			case "status", "bridge", "container":
+			case "ip":
+				if strings.Contains(f.value, "/") {
+					_, cidr, err := net.ParseCIDR(f.value)
+					if err != nil {
+						return nil, fmt.Errorf("parsing filter %q failed: %v", kv, err)
+					}
+					f.cidr = cidr
+				} else if net.ParseIP(f.value) == nil {
+					return nil, fmt.Errorf("filter %q is not a valid IP address", kv)
+				}
+			case "pid":
+				if _, err := strconv.Atoi(f.value); err != nil {
+					return nil, fmt.Errorf("filter %q is not a valid pid", kv)
+				}
+			default:
+				return nil, fmt.Errorf("unknown filter key %q", f.key)
+			}
+
+			filters = append(filters, f)
+		}
+	}
+	return filters, nil
+}
+
+// match returns true if the network matches all the filters.
+func (filters listFilters) match(n network.Network) bool {
+	for _, f := range filters {
+		if !f.match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f listFilter) match(n network.Network) bool {
+	switch f.key {
+	case "status":
+		return n.Status == f.value
+	case "bridge":
+		return n.Bridge == f.value
+	case "container":
+		return n.ContainerID == f.value || n.Hostname == f.value
+	case "ip":
+		if f.cidr != nil {
+			return f.cidr.Contains(n.IP)
+		}
+		return n.IP.Equal(net.ParseIP(f.value))
+	case "pid":
+		return strconv.Itoa(n.PID) == f.value
+	}
+	return false
 }
@@ -5,10 +5,16 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/config"
+	"github.com/genuinetools/netns/daemon"
 	"github.com/genuinetools/netns/network"
 	"github.com/genuinetools/netns/version"
 	"github.com/genuinetools/pkg/cli"
@@ -29,9 +35,22 @@ var (
 	netOpt network.Opt
 	brOpt  bridge.Opt
 
-	debug bool
+	icc bool
+	nat string
 
-	client *network.Client
+	debug     bool
+	logFile   string
+	logFormat string
+
+	socket  string
+	timeout time.Duration
+
+	configFile   string
+	networkName  string
+	defaultRoute string
+
+	client   *network.Client
+	networks *network.Set
 )
 
 func main() {
@@ -46,36 +65,88 @@ func main() {
 	// Build the list of available commands.
 	p.Commands = []cli.Command{
 		&createCommand{},
+		&daemonCommand{},
+		&deleteCommand{},
+		&dnsCommand{},
+		&doctorCommand{},
+		&execCommand{},
+		&gcCommand{},
+		&impairCommand{},
+		&inspectCommand{},
 		&listCommand{},
+		&metricsCommand{},
+		&migrateCommand{},
 		&removeCommand{},
+		&stateCommand{},
+		&statsCommand{},
+	}
+	for i, c := range p.Commands {
+		p.Commands[i] = exitCommand{c}
 	}
 
 	// Setup the global flags.
 	p.FlagSet = flag.NewFlagSet("global", flag.ExitOnError)
 	p.FlagSet.StringVar(&ipfile, "ipfile", ".ip", "file in which to save the containers ip address")
 
-	p.FlagSet.StringVar(&netOpt.ContainerInterface, "iface", network.DefaultContainerInterface, "name of interface in the namespace")
 	p.FlagSet.StringVar(&netOpt.StateDir, "state-dir", defaultStateDir, "directory for saving state, used for ip allocation")
-
-	p.FlagSet.StringVar(&brOpt.Name, "bridge", defaultBridgeName, "name for bridge")
-	p.FlagSet.StringVar(&brOpt.IPAddr, "ip", defaultBridgeIP, "ip address for bridge")
-	p.FlagSet.IntVar(&brOpt.MTU, "mtu", bridge.DefaultMTU, "mtu for bridge")
+	registerNetworkFlags(p.FlagSet, &netOpt, &brOpt, &icc, &nat)
 
 	p.FlagSet.BoolVar(&debug, "d", false, "enable debug logging")
+	p.FlagSet.StringVar(&logFile, "log-file", "", "file to append the logs to, in addition to stderr")
+	p.FlagSet.StringVar(&logFormat, "log-format", "text", "log format (text, json)")
 	p.FlagSet.StringVar(&staticip, "static-ip", "", "Enable static IP Address")
+	p.FlagSet.StringVar(&socket, "socket", "", "unix socket of the daemon (default: netns.sock in the state directory)")
+	p.FlagSet.DurationVar(&timeout, "timeout", 0, "maximum duration of an operation on the networks, including waiting for the database (default: no limit)")
+
+	p.FlagSet.StringVar(&configFile, "config", "", "config file defining the settings and the networks (default: "+config.DefaultPath+" if it exists)")
+	p.FlagSet.StringVar(&networkName, "network", "", "names of the networks of the config file to attach the containers to, separated by commas, the first one is used by the other commands (default: the default network of the config file)")
+	p.FlagSet.StringVar(&defaultRoute, "default-route", "", "name of the network supplying the default route of the containers (default: the first network)")
+	globalFlags = flagNames(p.FlagSet)
 
 	// Set the before function.
 	p.Before = func(ctx context.Context) error {
+		// Fill in the flags that were not passed from the environment and
+		// the config file.
+		f, err := loadConfig(p.FlagSet)
+		if err != nil {
+			return err
+		}
+
 		// Set the log level.
 		if debug {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
 
+		// Setup the logger.
+		if err := setupLogging(logFile, logFormat); err != nil {
+			return err
+		}
+
+		if timeout < 0 {
+			return fmt.Errorf("timeout must be positive, got %s", timeout)
+		}
+
 		netOpt.BridgeName = brOpt.Name
+		brOpt.DisableICC = !icc
+
+		if len(socket) < 1 {
+			socket = filepath.Join(netOpt.StateDir, daemon.DefaultSocket)
+		}
+
+		brOpt.NAT, err = bridge.ParseNAT(nat)
+		if err != nil {
+			return err
+		}
 
 		// Create the network client.
-		var err error
 		client, err = network.New(netOpt)
+		if err != nil {
+			return err
+		}
+
+		// Set up the other networks of the config file the containers can
+		// be attached to.
+		networks, err = loadNetworks(p.FlagSet, f)
 		return err
 	}
 
@@ -86,9 +157,9 @@ func main() {
 			return err
 		}
 
-		ip, err := client.Create(hook, brOpt, staticip)
+		ip, err := createNetwork(ctx, hook, staticip)
 		if err != nil {
-			return err
+			return exitOnError(err)
 		}
 
 		// Save the ip to a file so other hooks can use it.
@@ -99,8 +170,62 @@ func main() {
 		return nil
 	}
 
-	// Run our program.
+	// Run our program, and exit with the code of its error once it is done.
 	p.Run()
+	exit()
+}
+
+// registerNetworkFlags defines the flags setting the options of a network in
+// fs.
+func registerNetworkFlags(fs *flag.FlagSet, netOpt *network.Opt, brOpt *bridge.Opt, icc *bool, nat *string) {
+	fs.StringVar(&netOpt.ContainerInterface, "iface", network.DefaultContainerInterface, "name of interface in the namespace")
+	fs.StringVar(&netOpt.PortPrefix, "port-prefix", network.DefaultPortPrefix, "prefix of the names of the veths on the host")
+
+	fs.StringVar(&brOpt.Name, "bridge", defaultBridgeName, "name for bridge")
+	fs.StringVar(&brOpt.IPAddr, "ip", defaultBridgeIP, "ip address for bridge, or auto to pick a subnet of the address pools that is free on the host")
+	fs.Var((*addressPools)(&brOpt.AddressPools), "address-pool", "subnets to pick from with --ip auto, can be repeated, defaults to the pools of docker (ex. base=172.17.0.0/12,size=16)")
+	fs.IntVar(&brOpt.MTU, "mtu", bridge.DefaultMTU, "mtu for bridge")
+	fs.BoolVar(icc, "icc", true, "allow traffic between containers on the bridge")
+	fs.StringVar(nat, "nat", bridge.NATMasquerade, "nat mode for traffic leaving the bridge (masquerade, snat:<ip>, none)")
+	fs.BoolVar(&brOpt.IPForward, "ip-forward", true, "enable ip forwarding in the kernel")
+	fs.Var((*iccRules)(&brOpt.ICCAllow), "icc-allow", "traffic allowed between containers when icc is disabled, can be repeated (ex. src=172.19.0.2,dst=172.19.0.3,port=80/tcp)")
+
+	fs.Var(&rateLimit{&netOpt.Limits.Egress}, "egress", "bandwidth limit for traffic sent by containers (ex. rate=100mbit,burst=1mb)")
+	fs.Var(&rateLimit{&netOpt.Limits.Ingress}, "ingress", "bandwidth limit for traffic received by containers (ex. rate=100mbit,burst=1mb)")
+
+	fs.Var(&netemProfile{&netOpt.Netem}, "netem", "network impairment profile for traffic received by containers (ex. delay=100ms,jitter=10ms,loss=1%)")
+
+	fs.IntVar(&netOpt.Link.TxQueueLen, "txqueuelen", 0, "length of the transmit queue of the veth pairs, the default of the kernel if 0")
+	fs.Var(&offloads{&netOpt.Link.Offloads}, "offloads", "offloads of the veth pairs (ex. tso=off,gso=off,tx-checksum=on,rx-checksum=on)")
+
+	fs.StringVar(&netOpt.DNS.Mode, "resolv", network.ResolvNone, "where to write the resolv.conf and hosts files for containers (none, rootfs, state)")
+	fs.Var((*stringSlice)(&netOpt.DNS.Nameservers), "dns", "nameserver for containers, can be repeated, defaults to the upstream nameservers of the host")
+	fs.Var((*stringSlice)(&netOpt.DNS.Search), "dns-search", "dns search domain for containers, can be repeated")
+	fs.Var((*stringSlice)(&netOpt.DNS.Options), "dns-opt", "dns resolver option for containers, can be repeated")
+}
+
+// setupLogging sets the format of the logs and the file they are appended
+// to.
+func setupLogging(file, format string) error {
+	switch format {
+	case "text":
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, must be one of text or json", format)
+	}
+
+	if len(file) < 1 {
+		return nil
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening log file %s failed: %v", file, err)
+	}
+	logrus.SetOutput(io.MultiWriter(os.Stderr, f))
+
+	return nil
 }
 
 // readHookData decodes stdin as HookState.
@@ -120,3 +245,118 @@ func readHookData() (hook configs.HookState, err error) {
 
 	return hook, nil
 }
+
+// iccRules is a flag.Value for repeated inter-container communication rules.
+type iccRules []bridge.ICCRule
+
+func (r *iccRules) String() string {
+	s := make([]string, 0, len(*r))
+	for _, rule := range *r {
+		s = append(s, rule.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func (r *iccRules) Set(value string) error {
+	rule, err := bridge.ParseICCRule(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, rule)
+	return nil
+}
+
+// addressPools is a flag.Value for repeated address pools.
+type addressPools []bridge.AddressPool
+
+func (p *addressPools) String() string {
+	s := make([]string, 0, len(*p))
+	for _, pool := range *p {
+		s = append(s, pool.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func (p *addressPools) Set(value string) error {
+	pool, err := bridge.ParseAddressPool(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, pool)
+	return nil
+}
+
+// rateLimit is a flag.Value for a bandwidth limit.
+type rateLimit struct {
+	l **network.RateLimit
+}
+
+func (r *rateLimit) String() string {
+	if r.l == nil || *r.l == nil {
+		return ""
+	}
+	return (*r.l).String()
+}
+
+func (r *rateLimit) Set(value string) error {
+	l, err := network.ParseRateLimit(value)
+	if err != nil {
+		return err
+	}
+	*r.l = l
+	return nil
+}
+
+// netemProfile is a flag.Value for a network impairment profile.
+type netemProfile struct {
+	n **network.Netem
+}
+
+func (n *netemProfile) String() string {
+	if n.n == nil || *n.n == nil {
+		return ""
+	}
+	return (*n.n).String()
+}
+
+func (n *netemProfile) Set(value string) error {
+	profile, err := network.ParseNetem(value)
+	if err != nil {
+		return err
+	}
+	*n.n = profile
+	return nil
+}
+
+// offloads is a flag.Value for the offloads of the veth pairs.
+type offloads struct {
+	o *network.Offloads
+}
+
+func (o *offloads) String() string {
+	if o.o == nil {
+		return ""
+	}
+	return o.o.String()
+}
+
+func (o *offloads) Set(value string) error {
+	parsed, err := network.ParseOffloads(value)
+	if err != nil {
+		return err
+	}
+	*o.o = parsed
+	return nil
+}
+
+// stringSlice is a flag.Value for repeated string flags.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const metricsHelp = `Print the metrics in the Prometheus text format.
+
+The metrics are read from the daemon when it is running. Otherwise only the
+size and the usage of the pool of ip addresses are reported, since the
+counters are kept by the daemon.
+
+With --textfile the metrics are written to a file for the textfile collector
+of the node exporter, once or every --interval.`
+
+func (cmd *metricsCommand) Name() string      { return "metrics" }
+func (cmd *metricsCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *metricsCommand) ShortHelp() string { return `Print the Prometheus metrics.` }
+func (cmd *metricsCommand) LongHelp() string  { return metricsHelp }
+func (cmd *metricsCommand) Hidden() bool      { return false }
+
+func (cmd *metricsCommand) Register(fs *flag.FlagSet) {
+	fs.StringVar(&cmd.textfile, "textfile", "", "file to write the metrics to, it should end in .prom")
+	fs.DurationVar(&cmd.interval, "interval", 0, "interval between writes of the textfile (default: write once)")
+}
+
+type metricsCommand struct {
+	textfile string
+	interval time.Duration
+}
+
+func (cmd *metricsCommand) Run(ctx context.Context, args []string) error {
+	if len(cmd.textfile) < 1 {
+		if cmd.interval != 0 {
+			return fmt.Errorf("interval can only be used with a textfile")
+		}
+		b, err := metricsText(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	if cmd.interval < 0 {
+		return fmt.Errorf("interval must be positive, got %s", cmd.interval)
+	}
+
+	for {
+		b, err := metricsText(ctx)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(cmd.textfile, b); err != nil {
+			return err
+		}
+
+		if cmd.interval == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cmd.interval):
+		}
+	}
+}
+
+// writeFileAtomic writes the file through a temporary file in the same
+// directory so readers never see a partial file.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("creating temporary file for %s failed: %v", path, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s failed: %v", f.Name(), err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("setting permissions of %s failed: %v", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing %s failed: %v", f.Name(), err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("renaming %s to %s failed: %v", f.Name(), path, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	counter := newCounterVec("test_requests_total", "Number of requests.", []string{"method", "path"})
+	gauge := newGaugeVec("test_pool_size", "Size of the pool.\nIn addresses.", []string{"bridge"})
+	hist := newHistogramVec("test_duration_seconds", "Duration of the requests.", []float64{1, 0.1}, []string{"step"})
+
+	r := NewRegistry()
+	r.register(counter)
+	r.register(gauge)
+	r.register(hist)
+
+	counter.Inc("GET", "/v1")
+	counter.Add(2, "DELETE", `/v1/"x"`)
+	counter.Inc("GET", "/v1")
+	gauge.Set(253, "netns0")
+	hist.Observe(0.05, "setup")
+	hist.Observe(0.5, "setup")
+	hist.Observe(3, "setup")
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_duration_seconds Duration of the requests.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{step="setup",le="0.1"} 1
+test_duration_seconds_bucket{step="setup",le="1"} 2
+test_duration_seconds_bucket{step="setup",le="+Inf"} 3
+test_duration_seconds_sum{step="setup"} 3.55
+test_duration_seconds_count{step="setup"} 3
+# HELP test_pool_size Size of the pool.\nIn addresses.
+# TYPE test_pool_size gauge
+test_pool_size{bridge="netns0"} 253
+# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{method="DELETE",path="/v1/\"x\""} 2
+test_requests_total{method="GET",path="/v1"} 2
+`
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	if v := counter.Value("GET", "/v1"); v != 2 {
+		t.Fatalf("expected counter to be 2, got %v", v)
+	}
+	if n := hist.Count("setup"); n != 3 {
+		t.Fatalf("expected 3 observations, got %d", n)
+	}
+}
+
+func TestLabelValuesMismatch(t *testing.T) {
+	counter := newCounterVec("test_mismatch_total", "Number of mismatches.", []string{"method", "path"})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for the wrong number of label values")
+		}
+	}()
+	counter.Inc("GET")
+}
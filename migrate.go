@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/genuinetools/netns/network"
+)
+
+const migrateHelp = `Renumber the bridge and its containers to a new subnet.
+
+The argument is the new address of the bridge with the prefix length of its
+subnet, ex. 10.20.0.1/16. Each container keeps its offset in the subnet, so
+172.19.0.5 becomes 10.20.0.5. The address of the bridge and its nat rules
+are changed, then the address and the default route of each running
+container, and finally the allocations in the database.
+
+Update --ip or the config file as well, it is only used when the bridge is
+created.`
+
+func (cmd *migrateCommand) Name() string      { return "migrate" }
+func (cmd *migrateCommand) Args() string      { return "[OPTIONS] <ip/prefix>" }
+func (cmd *migrateCommand) ShortHelp() string { return `Renumber the bridge to a new subnet.` }
+func (cmd *migrateCommand) LongHelp() string  { return migrateHelp }
+func (cmd *migrateCommand) Hidden() bool      { return false }
+
+func (cmd *migrateCommand) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.dryRun, "dry-run", false, "print the new addresses without changing anything")
+}
+
+type migrateCommand struct {
+	dryRun bool
+}
+
+func (cmd *migrateCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("must pass the new address of the bridge, ex. 10.20.0.1/16")
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	m, err := networks.Migrate(ctx, args[0], cmd.dryRun)
+	if m == nil {
+		return timeoutError(err)
+	}
+
+	verb := "renumbered"
+	if cmd.dryRun {
+		verb = "would renumber"
+	}
+	fmt.Printf("%s bridge %s from %s to %s\n", verb, m.Bridge, m.From.String(), m.To.String())
+	for _, r := range m.Addresses {
+		fmt.Printf("  %s -> %s (%s)\n", r.From.String(), r.To.String(), renumberingOwner(r))
+	}
+
+	return timeoutError(err)
+}
+
+// renumberingOwner describes the container of a renumbered address.
+func renumberingOwner(r network.Renumbering) string {
+	owner := fmt.Sprintf("pid %d", r.PID)
+	if len(r.ContainerID) > 0 {
+		owner = fmt.Sprintf("%s, %s", r.ContainerID, owner)
+	}
+	if !r.Running {
+		owner += ", not running"
+	}
+	return owner
+}
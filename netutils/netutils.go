@@ -2,7 +2,10 @@ package netutils
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
+	"strings"
 
 	"github.com/docker/libnetwork/iptables"
 	"github.com/sirupsen/logrus"
@@ -34,15 +37,94 @@ func GetInterfaceAddr(name string) (*net.IPNet, error) {
 
 // SetupNATOut adds NAT rules for outbound traffic with iptables.
 func SetupNATOut(cidr string, action iptables.Action) error {
-	masquerade := []string{
+	return setupNATOut(cidr, []string{"-j", "MASQUERADE"}, action)
+}
+
+// SetupSNATOut adds NAT rules for outbound traffic with iptables, rewriting
+// the source address of the traffic to source.
+func SetupSNATOut(cidr string, source net.IP, action iptables.Action) error {
+	return setupNATOut(cidr, []string{"-j", "SNAT", "--to-source", source.String()}, action)
+}
+
+// RemoveNATOut removes every masquerade and snat rule for outbound traffic
+// of the network added by SetupNATOut or SetupSNATOut.
+func RemoveNATOut(cidr string) error {
+	rules, err := NATRules()
+	if err != nil {
+		// Without iptables there are no rules to remove.
+		logrus.Debugf("removing NAT outbound for %s: %v", cidr, err)
+		return nil
+	}
+
+	for _, rule := range NATOutRules(rules, cidr) {
+		args := strings.Fields(rule)
+		args[0] = "-D"
+		if err := iptables.RawCombinedOutput(append([]string{"-t", string(iptables.Nat)}, args...)...); err != nil {
+			return fmt.Errorf("removing rule %q failed: %v", rule, err)
+		}
+	}
+
+	return nil
+}
+
+// NATOutRule returns the rule added by SetupNATOut for the network, or the
+// one added by SetupSNATOut when source is not nil, in the format of
+// iptables -S.
+func NATOutRule(cidr string, source net.IP) string {
+	target := "MASQUERADE"
+	if source != nil {
+		target = "SNAT --to-source " + source.String()
+	}
+	return fmt.Sprintf("-A POSTROUTING -s %s -j %s", subnet(cidr), target)
+}
+
+// NATOutRules returns the masquerade and snat rules for outbound traffic of
+// the network among rules, which are in the format of iptables -S.
+func NATOutRules(rules []string, cidr string) []string {
+	prefix := fmt.Sprintf("-A POSTROUTING -s %s -j ", subnet(cidr))
+
+	matches := []string{}
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule, prefix) {
+			continue
+		}
+		target := strings.Fields(strings.TrimPrefix(rule, prefix))
+		if (len(target) == 1 && target[0] == "MASQUERADE") ||
+			(len(target) == 3 && target[0] == "SNAT" && target[1] == "--to-source") {
+			matches = append(matches, rule)
+		}
+	}
+
+	return matches
+}
+
+// subnet returns the network of cidr the way iptables prints it.
+func subnet(cidr string) string {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return cidr
+	}
+	return n.String()
+}
+
+// NATOutExists returns true if the rule added by SetupNATOut exists, or the
+// one added by SetupSNATOut when source is not nil.
+func NATOutExists(cidr string, source net.IP) bool {
+	if source != nil {
+		return iptables.Exists(iptables.Nat, "POSTROUTING", "-s", cidr, "-j", "SNAT", "--to-source", source.String())
+	}
+	return iptables.Exists(iptables.Nat, "POSTROUTING", "-s", cidr, "-j", "MASQUERADE")
+}
+
+func setupNATOut(cidr string, target []string, action iptables.Action) error {
+	rule := append([]string{
 		"POSTROUTING", "-t", "nat",
 		"-s", cidr,
-		"-j", "MASQUERADE",
-	}
+	}, target...)
 
-	incl := append([]string{string(action)}, masquerade...)
+	incl := append([]string{string(action)}, rule...)
 	if _, err := iptables.Raw(
-		append([]string{"-C"}, masquerade...)...,
+		append([]string{"-C"}, rule...)...,
 	); err != nil || action == iptables.Delete {
 		if output, err := iptables.Raw(incl...); err != nil {
 			return err
@@ -56,3 +138,135 @@ func SetupNATOut(cidr string, action iptables.Action) error {
 
 	return nil
 }
+
+// NATRules returns the rules of the iptables nat table in the format of
+// iptables -S.
+func NATRules() ([]string, error) {
+	output, err := iptables.Raw("-t", string(iptables.Nat), "-S")
+	if err != nil {
+		return nil, fmt.Errorf("listing nat rules failed: %v", err)
+	}
+
+	rules := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		// Skip the chain definitions.
+		if strings.HasPrefix(line, "-A ") {
+			rules = append(rules, line)
+		}
+	}
+
+	return rules, nil
+}
+
+// iccChain returns the name of the iptables chain holding the inter-container
+// communication rules for a bridge.
+func iccChain(bridgeName string) string {
+	return "NETNS-ICC-" + bridgeName
+}
+
+// SetupICC configures the iptables rules controlling the traffic between
+// containers on the same bridge. When enable is true all the traffic is
+// allowed and any existing isolation rules are removed. When enable is false
+// the traffic between bridge ports is dropped, except for the rules in allow,
+// which are lists of iptables match arguments.
+//
+// The rules live in their own chain that is rebuilt on every call so the
+// function is safe to run multiple times.
+func SetupICC(bridgeName string, enable bool, allow [][]string) error {
+	chain := iccChain(bridgeName)
+	jump := []string{
+		"-i", bridgeName,
+		"-o", bridgeName,
+		"-j", chain,
+	}
+
+	if enable {
+		// Remove the jump to our chain if it exists.
+		if iptables.Exists(iptables.Filter, "FORWARD", jump...) {
+			if err := iptables.RawCombinedOutput(append([]string{"-D", "FORWARD"}, jump...)...); err != nil {
+				return fmt.Errorf("removing jump to chain %s failed: %v", chain, err)
+			}
+		}
+
+		// Remove the chain itself if it exists.
+		if iptables.ExistChain(chain, iptables.Filter) {
+			if err := iptables.RemoveExistingChain(chain, iptables.Filter); err != nil {
+				return fmt.Errorf("removing chain %s failed: %v", chain, err)
+			}
+		}
+
+		return nil
+	}
+
+	// Bridged traffic only passes through iptables when br_netfilter is
+	// loaded and enabled.
+	if err := SetSysctl("net/bridge/bridge-nf-call-iptables", "1"); err != nil {
+		return fmt.Errorf("enabling bridge netfilter failed, is the br_netfilter module loaded? %v", err)
+	}
+
+	// Create the chain if it does not exist and flush it so we start with
+	// a clean slate.
+	if _, err := iptables.NewChain(chain, iptables.Filter, false); err != nil {
+		return fmt.Errorf("creating chain %s failed: %v", chain, err)
+	}
+	if err := iptables.RawCombinedOutput("-t", string(iptables.Filter), "-F", chain); err != nil {
+		return fmt.Errorf("flushing chain %s failed: %v", chain, err)
+	}
+
+	// Allow the replies to connections that were allowed.
+	rules := [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	for _, a := range allow {
+		rules = append(rules, append(append([]string{}, a...), "-j", "ACCEPT"))
+	}
+	rules = append(rules, []string{"-j", "DROP"})
+
+	for _, rule := range rules {
+		if err := iptables.RawCombinedOutput(append([]string{"-A", chain}, rule...)...); err != nil {
+			return fmt.Errorf("adding rule %v to chain %s failed: %v", rule, chain, err)
+		}
+	}
+
+	// Jump to our chain for all the traffic between bridge ports.
+	if !iptables.Exists(iptables.Filter, "FORWARD", jump...) {
+		if err := iptables.RawCombinedOutput(append([]string{"-I", "FORWARD"}, jump...)...); err != nil {
+			return fmt.Errorf("adding jump to chain %s failed: %v", chain, err)
+		}
+	}
+
+	return nil
+}
+
+// ICCExists returns true if the rules added by SetupICC to isolate the
+// containers on the bridge exist.
+func ICCExists(bridgeName string) bool {
+	chain := iccChain(bridgeName)
+	return iptables.ExistChain(chain, iptables.Filter) &&
+		iptables.Exists(iptables.Filter, "FORWARD", "-i", bridgeName, "-o", bridgeName, "-j", chain)
+}
+
+// GetSysctl returns the value of the kernel parameter with the given key, for
+// example "net/ipv4/ip_forward".
+func GetSysctl(key string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join("/proc/sys", key))
+	if err != nil {
+		return "", fmt.Errorf("getting sysctl %s failed: %v", key, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// SetSysctl sets the value of the kernel parameter with the given key, for
+// example "net/ipv4/ip_forward".
+func SetSysctl(key, value string) error {
+	// Return early if the value is already set.
+	if v, err := GetSysctl(key); err == nil && v == value {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(filepath.Join("/proc/sys", key), []byte(value), 0644); err != nil {
+		return fmt.Errorf("setting sysctl %s to %s failed: %v", key, value, err)
+	}
+
+	return nil
+}
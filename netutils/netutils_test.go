@@ -0,0 +1,33 @@
+package netutils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNATOutRules(t *testing.T) {
+	rules := []string{
+		"-P POSTROUTING ACCEPT",
+		"-A POSTROUTING -s 172.19.0.0/16 -j MASQUERADE",
+		"-A POSTROUTING -s 172.19.0.0/16 -j SNAT --to-source 192.0.2.10",
+		"-A POSTROUTING -s 172.19.0.0/16 ! -o netns0 -j MASQUERADE",
+		"-A POSTROUTING -s 172.19.0.0/24 -j MASQUERADE",
+		"-A POSTROUTING -s 10.20.0.0/24 -j SNAT --to-source 192.0.2.11",
+	}
+
+	// The rules of every mode are found for the address of the bridge, so
+	// renumbering it removes them whatever the mode was.
+	expected := []string{
+		NATOutRule("172.19.0.1/16", nil),
+		NATOutRule("172.19.0.1/16", net.ParseIP("192.0.2.10")),
+	}
+	if got := NATOutRules(rules, "172.19.0.1/16"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %q got %q", expected, got)
+	}
+
+	expected = []string{"-A POSTROUTING -s 10.20.0.0/24 -j SNAT --to-source 192.0.2.11"}
+	if got := NATOutRules(rules, "10.20.0.1/24"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %q got %q", expected, got)
+	}
+}
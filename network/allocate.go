@@ -1,6 +1,7 @@
 package network
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"math/big"
@@ -8,37 +9,50 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/erikh/ping"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	bolt "go.etcd.io/bbolt"
 )
 
 // AllocateIP returns an unused IP for a specific process ID
-// and saves it in the database.
-func (c *Client) AllocateIP(pid int) (ip net.IP, err error) {
+// and saves it in the database. It gives up when the context is done.
+func (c *Client) AllocateIP(ctx context.Context, pid int) (ip net.IP, err error) {
 	// Refresh the ipMap.
 	ipMap, err := c.getIPMap()
 	if err != nil {
 		return nil, err
 	}
 
-	// Find the last IP used by the allocator.
+	// Find the last IP used by the allocator and the ones allocated.
 	lastip := c.ipNet.IP
+	allocated := map[string]bool{}
 	if err := c.db.View(func(tx *bolt.Tx) error {
-		if result := tx.Bucket(ipBucket).Get([]byte{0}); result != nil {
-			lastip = result
+		b := tx.Bucket(ipBucket)
+		if result := b.Get([]byte{0}); result != nil {
+			lastip = append(net.IP(nil), result...)
 		}
-		return nil
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) > 1 {
+				allocated[net.IP(k).String()] = true
+			}
+			return nil
+		})
 	}); err != nil {
 		return nil, err
 	}
 
-	bridgeAddrs, _ := c.bridge.Addrs()
+	var bridgeAddrs []netlink.Addr
+	if link, err := c.kernel.LinkByIndex(c.bridge.Index); err == nil {
+		bridgeAddrs, _ = c.kernel.AddrList(link, netlink.FAMILY_ALL)
+	}
 
 	ip = increaseIP(lastip)
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("finding a free ip in network %s failed: %w", c.ipNet.String(), err)
+		}
+
 		switch {
 		case !c.ipNet.Contains(ip):
 			ip = c.ipNet.IP
@@ -46,22 +60,34 @@ func (c *Client) AllocateIP(pid int) (ip net.IP, err error) {
 		// Skip bridge IP.
 		case func() bool {
 			for _, addr := range bridgeAddrs {
-				itfIP, _, _ := net.ParseCIDR(addr.String())
-				if ip.Equal(itfIP) {
+				if ip.Equal(addr.IP) {
 					return true
 				}
 			}
 			return false
 		}():
-			logrus.Debugf("[ipallocator] ip %s belongs to the bridge. Skipped.", ip.String())
+			c.log.Debugf("[ipallocator] ip %s belongs to the bridge. Skipped.", ip.String())
 
 		// Skip broadcast ip
 		case !isUnicastIP(ip, c.ipNet.Mask):
-			logrus.Debugf("[ipallocator] ip %s is not unicast. Skipped.", ip.String())
+			c.log.Debugf("[ipallocator] ip %s is not unicast. Skipped.", ip.String())
+
+		// Skip the ips allocated to other containers.
+		case allocated[ip.String()]:
+			c.log.Debugf("[ipallocator] ip %s is allocated in the database. Skipped.", ip.String())
+
+		// Skip the ips in the neighbor table of the bridge.
+		case func() bool { _, ok := ipMap[ip.String()]; return ok }():
+			probesTotal.Inc("arp", "in_use")
+			c.log.Debugf("[ipallocator] ip %s is in the neighbor table. Skipped.", ip.String())
+
+		default:
+			probesTotal.Inc("arp", "free")
 
-		case !func() bool { _, ok := ipMap[ip.String()]; return ok }():
 			// use ICMP to check if the IP is in use, final sanity check.
-			if !ping.Ping(&net.IPAddr{IP: ip, Zone: ""}, 150*time.Millisecond) {
+			if !c.kernel.Ping(ip, 150*time.Millisecond) {
+				probesTotal.Inc("icmp", "free")
+
 				// save the new ip in the database
 				if err := c.db.Update(func(tx *bolt.Tx) error {
 					if err := tx.Bucket(ipBucket).Put(ip, []byte(strconv.Itoa(pid))); err != nil {
@@ -74,12 +100,13 @@ func (c *Client) AllocateIP(pid int) (ip net.IP, err error) {
 				}); err != nil {
 					return nil, fmt.Errorf("adding ip %s to database for %d failed: %v", ip.String(), pid, err)
 				}
-				logrus.Debugf("[ipallocator] ip %s is selected.", ip.String())
+				c.log.Debugf("[ipallocator] ip %s is selected.", ip.String())
 
 				return ip, nil
 			}
 
-			logrus.Debugf("[ipallocator] ip %s is already allocated. Skipped.", ip.String())
+			probesTotal.Inc("icmp", "in_use")
+			c.log.Debugf("[ipallocator] ip %s is already allocated. Skipped.", ip.String())
 		}
 
 		ip = increaseIP(ip)
@@ -89,7 +116,23 @@ func (c *Client) AllocateIP(pid int) (ip net.IP, err error) {
 		}
 	}
 
-	return nil, fmt.Errorf("could not find a suitable IP in network %s", c.ipNet.String())
+	return nil, &PoolExhaustedError{Network: c.ipNet}
+}
+
+// reserveIP saves a static ip in the database for pid. It returns an
+// IPConflictError if the ip is allocated to another process.
+func (c *Client) reserveIP(ip net.IP, pid int) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ipBucket)
+		if v := b.Get(ip); v != nil && string(v) != strconv.Itoa(pid) {
+			owner, _ := strconv.Atoi(string(v))
+			return &IPConflictError{IP: ip, PID: owner}
+		}
+		if err := b.Put(ip, []byte(strconv.Itoa(pid))); err != nil {
+			return fmt.Errorf("adding ip %s to database for %d failed: %v", ip.String(), pid, err)
+		}
+		return nil
+	})
 }
 
 func (c *Client) getIPMap() (map[string]struct{}, error) {
@@ -99,20 +142,25 @@ func (c *Client) getIPMap() (map[string]struct{}, error) {
 		err  error
 	)
 	if c.ipNet.IP.To4() == nil {
-		list, err = netlink.NeighList(c.bridge.Index, netlink.FAMILY_V6)
+		list, err = c.kernel.NeighList(c.bridge.Index, netlink.FAMILY_V6)
 		if err != nil {
 			return nil, fmt.Errorf("cannot retrieve IPv6 neighbor information for interface %s: %v", c.bridge.Name, err)
 		}
 	} else {
-		list, err = netlink.NeighList(c.bridge.Index, netlink.FAMILY_V4)
+		list, err = c.kernel.NeighList(c.bridge.Index, netlink.FAMILY_V4)
 		if err != nil {
 			return nil, fmt.Errorf("cannot retrieve IPv4 neighbor information for interface %s: %v", c.bridge.Name, err)
 		}
 	}
 
+	// Key the map by ip, leaving out the entries of the neighbors that did
+	// not answer.
 	ipMap := map[string]struct{}{}
 	for _, entry := range list {
-		ipMap[entry.String()] = struct{}{}
+		if entry.State&(netlink.NUD_FAILED|netlink.NUD_INCOMPLETE) != 0 {
+			continue
+		}
+		ipMap[entry.IP.String()] = struct{}{}
 	}
 
 	return ipMap, nil
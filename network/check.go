@@ -0,0 +1,177 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Inconsistency is a difference between the database and the kernel.
+type Inconsistency struct {
+	IP     net.IP `json:"ip,omitempty"`
+	PID    int    `json:"pid,omitempty"`
+	Veth   string `json:"veth,omitempty"`
+	Reason string `json:"reason"`
+	// Repaired is true if the problem was fixed.
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+func (i Inconsistency) String() string {
+	var subject []string
+	if i.IP != nil {
+		subject = append(subject, i.IP.String())
+	}
+	if len(i.Veth) > 0 {
+		subject = append(subject, i.Veth)
+	}
+	if i.PID > 0 {
+		subject = append(subject, "pid "+strconv.Itoa(i.PID))
+	}
+	s := i.Reason
+	if len(subject) > 0 {
+		s = fmt.Sprintf("%s: %s", strings.Join(subject, " "), i.Reason)
+	}
+	if i.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// CheckDB opens the database for writing and checks its consistency. It
+// returns ErrDatabaseLocked if the database could not be opened within
+// timeout because another process holds it.
+func (c *Client) CheckDB(timeout time.Duration) error {
+	if _, err := os.Stat(c.dbPath); os.IsNotExist(err) {
+		return ErrDatabaseDoesNotExist
+	}
+
+	db, err := bolt.Open(c.dbPath, 0666, &bolt.Options{
+		Timeout: timeout,
+	})
+	if err == bolt.ErrTimeout {
+		return ErrDatabaseLocked
+	}
+	if err != nil {
+		return fmt.Errorf("opening database at %s failed: %v", c.dbPath, err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		// Read all the errors so the check finishes before the transaction.
+		var checkErr error
+		for err := range tx.Check() {
+			if checkErr == nil {
+				checkErr = fmt.Errorf("database at %s is corrupted: %v", c.dbPath, err)
+			}
+		}
+		return checkErr
+	})
+}
+
+// DatabasePath returns the path of the database.
+func (c *Client) DatabasePath() string {
+	return c.dbPath
+}
+
+// DatabaseHolders returns the pids of the processes that have the database
+// open.
+func (c *Client) DatabaseHolders() ([]int, error) {
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return nil, err
+	}
+
+	self := os.Getpid()
+	seen := map[int]bool{}
+	pids := []int{}
+	for _, fd := range fds {
+		target, err := os.Readlink(fd)
+		if err != nil || target != c.dbPath {
+			continue
+		}
+
+		pid, err := strconv.Atoi(strings.Split(fd, "/")[2])
+		if err != nil || pid == self || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+// Check compares the allocations in the database with the processes and
+// the links on the host.
+func (c *Client) Check(ctx context.Context) ([]Inconsistency, error) {
+	allocations, err := c.Allocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []Inconsistency{}
+	allocated := map[string]bool{}
+	holders := &namespaceHolders{kernel: c.kernel}
+	for _, a := range allocations {
+		veth := c.vethName(a.PID)
+		allocated[veth] = true
+
+		if status := c.statusWith(a, holders); isGone(status) {
+			reason := "the container process is gone but the ip is still allocated"
+			if status == StatusPIDReused {
+				reason = "the pid was reused by another process but the ip is still allocated"
+			}
+			problems = append(problems, Inconsistency{
+				IP:     a.IP,
+				PID:    a.PID,
+				Reason: reason,
+			})
+			continue
+		}
+
+		if _, err := c.kernel.LinkByName(veth); err != nil {
+			problems = append(problems, Inconsistency{
+				IP:     a.IP,
+				PID:    a.PID,
+				Veth:   veth,
+				Reason: "the local side of the veth pair is missing",
+			})
+		}
+	}
+
+	// Find the veth pairs on the bridge that are not allocated.
+	br, err := c.kernel.LinkByName(c.opt.BridgeName)
+	if err != nil {
+		// Without the bridge there is nothing attached to it.
+		return problems, nil
+	}
+	links, err := c.kernel.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("listing links failed: %v", err)
+	}
+	for _, link := range links {
+		attrs := link.Attrs()
+		if link.Type() != "veth" || attrs.MasterIndex != br.Attrs().Index || allocated[attrs.Name] {
+			continue
+		}
+		pid, ok := c.vethPID(attrs.Name)
+		if !ok {
+			continue
+		}
+
+		problems = append(problems, Inconsistency{
+			Veth:   attrs.Name,
+			PID:    pid,
+			Reason: "the veth is attached to the bridge but not allocated",
+		})
+	}
+
+	return problems, nil
+}
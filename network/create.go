@@ -1,63 +1,175 @@
 package network
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
-	"runtime"
+	"os"
+	"syscall"
 
 	"github.com/genuinetools/netns/bridge"
-	"github.com/genuinetools/netns/netutils"
+	"github.com/genuinetools/netns/kernel"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
-	"github.com/vishvananda/netns"
 	bolt "go.etcd.io/bbolt"
 )
 
 // Create returns a container IP that was created with the given bridge name,
 // the settings from the HookState passed, and the bridge options.
-func (c *Client) Create(hook configs.HookState, brOpt bridge.Opt, staticip string) (net.IP, error) {
-	var nsip net.IP
+func (c *Client) Create(ctx context.Context, hook configs.HookState, brOpt bridge.Opt, staticip string) (net.IP, error) {
+	return c.create(ctx, hook, brOpt, staticip, c.opt.ContainerInterface, true)
+}
+
+// create attaches the container to the network with an interface named
+// iface. The default route and the name resolution files of the container
+// are only set up if defaultRoute is true, the other networks only get the
+// route to their subnet.
+func (c *Client) create(ctx context.Context, hook configs.HookState, brOpt bridge.Opt, staticip, iface string, defaultRoute bool) (nsip net.IP, err error) {
+	// Log the outcome and the duration of each step when we are done.
+	st := newSteps(c.opt.BridgeName, logrus.WithFields(logrus.Fields{
+		"container": hook.ID,
+		"pid":       hook.Pid,
+		"bridge":    c.opt.BridgeName,
+	}))
+	defer func() {
+		st.summary("create", err)
+		if err == nil {
+			allocationsTotal.Inc(c.opt.BridgeName)
+		}
+	}()
+
+	c.log = st.begin("setup")
+
 	// Open the database.
-	if err := c.openDB(false); err != nil {
+	if err := c.openDB(ctx, false); err != nil {
 		return nil, err
 	}
 	defer c.closeDB()
 
+	// Undo the steps that were done if a later one fails so a failed create
+	// does not leave a veth pair or an allocated ip behind.
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if uerr := undo[i](); uerr != nil {
+				c.log.Warnf("rolling back failed create for pid %d failed: %v", hook.Pid, uerr)
+			}
+		}
+	}()
+
+	// Get the bandwidth limits and impairment profile for the container.
+	limits, err := c.limits(hook.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := c.netem(hook.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	linkOpt, err := c.linkOpt(hook.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize the bridge.
-	var err error
+	c.log = st.begin("bridge_init")
+	if brOpt.Kernel == nil {
+		brOpt.Kernel = c.kernel
+	}
 	c.bridge, err = bridge.Init(brOpt)
 	if err != nil {
 		return nil, err
 	}
 
+	// Release the networks left by the containers that had the pid, the
+	// links of this one are named after it.
+	if err := c.releaseStale(hook.Pid); err != nil {
+		return nil, err
+	}
+
 	// Create and attach local name to the bridge.
+	c.log = st.begin("veth_add")
 	localVethPair, err := c.vethPair(hook.Pid, c.opt.BridgeName)
 	if err != nil {
 		return nil, fmt.Errorf("getting vethpair for pid %d failed: %v", hook.Pid, err)
 	}
-	if err := netlink.LinkAdd(localVethPair); err != nil {
+	// Both sides get the MTU of the bridge unless it is overridden, so the
+	// packets are not fragmented or dropped on the way.
+	localVethPair.MTU = linkOpt.MTU
+	if localVethPair.MTU < 1 {
+		localVethPair.MTU = c.bridge.MTU
+	}
+	if linkOpt.TxQueueLen > 0 {
+		localVethPair.TxQLen = linkOpt.TxQueueLen
+	}
+	if err := c.kernel.LinkAdd(localVethPair); err != nil {
+		if errors.Is(err, syscall.EEXIST) {
+			return nil, &LinkExistsError{Name: localVethPair.Name}
+		}
 		return nil, fmt.Errorf("create veth pair named [ %#v ] failed: %v", localVethPair, err)
 	}
+	undo = append(undo, func() error {
+		// Deleting the local side deletes the peer as well.
+		if err := c.kernel.LinkDel(localVethPair); err != nil && !kernel.IsLinkNotFound(err) {
+			return fmt.Errorf("deleting link %s failed: %v", localVethPair.Name, err)
+		}
+		return nil
+	})
 
 	// Get the peer link.
-	peer, err := netlink.LinkByName(localVethPair.PeerName)
+	peer, err := c.kernel.LinkByName(localVethPair.PeerName)
 	if err != nil {
 		return nil, fmt.Errorf("getting peer interface %s failed: %v", localVethPair.PeerName, err)
 	}
 
+	// Set the offloads of both sides, they are kept when the peer is moved.
+	if err := setOffloads(c.kernel, linkOpt.Offloads, localVethPair, peer); err != nil {
+		return nil, fmt.Errorf("setting offloads for pid %d failed: %v", hook.Pid, err)
+	}
+
 	// Put peer interface into the network namespace of specified PID.
-	if err := netlink.LinkSetNsPid(peer, hook.Pid); err != nil {
-		return nil, fmt.Errorf("adding peer interface to network namespace of pid %d failed: %v", hook.Pid, err)
+	if err := c.kernel.LinkSetNsPid(peer, hook.Pid); err != nil {
+		return nil, c.namespaceError(hook.Pid, fmt.Errorf("adding peer interface to network namespace of pid %d failed: %v", hook.Pid, err))
 	}
 
 	// Bring the veth pair up.
-	if err := netlink.LinkSetUp(localVethPair); err != nil {
+	if err := c.kernel.LinkSetUp(localVethPair); err != nil {
 		return nil, fmt.Errorf("bringing local veth pair [ %#v ] up failed: %v", localVethPair, err)
 	}
 
+	// Limit and impair the traffic going to the container.
+	if err := setQdiscs(c.kernel, localVethPair, limits.Ingress, profile); err != nil {
+		return nil, fmt.Errorf("setting ingress qdiscs for pid %d failed: %v", hook.Pid, err)
+	}
+
+	// Limit the traffic sent by the container on the host, where the
+	// container cannot remove the limit.
+	// The ifb device is removed as well if setting it up fails halfway.
+	ifbName := c.ifbName(hook.Pid)
+	undo = append(undo, func() error {
+		return deleteIfb(c.kernel, ifbName)
+	})
+	if err := setEgressLimit(c.kernel, localVethPair, ifbName, limits.Egress); err != nil {
+		return nil, fmt.Errorf("setting egress limit for pid %d failed: %v", hook.Pid, err)
+	}
+	var ifbIndex int
+	if limits.Egress != nil {
+		ifb, err := c.kernel.LinkByName(ifbName)
+		if err != nil {
+			return nil, fmt.Errorf("getting ifb device %s failed: %v", ifbName, err)
+		}
+		ifbIndex = ifb.Attrs().Index
+	}
+
 	// Check the bridge IPNet as it may be different than the default.
-	brNet, err := netutils.GetInterfaceAddr(c.opt.BridgeName)
+	c.log = st.begin("allocate")
+	brNet, err := kernel.InterfaceAddr(c.kernel, c.opt.BridgeName)
 	if err != nil {
 		return nil, fmt.Errorf("retrieving IP/network of bridge %s failed: %v", c.opt.BridgeName, err)
 	}
@@ -74,6 +186,9 @@ func (c *Client) Create(hook configs.HookState, brOpt bridge.Opt, staticip strin
 		if _, err := tx.CreateBucketIfNotExists(ipBucket); err != nil {
 			return fmt.Errorf("creating bucket %s failed: %v", ipBucket, err)
 		}
+		if _, err := tx.CreateBucketIfNotExists(allocationBucket); err != nil {
+			return fmt.Errorf("creating bucket %s failed: %v", allocationBucket, err)
+		}
 
 		return nil
 	}); err != nil {
@@ -82,13 +197,26 @@ func (c *Client) Create(hook configs.HookState, brOpt bridge.Opt, staticip strin
 
 	if staticip != "" {
 		nsip = net.ParseIP(staticip)
+		if nsip == nil || !ipNet.Contains(nsip) {
+			return nil, fmt.Errorf("static ip %s is not an address of network %s", staticip, ipNet.String())
+		}
+		// The ips are saved with the length of the ip of the network.
+		if len(ipNet.IP) == net.IPv4len {
+			nsip = nsip.To4()
+		}
+		if err := c.reserveIP(nsip, hook.Pid); err != nil {
+			return nil, fmt.Errorf("reserving static ip failed: %w", err)
+		}
 	} else {
-		nsip, err = c.AllocateIP(hook.Pid)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("allocating ip address failed: %v", err)
+		nsip, err = c.AllocateIP(ctx, hook.Pid)
+		if err != nil {
+			return nil, fmt.Errorf("allocating ip address failed: %w", err)
+		}
 	}
+	allocated := nsip
+	undo = append(undo, func() error {
+		return c.releaseIP(allocated)
+	})
 
 	newIP := &net.IPNet{
 		IP:   nsip,
@@ -96,98 +224,226 @@ func (c *Client) Create(hook configs.HookState, brOpt bridge.Opt, staticip strin
 	}
 
 	// Configure the interface in the network namespace.
-	if err := c.configureInterface(localVethPair.PeerName, hook.Pid, newIP, ip.String()); err != nil {
-		return nil, err
+	c.log = st.begin("configure")
+	var gateway net.IP
+	if defaultRoute {
+		gateway = ip
 	}
-
-	logrus.Debugf("attached veth (%s) to bridge (%s)", localVethPair.Name, c.opt.BridgeName)
-	return nsip, nil
-}
-
-// configureInterface configures the network interface in the network namespace.
-func (c *Client) configureInterface(name string, pid int, addr *net.IPNet, gatewayIP string) error {
-	// Lock the OS Thread so we don't accidentally switch namespaces.
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	// Save the current network namespace.
-	origns, err := netns.Get()
-	if err != nil {
-		return fmt.Errorf("getting current network namespace failed: %v", err)
+	if err := c.configureInterface(localVethPair.PeerName, iface, hook.Pid, newIP, gateway); err != nil {
+		return nil, c.namespaceError(hook.Pid, err)
 	}
-	defer origns.Close()
 
-	// Get the namespace from the pid.
-	newns, err := netns.GetFromPid(pid)
-	if err != nil {
-		return fmt.Errorf("getting network namespace for pid %d failed: %v", pid, err)
+	// Write the name resolution files for the container.
+	var dnsDir string
+	if defaultRoute {
+		var err error
+		dnsDir, err = c.writeDNSFiles(hook, nsip)
+		if len(dnsDir) > 0 {
+			undo = append(undo, func() error {
+				return os.RemoveAll(dnsDir)
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("writing name resolution files for pid %d failed: %v", hook.Pid, err)
+		}
 	}
-	defer newns.Close()
 
-	// Enter the namespace.
-	if err := netns.Set(newns); err != nil {
-		return fmt.Errorf("entering network namespace failed: %v", err)
+	// Save the allocation record with the process, the pid alone does not
+	// tell whether the container is still running.
+	c.log = st.begin("save")
+	a := Allocation{
+		IP:          nsip,
+		ContainerID: hook.ID,
+		Hostname:    containerHostname(hook.Bundle),
+		PID:         hook.Pid,
+		Limits:      limits,
+		Netem:       profile,
+		Interface:   iface,
+		DNSDir:      dnsDir,
+		VethIndex:   localVethPair.Index,
+		IfbIndex:    ifbIndex,
+	}
+	if p, err := c.kernel.Process(hook.Pid); err == nil {
+		a.Process = &p
+	} else {
+		c.log.Warnf("getting process %d failed: %v", hook.Pid, err)
 	}
+	if err := c.saveAllocation(nsip, a); err != nil {
+		return nil, err
+	}
+
+	c.log.Debugf("attached veth (%s) to bridge (%s)", localVethPair.Name, c.opt.BridgeName)
+	return nsip, nil
+}
+
+// configureInterface configures the network interface in the network namespace
+// and renames it to iface.
+func (c *Client) configureInterface(name, iface string, pid int, addr *net.IPNet, gateway net.IP) error {
+	return c.kernel.InNamespace(pid, func() error {
+		return c.configureLink(name, iface, addr, gateway)
+	})
+}
 
+// configureLink configures the network interface in the current network
+// namespace. The default route goes through the gateway if it is not nil.
+func (c *Client) configureLink(name, newName string, addr *net.IPNet, gateway net.IP) error {
 	// Find the network interface identified by the name.
-	iface, err := netlink.LinkByName(name)
+	iface, err := c.kernel.LinkByName(name)
 	if err != nil {
 		return fmt.Errorf("getting link %s failed: %v", name, err)
 	}
 
 	// Bring the interface down.
-	if err := netlink.LinkSetDown(iface); err != nil {
+	if err := c.kernel.LinkSetDown(iface); err != nil {
 		return fmt.Errorf("bringing interface [ %#v ] down failed: %v", iface, err)
 	}
 
-	// Change the interface name to eth0 in the namespace.
-	if err := netlink.LinkSetName(iface, c.opt.ContainerInterface); err != nil {
-		return fmt.Errorf("renaming interface %s to %s failed: %v", name, c.opt.ContainerInterface, err)
+	// Change the interface name in the namespace, ex. eth0.
+	if err := c.kernel.LinkSetName(iface, newName); err != nil {
+		return fmt.Errorf("renaming interface %s to %s failed: %v", name, newName, err)
 	}
 
 	// Add the IP address.
 	ipAddr := &netlink.Addr{IPNet: addr, Label: ""}
-	if err := netlink.AddrAdd(iface, ipAddr); err != nil {
+	if err := c.kernel.AddrAdd(iface, ipAddr); err != nil {
 		return fmt.Errorf("setting %s interface ip to %s failed: %v", name, addr.String(), err)
 	}
 
 	// Bring the interface up.
-	if err := netlink.LinkSetUp(iface); err != nil {
+	if err := c.kernel.LinkSetUp(iface); err != nil {
 		return fmt.Errorf("bringing interface [ %#v ] up failed: %v", iface, err)
 	}
 
-	// Add the gateway route.
-	gw := net.ParseIP(gatewayIP)
-	err = netlink.RouteAdd(&netlink.Route{
+	// Add the gateway route, the route to the subnet comes with the
+	// address.
+	if gateway == nil {
+		return nil
+	}
+	err = c.kernel.RouteAdd(&netlink.Route{
 		Scope:     netlink.SCOPE_UNIVERSE,
 		LinkIndex: iface.Attrs().Index,
-		Gw:        gw,
+		Gw:        gateway,
 	})
 	if err != nil {
-		return fmt.Errorf("adding route %s to interface %s failed: %v", gw.String(), name, err)
+		return fmt.Errorf("adding route %s to interface %s failed: %v", gateway.String(), name, err)
+	}
+
+	return nil
+}
+
+// limits returns the bandwidth limits for a container from the defaults and
+// the container annotations.
+func (c *Client) limits(annotations map[string]string) (Limits, error) {
+	limits := c.opt.Limits
+
+	if s, ok := annotations[AnnotationEgress]; ok {
+		l, err := ParseRateLimit(s)
+		if err != nil {
+			return limits, fmt.Errorf("parsing annotation %s failed: %v", AnnotationEgress, err)
+		}
+		limits.Egress = l
+	}
+
+	if s, ok := annotations[AnnotationIngress]; ok {
+		l, err := ParseRateLimit(s)
+		if err != nil {
+			return limits, fmt.Errorf("parsing annotation %s failed: %v", AnnotationIngress, err)
+		}
+		limits.Ingress = l
+	}
+
+	return limits, nil
+}
+
+// netem returns the network impairment profile for a container from the
+// defaults and the container annotations.
+func (c *Client) netem(annotations map[string]string) (*Netem, error) {
+	s, ok := annotations[AnnotationNetem]
+	if !ok {
+		return c.opt.Netem, nil
+	}
+
+	n, err := ParseNetem(s)
+	if err != nil {
+		return nil, fmt.Errorf("parsing annotation %s failed: %v", AnnotationNetem, err)
+	}
+
+	return n, nil
+}
+
+// saveAllocation saves the allocation record for an ip in the database.
+func (c *Client) saveAllocation(ip net.IP, a Allocation) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshaling allocation for %s failed: %v", ip.String(), err)
 	}
 
-	// Switch back to the original namespace.
-	if err := netns.Set(origns); err != nil {
-		return fmt.Errorf("switching back to original namespace failed: %v", err)
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(allocationBucket)
+		if err != nil {
+			return fmt.Errorf("creating bucket %s failed: %v", allocationBucket, err)
+		}
+		return bucket.Put(ip, b)
+	}); err != nil {
+		return fmt.Errorf("saving allocation for %s failed: %v", ip.String(), err)
 	}
 
 	return nil
 }
 
+// namespaceError returns a NamespaceGoneError for the error of an operation
+// on the network namespace of pid if the process is gone.
+func (c *Client) namespaceError(pid int, err error) error {
+	if !c.kernel.ProcessExists(pid) {
+		return &NamespaceGoneError{PID: pid, Err: err}
+	}
+	return err
+}
+
+// releaseIP returns an ip that was allocated but not saved with an
+// allocation record to the allocator.
+func (c *Client) releaseIP(ip net.IP) error {
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ipBucket).Delete(ip)
+	}); err != nil {
+		return fmt.Errorf("releasing ip %s failed: %v", ip.String(), err)
+	}
+	return nil
+}
+
 // vethPair creates a veth pair. Peername is renamed to eth0 in the container.
 func (c *Client) vethPair(pid int, bridgeName string) (*netlink.Veth, error) {
-	br, err := netlink.LinkByName(bridgeName)
+	br, err := c.kernel.LinkByName(bridgeName)
 	if err != nil {
 		return nil, fmt.Errorf("getting link %s failed: %v", bridgeName, err)
 	}
 
 	la := netlink.NewLinkAttrs()
-	la.Name = fmt.Sprintf("%s-%d", c.opt.PortPrefix, pid)
+	la.Name = c.vethName(pid)
 	la.MasterIndex = br.Attrs().Index
 
 	return &netlink.Veth{
 		LinkAttrs: la,
-		PeerName:  fmt.Sprintf("ethc%d", pid),
+		PeerName:  pidLinkName("ethc", pid),
 	}, nil
 }
+
+// containerInterface returns the name of the interface of the allocation in
+// the container.
+func (c *Client) containerInterface(a Allocation) string {
+	if len(a.Interface) > 0 {
+		return a.Interface
+	}
+	return c.opt.ContainerInterface
+}
+
+// vethName returns the name of the local side of the veth pair for pid.
+func (c *Client) vethName(pid int) string {
+	return pidLinkName(c.opt.PortPrefix, pid)
+}
+
+// ifbName returns the name of the ifb device limiting the traffic sent by
+// the container with pid.
+func (c *Client) ifbName(pid int) string {
+	return pidLinkName(c.opt.PortPrefix+"i", pid)
+}
@@ -1,60 +1,87 @@
 package network
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
-	"os/exec"
-	"syscall"
+	"reflect"
 	"testing"
 
 	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/kernel"
 	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/vishvananda/netlink"
+	bolt "go.etcd.io/bbolt"
 )
 
-func TestCreateNetwork(t *testing.T) {
-	process, err := createTestProcess()
+// newTestClient returns a client using a fake kernel and a temporary state
+// directory.
+func newTestClient(t *testing.T) (*Client, *kernel.Fake) {
+	dir, err := ioutil.TempDir("", "netns")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer process.Kill()
+	t.Cleanup(func() { os.RemoveAll(dir) })
 
+	k := kernel.NewFake()
 	c, err := New(Opt{
 		BridgeName: defaultBridgeName,
-		StateDir:   defaultStateDir,
+		StateDir:   dir,
+		Kernel:     k,
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(defaultStateDir)
 
-	ip, err := c.Create(configs.HookState{
-		Pid: process.Pid,
+	return c, k
+}
+
+func testCreate(c *Client, k *kernel.Fake, pid int) (net.IP, error) {
+	k.AddProcess(pid)
+	return c.Create(context.Background(), configs.HookState{
+		Pid: pid,
 	}, bridge.Opt{
 		IPAddr: defaultBridgeIP,
 		Name:   defaultBridgeName,
 	}, "")
+}
+
+func TestCreateNetwork(t *testing.T) {
+	c, k := newTestClient(t)
+
+	ip, err := testCreate(c, k, 1234)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer bridge.Delete(defaultBridgeName)
 
 	expected := "172.19.0.2"
 	if ip.String() != expected {
 		t.Fatalf("expected IP to be %s got %s", expected, ip.String())
 	}
 
-	if err := c.openDB(false); err != nil {
-		t.Fatal(err)
+	// The local side of the veth pair is on the host and the peer is renamed
+	// in the container.
+	if links := k.Links(0); !reflect.DeepEqual(links, []string{defaultBridgeName, "netnsv0-1234"}) {
+		t.Fatalf("expected the bridge and the veth on the host, got %v", links)
+	}
+	if links := k.Links(1234); !reflect.DeepEqual(links, []string{DefaultContainerInterface}) {
+		t.Fatalf("expected %s in the container, got %v", DefaultContainerInterface, links)
+	}
+	routes := k.Routes(1234)
+	if len(routes) != 1 || routes[0].Gw.String() != "172.19.0.1" {
+		t.Fatalf("expected a default route through the bridge, got %v", routes)
 	}
 
-	process2, err := createTestProcess()
-	if err != nil {
+	if err := c.openDB(context.Background(), false); err != nil {
 		t.Fatal(err)
 	}
-	defer process2.Kill()
+	defer c.closeDB()
 
 	// Allocate another IP.
-	ip, err = c.AllocateIP(process2.Pid)
+	ip, err = c.AllocateIP(context.Background(), 1235)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -64,13 +91,311 @@ func TestCreateNetwork(t *testing.T) {
 	}
 }
 
-func createTestProcess() (*os.Process, error) {
-	// Create a process in a new network namespace.
-	cmd := exec.Command("sleep", "30")
-	cmd.SysProcAttr = &syscall.SysProcAttr{Unshareflags: syscall.CLONE_NEWNET}
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("unsharing command failed: %v", err)
+func TestAllocateIPSkipsUsed(t *testing.T) {
+	c, k := newTestClient(t)
+
+	br, err := bridge.Init(bridge.Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+		Kernel: k,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A neighbor of the bridge is skipped without pinging it and an ip
+	// answering the ping is skipped as well.
+	k.AddNeighbor(netlink.Neigh{LinkIndex: br.Index, IP: net.ParseIP("172.19.0.2"), State: netlink.NUD_REACHABLE})
+	k.AddNeighbor(netlink.Neigh{LinkIndex: br.Index, IP: net.ParseIP("172.19.0.4"), State: netlink.NUD_FAILED})
+	k.Answer(net.ParseIP("172.19.0.3"))
+
+	ip, err := testCreate(c, k, 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "172.19.0.4"
+	if ip.String() != expected {
+		t.Fatalf("expected IP to be %s got %s", expected, ip.String())
+	}
+	if !reflect.DeepEqual(k.Pinged, []string{"172.19.0.3", "172.19.0.4"}) {
+		t.Fatalf("expected 172.19.0.3 and 172.19.0.4 to be pinged, got %v", k.Pinged)
+	}
+}
+
+func TestGetIPMap(t *testing.T) {
+	c, k := newTestClient(t)
+
+	br, err := bridge.Init(bridge.Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+		Kernel: k,
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
+	c.bridge = br
+	_, c.ipNet, _ = net.ParseCIDR(defaultBridgeIP)
+
+	k.AddNeighbor(netlink.Neigh{LinkIndex: br.Index, IP: net.ParseIP("172.19.0.2"), State: netlink.NUD_REACHABLE})
+	k.AddNeighbor(netlink.Neigh{LinkIndex: br.Index, IP: net.ParseIP("172.19.0.3"), State: netlink.NUD_STALE})
+	k.AddNeighbor(netlink.Neigh{LinkIndex: br.Index, IP: net.ParseIP("172.19.0.4"), State: netlink.NUD_FAILED})
+	k.AddNeighbor(netlink.Neigh{LinkIndex: br.Index, IP: net.ParseIP("172.19.0.5"), State: netlink.NUD_INCOMPLETE})
+	k.AddNeighbor(netlink.Neigh{LinkIndex: br.Index + 1, IP: net.ParseIP("172.19.0.6"), State: netlink.NUD_REACHABLE})
 
-	return cmd.Process, nil
+	ipMap, err := c.getIPMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]struct{}{
+		"172.19.0.2": {},
+		"172.19.0.3": {},
+	}
+	if !reflect.DeepEqual(ipMap, expected) {
+		t.Fatalf("expected the neighbors that answered keyed by ip %v, got %v", expected, ipMap)
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	c, k := newTestClient(t)
+	k.Fail("RouteAdd", errors.New("network is unreachable"))
+
+	if _, err := testCreate(c, k, 1234); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// The veth pair is deleted and the ip is released.
+	if links := k.Links(0); !reflect.DeepEqual(links, []string{defaultBridgeName}) {
+		t.Fatalf("expected only the bridge on the host, got %v", links)
+	}
+	if links := k.Links(1234); len(links) != 0 {
+		t.Fatalf("expected no links in the container, got %v", links)
+	}
+
+	if err := c.openDB(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(ipBucket).Get(net.ParseIP("172.19.0.2").To4()); v != nil {
+			t.Fatalf("expected 172.19.0.2 to be released, it is allocated to pid %s", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.closeDB()
+
+	// The container can be set up again once the route can be added.
+	k.Fail("RouteAdd", nil)
+	if _, err := c.Create(context.Background(), configs.HookState{
+		Pid: 1234,
+	}, bridge.Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+	}, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAndGC(t *testing.T) {
+	c, k := newTestClient(t)
+
+	for _, pid := range []int{1234, 1235} {
+		if _, err := testCreate(c, k, pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stopping the container destroys its namespace and the veth pair.
+	k.RemoveProcess(1234)
+
+	problems, err := c.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].PID != 1234 || problems[0].IP.String() != "172.19.0.2" {
+		t.Fatalf("expected the allocation of pid 1234 to be reported, got %+v", problems)
+	}
+
+	reclaimed, err := c.GC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reclaimed) != 1 || reclaimed[0].PID != 1234 {
+		t.Fatalf("expected the allocation of pid 1234 to be reclaimed, got %+v", reclaimed)
+	}
+
+	problems, err = c.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems after gc, got %+v", problems)
+	}
+
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].PID != 1235 {
+		t.Fatalf("expected only the allocation of pid 1235, got %+v", allocations)
+	}
+}
+
+func TestGCReusedPID(t *testing.T) {
+	c, k := newTestClient(t)
+	c.opt.Limits = Limits{Egress: &RateLimit{Rate: 12500000, Burst: 1024 * 1024}}
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+
+	// The container exits and another one gets its pid. It replaces the
+	// ifb device and adds a veth pair with the same names while the
+	// allocation is still saved, as it does when it is created during gc.
+	k.RemoveProcess(1234)
+	k.AddProcess(1234)
+	if err := deleteIfb(k, "netnsv0i-1234"); err != nil {
+		t.Fatal(err)
+	}
+	veth, err := c.vethPair(1234, defaultBridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifb := &netlink.Ifb{LinkAttrs: netlink.LinkAttrs{Name: "netnsv0i-1234"}}
+	for _, link := range []netlink.Link{veth, ifb} {
+		if err := k.LinkAdd(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reclaimed, err := c.GC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reclaimed) != 1 || reclaimed[0].PID != 1234 {
+		t.Fatalf("expected the allocation of pid 1234 to be reclaimed, got %+v", reclaimed)
+	}
+
+	// The links of the running container are left alone.
+	for _, link := range []netlink.Link{veth, ifb} {
+		l, err := k.LinkByName(link.Attrs().Name)
+		if err != nil {
+			t.Fatalf("expected %s to be kept: %v", link.Attrs().Name, err)
+		}
+		if l.Attrs().Index != link.Attrs().Index {
+			t.Fatalf("expected %s to keep index %d got %d", link.Attrs().Name, link.Attrs().Index, l.Attrs().Index)
+		}
+	}
+}
+
+func TestCreateReusedPID(t *testing.T) {
+	c, k := newTestClient(t)
+	c.opt.Limits = Limits{Egress: &RateLimit{Rate: 12500000, Burst: 1024 * 1024}}
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+
+	// The network of the container that had the pid is released when a
+	// new container gets it.
+	k.RemoveProcess(1234)
+	ip, err := testCreate(c, k, 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || !allocations[0].IP.Equal(ip) {
+		t.Fatalf("expected only the allocation of ip %s, got %+v", ip, allocations)
+	}
+	ifb, err := k.LinkByName("netnsv0i-1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ifb.Attrs().Index != allocations[0].IfbIndex {
+		t.Fatalf("expected the ifb device of the new container, index %d got %d", allocations[0].IfbIndex, ifb.Attrs().Index)
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	c, k := newTestClient(t)
+
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+
+	// The static ip of another container.
+	k.AddProcess(1235)
+	_, err := c.Create(context.Background(), configs.HookState{
+		Pid: 1235,
+	}, bridge.Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+	}, "172.19.0.2")
+	var conflict *IPConflictError
+	if !errors.As(err, &conflict) || conflict.PID != 1234 {
+		t.Fatalf("expected an ip conflict with pid 1234, got %v", err)
+	}
+
+	// The veth of the running container already exists.
+	_, err = c.Create(context.Background(), configs.HookState{
+		Pid: 1234,
+	}, bridge.Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+	}, "")
+	if !errors.Is(err, ErrLinkExists) {
+		t.Fatalf("expected %v got %v", ErrLinkExists, err)
+	}
+
+	// The process is gone before its namespace is set up.
+	_, err = c.Create(context.Background(), configs.HookState{
+		Pid: 1236,
+	}, bridge.Opt{
+		IPAddr: defaultBridgeIP,
+		Name:   defaultBridgeName,
+	}, "")
+	if !errors.Is(err, ErrNamespaceGone) {
+		t.Fatalf("expected %v got %v", ErrNamespaceGone, err)
+	}
+
+	// The errors do not leave allocations behind.
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].PID != 1234 {
+		t.Fatalf("expected only the allocation of pid 1234, got %+v", allocations)
+	}
+
+	if err := c.Delete(context.Background(), "1236"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v got %v", ErrNotFound, err)
+	}
+}
+
+func TestAllocateIPPoolExhausted(t *testing.T) {
+	c, k := newTestClient(t)
+
+	// A /30 has a single address left next to the bridge.
+	opt := bridge.Opt{IPAddr: "172.20.0.1/30", Name: defaultBridgeName}
+	for i, pid := range []int{1234, 1235} {
+		k.AddProcess(pid)
+		_, err := c.Create(context.Background(), configs.HookState{Pid: pid}, opt, "")
+		if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && !errors.Is(err, ErrPoolExhausted) {
+			t.Fatalf("expected %v got %v", ErrPoolExhausted, err)
+		}
+	}
+
+	if code := ErrorCode(fmt.Errorf("creating failed: %w", &PoolExhaustedError{})); code != "pool_exhausted" {
+		t.Fatalf("expected code pool_exhausted got %q", code)
+	}
+	if err := CodeError("pool_exhausted"); err != ErrPoolExhausted {
+		t.Fatalf("expected %v got %v", ErrPoolExhausted, err)
+	}
 }
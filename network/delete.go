@@ -0,0 +1,154 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Delete releases the network of the container identified by its container
+// ID, PID or IP address. The local side of the veth pair is deleted, which
+// deletes the peer in the container as well, with the ifb device limiting
+// the traffic of the container, and the ip is returned to the allocator.
+func (c *Client) Delete(ctx context.Context, target string) error {
+	if err := c.release(ctx, target, "delete"); err != nil {
+		return err
+	}
+	releasesTotal.Inc(c.opt.BridgeName)
+	return nil
+}
+
+// release deletes the network of the container as part of the operation.
+func (c *Client) release(ctx context.Context, target, operation string) (err error) {
+	// Log the outcome and the duration of each step when we are done.
+	st := newSteps(c.opt.BridgeName, logrus.WithFields(logrus.Fields{
+		"target": target,
+		"bridge": c.opt.BridgeName,
+	}))
+	defer func() {
+		st.summary(operation, err)
+	}()
+
+	c.log = st.begin("setup")
+
+	// Open the database.
+	if err := c.openDB(ctx, false); err != nil {
+		return err
+	}
+	defer c.closeDB()
+
+	ip, a, err := c.findAllocation(target)
+	if err != nil {
+		return err
+	}
+
+	// Delete the links of the container, the veth pair is already gone if
+	// the container was destroyed.
+	c.log = st.begin("veth_del")
+	if err := c.deleteLinks(a); err != nil {
+		return err
+	}
+
+	// Release the ip and remove the allocation record.
+	c.log = st.begin("release")
+	if err := c.removeAllocation(ip, a); err != nil {
+		return err
+	}
+
+	c.log.Debugf("released ip %s of pid %d", ip.String(), a.PID)
+	return nil
+}
+
+// deleteLinks deletes the local side of the veth pair and the ifb device of
+// the allocation if they exist. They are named after the pid, so the links
+// of a container that got the pid since are left alone: only the links with
+// the indexes saved with the allocation are deleted. The pid is trusted for
+// the allocations saved by older versions unless it was reused.
+func (c *Client) deleteLinks(a Allocation) error {
+	var status string
+	owned := func(link netlink.Link, index int) bool {
+		if a.VethIndex > 0 {
+			return index > 0 && link.Attrs().Index == index
+		}
+		if len(status) < 1 {
+			status = c.status(a)
+		}
+		return status != StatusPIDReused
+	}
+
+	name := c.vethName(a.PID)
+	if link, err := c.kernel.LinkByName(name); err == nil && owned(link, a.VethIndex) {
+		if err := c.kernel.LinkDel(link); err != nil {
+			return fmt.Errorf("deleting link %s failed: %v", name, err)
+		}
+	}
+
+	name = c.ifbName(a.PID)
+	if link, err := c.kernel.LinkByName(name); err == nil && owned(link, a.IfbIndex) {
+		return deleteIfb(c.kernel, name)
+	}
+	return nil
+}
+
+// removeAllocation returns the ip to the allocator, removes the allocation
+// record and the name resolution files kept in the state directory. The
+// database must be opened.
+func (c *Client) removeAllocation(ip net.IP, a Allocation) error {
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(ipBucket); b != nil {
+			if err := b.Delete(ip); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(allocationBucket); b != nil {
+			if err := b.Delete(ip); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("releasing ip %s failed: %v", ip.String(), err)
+	}
+
+	if dir := c.dnsDir(a); len(dir) > 0 {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("removing name resolution files in %s failed: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// releaseStale releases the networks of the containers that had the pid and
+// are gone, before a new container with the pid reuses the names of their
+// links. The database must be opened.
+func (c *Client) releaseStale(pid int) error {
+	allocations, err := c.allocations()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range allocations {
+		if a.PID != pid || !isGone(c.status(a)) {
+			continue
+		}
+
+		// Find the ip as it is saved in the database.
+		ip, a, err := c.findAllocation(a.IP.String())
+		if err != nil {
+			return err
+		}
+		if err := c.deleteLinks(a); err != nil {
+			return err
+		}
+		if err := c.removeAllocation(ip, a); err != nil {
+			return err
+		}
+		c.log.Infof("released ip %s left by the previous process with pid %d", ip.String(), pid)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/genuinetools/netns/resolvconf"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// ResolvNone does not write any name resolution files for the
+	// containers.
+	ResolvNone = "none"
+	// ResolvRootfs writes the name resolution files into the root
+	// filesystem of the container bundle.
+	ResolvRootfs = "rootfs"
+	// ResolvState writes the name resolution files into the state
+	// directory, so the runtime can bind mount them into the container.
+	ResolvState = "state"
+)
+
+// DNSOpt holds the name resolution options for the containers. When no
+// nameservers are given the ones from the host are used.
+type DNSOpt struct {
+	Mode        string
+	Nameservers []string
+	Search      []string
+	Options     []string
+}
+
+// containerIDRegexp matches the container IDs runc accepts.
+var containerIDRegexp = regexp.MustCompile(`^[\w+.-]+$`)
+
+// validContainerID returns true if the container ID can name a directory
+// in the state directory without pointing outside of it.
+func validContainerID(id string) bool {
+	return id != "." && id != ".." && containerIDRegexp.MatchString(id)
+}
+
+// DNSDir returns the directory in the state directory holding the name
+// resolution files for a container when using ResolvState.
+func (c *Client) DNSDir(containerID string) string {
+	return filepath.Join(c.opt.StateDir, "containers", containerID)
+}
+
+// dnsDir returns the directory in the state directory holding the name
+// resolution files of the allocation, or an empty string if it has none.
+func (c *Client) dnsDir(a Allocation) string {
+	if len(a.DNSDir) > 0 {
+		return a.DNSDir
+	}
+	// The allocations saved by older versions only have the container ID.
+	if validContainerID(a.ContainerID) {
+		return c.DNSDir(a.ContainerID)
+	}
+	return ""
+}
+
+// writeDNSFiles writes the resolv.conf and hosts files for the container.
+// It returns the directory it created in the state directory for them, if
+// any, even when writing them fails.
+func (c *Client) writeDNSFiles(hook configs.HookState, ip net.IP) (string, error) {
+	var (
+		dir  *os.File
+		path string
+	)
+	switch c.opt.DNS.Mode {
+	case "", ResolvNone:
+		return "", nil
+	case ResolvRootfs:
+		rootfs, err := bundleRootfs(hook.Bundle)
+		if err != nil {
+			return "", err
+		}
+		// The root filesystem comes from the image, /etc may be a
+		// symlink to anywhere on the host.
+		dir, err = openRootDir(rootfs, "etc")
+		if err != nil {
+			return "", err
+		}
+	case ResolvState:
+		id := hook.ID
+		if len(id) < 1 {
+			id = strconv.Itoa(hook.Pid)
+		}
+		if !validContainerID(id) {
+			return "", fmt.Errorf("invalid container id %q", id)
+		}
+		path = c.DNSDir(id)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return "", fmt.Errorf("creating directory %s failed: %v", path, err)
+		}
+		var err error
+		dir, err = os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("opening directory %s failed: %v", path, err)
+		}
+	default:
+		return "", fmt.Errorf("unknown resolv mode %q", c.opt.DNS.Mode)
+	}
+	defer dir.Close()
+
+	// Write the resolv.conf file.
+	conf, err := c.resolvConf()
+	if err != nil {
+		return path, err
+	}
+	if err := writeFileAt(dir, "resolv.conf", conf.Bytes()); err != nil {
+		return path, err
+	}
+
+	// Add the container to the hosts file, keeping the existing entries
+	// from the root filesystem.
+	hosts, err := readFileAt(dir, "hosts")
+	if err != nil || c.opt.DNS.Mode == ResolvState {
+		hosts = resolvconf.DefaultHosts
+	}
+	names := Allocation{
+		ContainerID: hook.ID,
+		Hostname:    containerHostname(hook.Bundle),
+	}.Names()
+	if len(names) > 0 {
+		hosts = resolvconf.UpdateHosts(hosts, ip, names...)
+	}
+	return path, writeFileAt(dir, "hosts", hosts)
+}
+
+// resolvConf returns the resolv.conf configuration for the containers from
+// the options and the host.
+func (c *Client) resolvConf() (resolvconf.Config, error) {
+	conf := resolvconf.Config{
+		Nameservers: c.opt.DNS.Nameservers,
+		Search:      c.opt.DNS.Search,
+		Options:     c.opt.DNS.Options,
+	}
+	if len(conf.Nameservers) > 0 {
+		return conf, nil
+	}
+
+	host, err := resolvconf.Host()
+	if err != nil {
+		return conf, err
+	}
+	conf.Nameservers = host.Nameservers
+	if len(conf.Search) < 1 {
+		conf.Search = host.Search
+	}
+	if len(conf.Options) < 1 {
+		conf.Options = host.Options
+	}
+
+	return conf, nil
+}
+
+// readBundleSpec reads the runtime spec from the config.json in a bundle.
+func readBundleSpec(bundle string) (*specs.Spec, error) {
+	if len(bundle) < 1 {
+		return nil, fmt.Errorf("hook state has no bundle path")
+	}
+
+	p := filepath.Join(bundle, "config.json")
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s failed: %v", p, err)
+	}
+
+	var spec specs.Spec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, fmt.Errorf("unmarshaling %s failed: %v", p, err)
+	}
+
+	return &spec, nil
+}
+
+// bundleRootfs returns the path to the root filesystem of a bundle.
+func bundleRootfs(bundle string) (string, error) {
+	spec, err := readBundleSpec(bundle)
+	if err != nil {
+		return "", err
+	}
+	if spec.Root == nil || len(spec.Root.Path) < 1 {
+		return "", fmt.Errorf("bundle %s has no root path", bundle)
+	}
+
+	if filepath.IsAbs(spec.Root.Path) {
+		return spec.Root.Path, nil
+	}
+	return filepath.Join(bundle, spec.Root.Path), nil
+}
+
+// containerHostname returns the hostname of the container from its bundle,
+// or an empty string if it has none.
+func containerHostname(bundle string) string {
+	spec, err := readBundleSpec(bundle)
+	if err != nil {
+		return ""
+	}
+	return spec.Hostname
+}
+
+// Names returns the names of the container for the allocation, which are its
+// hostname and its ID.
+func (a Allocation) Names() []string {
+	var names []string
+	if len(a.Hostname) > 0 {
+		names = append(names, a.Hostname)
+	}
+	if len(a.ContainerID) > 0 && a.ContainerID != a.Hostname {
+		names = append(names, a.ContainerID)
+	}
+	return names
+}
+
+// openRootDir opens the directory at path in rootfs, creating the missing
+// directories. Every component of path is opened without following
+// symlinks, so the directory is never outside of rootfs.
+func openRootDir(rootfs, path string) (*os.File, error) {
+	fd, err := syscall.Open(rootfs, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening root filesystem %s failed: %v", rootfs, err)
+	}
+
+	for _, name := range strings.Split(filepath.Clean("/"+path), "/") {
+		if len(name) < 1 {
+			continue
+		}
+
+		next, err := openDirAt(fd, name)
+		if err == syscall.ENOENT {
+			if err := syscall.Mkdirat(fd, name, 0755); err != nil && err != syscall.EEXIST {
+				syscall.Close(fd)
+				return nil, fmt.Errorf("creating directory %s in %s failed: %v", path, rootfs, err)
+			}
+			next, err = openDirAt(fd, name)
+		}
+		syscall.Close(fd)
+		if err == syscall.ELOOP || err == syscall.ENOTDIR {
+			return nil, fmt.Errorf("opening directory %s in %s failed: %s is not a directory, it may point outside of the root filesystem", path, rootfs, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("opening directory %s in %s failed: %v", path, rootfs, err)
+		}
+		fd = next
+	}
+
+	return os.NewFile(uintptr(fd), filepath.Join(rootfs, path)), nil
+}
+
+// openDirAt opens the directory name in the directory dirfd, failing if name
+// is a symlink.
+func openDirAt(dirfd int, name string) (int, error) {
+	return syscall.Openat(dirfd, name, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+}
+
+// readFileAt reads the regular file name in dir, failing if it is a symlink.
+func readFileAt(dir *os.File, name string) ([]byte, error) {
+	fd, err := syscall.Openat(int(dir.Fd()), name, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s failed: %v", filepath.Join(dir.Name(), name), err)
+	}
+	f := os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name))
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", f.Name())
+	}
+	return ioutil.ReadAll(f)
+}
+
+// writeFileAt replaces the file name in dir with a file holding b. The file
+// is written next to it and renamed over it, so a symlink or a hard link at
+// name is replaced rather than written through.
+func writeFileAt(dir *os.File, name string, b []byte) error {
+	dirfd := int(dir.Fd())
+	tmp := "." + name + ".netns"
+	path := filepath.Join(dir.Name(), name)
+
+	// Remove the file left behind by a failed write.
+	syscall.Unlinkat(dirfd, tmp)
+
+	fd, err := syscall.Openat(dirfd, tmp, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0644)
+	if err != nil {
+		return fmt.Errorf("creating temporary file for %s failed: %v", path, err)
+	}
+	f := os.NewFile(uintptr(fd), filepath.Join(dir.Name(), tmp))
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		syscall.Unlinkat(dirfd, tmp)
+		return fmt.Errorf("writing %s failed: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		syscall.Unlinkat(dirfd, tmp)
+		return fmt.Errorf("writing %s failed: %v", path, err)
+	}
+
+	if err := syscall.Renameat(dirfd, tmp, dirfd, name); err != nil {
+		syscall.Unlinkat(dirfd, tmp)
+		return fmt.Errorf("replacing %s failed: %v", path, err)
+	}
+	return nil
+}
+
+// writeFile replaces the file at path, a file of the state directory, with
+// a file holding b.
+func writeFile(path string, b []byte) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("opening directory of %s failed: %v", path, err)
+	}
+	defer dir.Close()
+
+	return writeFileAt(dir, filepath.Base(path), b)
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// newTestBundle returns a bundle with an empty root filesystem.
+func newTestBundle(t *testing.T) (string, string) {
+	bundle, err := ioutil.TempDir("", "netns-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(bundle) })
+
+	config := `{"root": {"path": "rootfs"}, "hostname": "web"}`
+	if err := ioutil.WriteFile(filepath.Join(bundle, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rootfs := filepath.Join(bundle, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return bundle, rootfs
+}
+
+func TestWriteDNSFilesRootfs(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.opt.DNS = DNSOpt{Mode: ResolvRootfs, Nameservers: []string{"192.0.2.53"}}
+	bundle, rootfs := newTestBundle(t)
+
+	// The hosts file of the image is a symlink, it is replaced.
+	outside, err := ioutil.TempDir("", "netns-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := ioutil.WriteFile(filepath.Join(outside, "hosts"), []byte("host\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "hosts"), filepath.Join(rootfs, "etc", "hosts")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.writeDNSFiles(configs.HookState{ID: "abc", Bundle: bundle}, net.ParseIP("172.19.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolv, err := ioutil.ReadFile(filepath.Join(rootfs, "etc", "resolv.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resolv), "nameserver 192.0.2.53") {
+		t.Fatalf("expected the nameserver in resolv.conf got %q", resolv)
+	}
+	fi, err := os.Lstat(filepath.Join(rootfs, "etc", "hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Mode().IsRegular() {
+		t.Fatal("expected the hosts symlink to be replaced by a file")
+	}
+	hosts, err := ioutil.ReadFile(filepath.Join(rootfs, "etc", "hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(hosts), "172.19.0.2\tweb abc") {
+		t.Fatalf("expected the container in the hosts file got %q", hosts)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(outside, "hosts")); string(b) != "host\n" {
+		t.Fatalf("expected the file outside of the root filesystem to be left alone, got %q", b)
+	}
+}
+
+func TestWriteDNSFilesRootfsSymlink(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.opt.DNS = DNSOpt{Mode: ResolvRootfs, Nameservers: []string{"192.0.2.53"}}
+	bundle, rootfs := newTestBundle(t)
+
+	// The etc directory of the image points outside of the root
+	// filesystem, as etc -> /etc would.
+	outside, err := ioutil.TempDir("", "netns-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := os.Symlink(outside, filepath.Join(rootfs, "etc")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.writeDNSFiles(configs.HookState{ID: "abc", Bundle: bundle}, net.ParseIP("172.19.0.2")); err == nil {
+		t.Fatal("expected an error for a symlinked etc")
+	}
+	files, err := ioutil.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected nothing written outside of the root filesystem, got %d files", len(files))
+	}
+}
+
+func TestWriteDNSFilesStateInvalidID(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.opt.DNS = DNSOpt{Mode: ResolvState, Nameservers: []string{"192.0.2.53"}}
+
+	for _, id := range []string{"../../etc", "..", "a/b"} {
+		if _, err := c.writeDNSFiles(configs.HookState{ID: id, Pid: 1234}, net.ParseIP("172.19.0.2")); err == nil {
+			t.Fatalf("expected an error for container id %q", id)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(c.opt.StateDir, "etc")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written outside of the containers directory, got %v", err)
+	}
+}
+
+func TestCreateDNSStateWithoutID(t *testing.T) {
+	c, k := newTestClient(t)
+	c.opt.DNS = DNSOpt{Mode: ResolvState, Nameservers: []string{"192.0.2.53"}}
+
+	// Without a container id the files are kept in a directory named after
+	// the pid, which is removed with the network.
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(c.opt.StateDir, "containers", "1234")
+	i, err := c.Inspect(context.Background(), "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Allocation.DNSDir != dir {
+		t.Fatalf("expected the directory %s in the allocation got %q", dir, i.Allocation.DNSDir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "resolv.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Delete(context.Background(), "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got %v", dir, err)
+	}
+}
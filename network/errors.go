@@ -0,0 +1,170 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/genuinetools/netns/bridge"
+)
+
+var (
+	// ErrNotFound holds the error for when no network matches a container.
+	ErrNotFound = errors.New("no network found")
+	// ErrPoolExhausted holds the error for when there is no free ip address
+	// left in the network.
+	ErrPoolExhausted = errors.New("no free ip address left")
+	// ErrIPConflict holds the error for when an ip address is already used
+	// by another container.
+	ErrIPConflict = errors.New("ip address is already in use")
+	// ErrNamespaceGone holds the error for when the network namespace of a
+	// container does not exist anymore.
+	ErrNamespaceGone = errors.New("network namespace is gone")
+	// ErrLinkExists holds the error for when a link with the same name
+	// already exists.
+	ErrLinkExists = errors.New("link already exists")
+	// ErrUnknownSchema holds the error for when the database was written by
+	// a newer version with a schema this one does not know.
+	ErrUnknownSchema = errors.New("unknown database schema")
+)
+
+// PoolExhaustedError is the error for a network without free ip addresses.
+type PoolExhaustedError struct {
+	Network *net.IPNet
+}
+
+func (e *PoolExhaustedError) Error() string {
+	return fmt.Sprintf("could not find a suitable IP in network %s", e.Network.String())
+}
+
+// Is makes the error match ErrPoolExhausted.
+func (e *PoolExhaustedError) Is(target error) bool {
+	return target == ErrPoolExhausted
+}
+
+// IPConflictError is the error for an ip address that is allocated to
+// another process.
+type IPConflictError struct {
+	IP  net.IP
+	PID int
+}
+
+func (e *IPConflictError) Error() string {
+	return fmt.Sprintf("ip %s is already allocated to pid %d", e.IP.String(), e.PID)
+}
+
+// Is makes the error match ErrIPConflict.
+func (e *IPConflictError) Is(target error) bool {
+	return target == ErrIPConflict
+}
+
+// NamespaceGoneError is the error for a process whose network namespace
+// cannot be entered because the process is gone.
+type NamespaceGoneError struct {
+	PID int
+	Err error
+}
+
+func (e *NamespaceGoneError) Error() string {
+	return fmt.Sprintf("network namespace of pid %d is gone: %v", e.PID, e.Err)
+}
+
+// Is makes the error match ErrNamespaceGone.
+func (e *NamespaceGoneError) Is(target error) bool {
+	return target == ErrNamespaceGone
+}
+
+func (e *NamespaceGoneError) Unwrap() error {
+	return e.Err
+}
+
+// LinkExistsError is the error for a link that cannot be added because its
+// name is taken.
+type LinkExistsError struct {
+	Name string
+}
+
+func (e *LinkExistsError) Error() string {
+	return fmt.Sprintf("link %s already exists", e.Name)
+}
+
+// Is makes the error match ErrLinkExists.
+func (e *LinkExistsError) Is(target error) bool {
+	return target == ErrLinkExists
+}
+
+// DatabaseLockedError is the error for a database that could not be opened
+// before the context was done because another process holds its lock.
+type DatabaseLockedError struct {
+	Path string
+	Err  error
+}
+
+func (e *DatabaseLockedError) Error() string {
+	return fmt.Sprintf("opening database at %s failed: gave up waiting for the lock held by another process: %v", e.Path, e.Err)
+}
+
+// Is makes the error match ErrDatabaseLocked.
+func (e *DatabaseLockedError) Is(target error) bool {
+	return target == ErrDatabaseLocked
+}
+
+func (e *DatabaseLockedError) Unwrap() error {
+	return e.Err
+}
+
+// SchemaVersionError is the error for a database with a schema version
+// newer than the supported one.
+type SchemaVersionError struct {
+	Path      string
+	Version   int
+	Supported int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("database at %s has schema version %d but only up to %d is supported, upgrade netns", e.Path, e.Version, e.Supported)
+}
+
+// Is makes the error match ErrUnknownSchema.
+func (e *SchemaVersionError) Is(target error) bool {
+	return target == ErrUnknownSchema
+}
+
+// codes are the names of the kinds of errors, used to pass them over the
+// wire.
+var codes = []struct {
+	code string
+	err  error
+}{
+	{"not_found", ErrNotFound},
+	{"pool_exhausted", ErrPoolExhausted},
+	{"ip_conflict", ErrIPConflict},
+	{"namespace_gone", ErrNamespaceGone},
+	{"link_exists", ErrLinkExists},
+	{"database_locked", ErrDatabaseLocked},
+	{"subnet_conflict", bridge.ErrSubnetConflict},
+	{"unknown_schema", ErrUnknownSchema},
+}
+
+// ErrorCode returns the name of the kind of the error, for example
+// "pool_exhausted", or an empty string if it is not one of the errors of the
+// package.
+func ErrorCode(err error) string {
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// CodeError returns the error for the name of a kind of error, or nil if it
+// is unknown.
+func CodeError(code string) error {
+	for _, c := range codes {
+		if c.code == code {
+			return c.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// Exec starts cmd in the network namespace of the container identified by
+// its container ID, PID or IP address. Only the network namespace is
+// changed, so cmd can be any binary from the host. The caller must wait for
+// cmd to finish.
+func (c *Client) Exec(ctx context.Context, target string, cmd *exec.Cmd) error {
+	// Open the database, it is closed before starting the command so it is
+	// not locked for as long as the command runs.
+	if err := c.openDB(ctx, true); err != nil {
+		return err
+	}
+	_, a, err := c.findAllocation(target)
+	c.closeDB()
+	if err != nil {
+		return err
+	}
+
+	// The pid may belong to another process now, never enter its namespace.
+	if status := c.status(a); status != StatusRunning {
+		return &NamespaceGoneError{PID: a.PID, Err: fmt.Errorf("the container is %s", status)}
+	}
+
+	// The child process is forked from the thread in the namespace so it
+	// inherits it.
+	var startErr error
+	if err := c.kernel.InNamespace(a.PID, func() error {
+		startErr = cmd.Start()
+		return nil
+	}); err != nil {
+		return c.namespaceError(a.PID, err)
+	}
+
+	if startErr != nil {
+		return fmt.Errorf("starting %s failed: %v", cmd.Path, startErr)
+	}
+
+	return nil
+}
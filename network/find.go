@@ -0,0 +1,125 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// getAllocation returns the allocation record for an ip from the ip and the
+// pid saved in the ip allocator bucket. Ips allocated by older versions do
+// not have a record, so only the pid is filled in for those.
+func getAllocation(tx *bolt.Tx, ip, pid []byte) (Allocation, error) {
+	var a Allocation
+
+	if b := tx.Bucket(allocationBucket); b != nil {
+		if v := b.Get(ip); v != nil {
+			if err := json.Unmarshal(v, &a); err != nil {
+				return a, fmt.Errorf("unmarshaling allocation for %s failed: %v", net.IP(ip).String(), err)
+			}
+		}
+	}
+
+	var err error
+	a.PID, err = strconv.Atoi(string(pid))
+	if err != nil {
+		return a, fmt.Errorf("parsing pid %s as int failed: %v", pid, err)
+	}
+
+	// Copy the key since it is only valid during the transaction.
+	a.IP = net.ParseIP(net.IP(ip).String())
+
+	return a, nil
+}
+
+// Allocations returns the allocation records from the database.
+func (c *Client) Allocations(ctx context.Context) ([]Allocation, error) {
+	// Return early if the database has not been created yet.
+	if _, err := os.Stat(c.dbPath); os.IsNotExist(err) {
+		return []Allocation{}, nil
+	}
+
+	// Open the database.
+	if err := c.openDB(ctx, true); err != nil {
+		return nil, err
+	}
+	defer c.closeDB()
+
+	return c.allocations()
+}
+
+// allocations returns the allocation records, the database must be opened.
+func (c *Client) allocations() ([]Allocation, error) {
+	allocations := []Allocation{}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ipBucket)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			// skip last ip
+			if len(k) == 1 && k[0] == 0 {
+				return nil
+			}
+
+			a, err := getAllocation(tx, k, v)
+			if err != nil {
+				return err
+			}
+			allocations = append(allocations, a)
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("getting allocations failed: %v", err)
+	}
+
+	return allocations, nil
+}
+
+// findAllocation returns the ip and the allocation record for the container
+// identified by target, which can be the container ID, its PID or its IP
+// address. The database must be opened.
+func (c *Client) findAllocation(target string) (ip net.IP, a Allocation, err error) {
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ipBucket)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			// skip last ip
+			if (len(k) == 1 && k[0] == 0) || ip != nil {
+				return nil
+			}
+
+			record, err := getAllocation(tx, k, v)
+			if err != nil {
+				return err
+			}
+
+			kip := net.IP(k)
+			if target == kip.String() || target == strconv.Itoa(record.PID) || (record.ContainerID != "" && target == record.ContainerID) {
+				// Copy the key as is since it is only valid during the
+				// transaction and the records are saved under it.
+				ip = append(net.IP(nil), k...)
+				a = record
+			}
+
+			return nil
+		})
+	}); err != nil {
+		return nil, a, fmt.Errorf("finding network for %s failed: %v", target, err)
+	}
+
+	if ip == nil {
+		return nil, a, fmt.Errorf("%w for %s", ErrNotFound, target)
+	}
+
+	return ip, a, nil
+}
@@ -0,0 +1,38 @@
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GC releases the networks of the containers whose process is gone, or
+// whose pid was reused, and returns their allocations. The networks whose
+// namespace is held by another process or a bind mount are kept. The
+// networks that could not be released are logged and left for the next run.
+func (c *Client) GC(ctx context.Context) ([]Allocation, error) {
+	gcRunsTotal.Inc(c.opt.BridgeName)
+
+	allocations, err := c.Allocations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting allocations failed: %v", err)
+	}
+
+	holders := &namespaceHolders{kernel: c.kernel}
+	reclaimed := []Allocation{}
+	for _, a := range allocations {
+		if !isGone(c.statusWith(a, holders)) {
+			continue
+		}
+
+		if err := c.release(ctx, a.IP.String(), "gc"); err != nil {
+			logrus.Warnf("[gc] releasing ip %s of pid %d failed: %v", a.IP.String(), a.PID, err)
+			continue
+		}
+		gcReclaimedTotal.Inc(c.opt.BridgeName)
+		reclaimed = append(reclaimed, a)
+	}
+
+	return reclaimed, nil
+}
@@ -0,0 +1,45 @@
+package network
+
+import (
+	"context"
+	"fmt"
+)
+
+// Impair sets the network impairment profile for the traffic going to a
+// running container identified by its container ID, PID or IP address. A nil
+// profile clears the impairment while keeping the bandwidth limits.
+func (c *Client) Impair(ctx context.Context, target string, profile *Netem) error {
+	// Open the database.
+	if err := c.openDB(ctx, false); err != nil {
+		return err
+	}
+	defer c.closeDB()
+
+	ip, a, err := c.findAllocation(target)
+	if err != nil {
+		return err
+	}
+
+	// The veth of a container that is not running is gone or about to be.
+	if status := c.status(a); status != StatusRunning {
+		return &NamespaceGoneError{PID: a.PID, Err: fmt.Errorf("the container is %s", status)}
+	}
+
+	// Get the local side of the veth pair.
+	localVethPair, err := c.vethPair(a.PID, c.opt.BridgeName)
+	if err != nil {
+		return fmt.Errorf("getting vethpair for pid %d failed: %v", a.PID, err)
+	}
+	link, err := c.kernel.LinkByName(localVethPair.Name)
+	if err != nil {
+		return fmt.Errorf("getting link %s failed: %v", localVethPair.Name, err)
+	}
+
+	if err := setQdiscs(c.kernel, link, a.Limits.Ingress, profile); err != nil {
+		return fmt.Errorf("setting ingress qdiscs for pid %d failed: %v", a.PID, err)
+	}
+
+	// Save the new profile with the allocation.
+	a.Netem = profile
+	return c.saveAllocation(ip, a)
+}
@@ -0,0 +1,393 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/genuinetools/netns/kernel"
+	"github.com/vishvananda/netlink"
+)
+
+// Inspection describes the network of a single container.
+type Inspection struct {
+	IP         net.IP      `json:"ip"`
+	Status     string      `json:"status"`
+	Allocation Allocation  `json:"allocation"`
+	Interface  *Interface  `json:"interface,omitempty"`
+	Routes     []Route     `json:"routes,omitempty"`
+	Neighbors  []Neighbor  `json:"neighbors,omitempty"`
+	Host       *BridgePort `json:"host,omitempty"`
+	NAT        NATInfo     `json:"nat"`
+	Stats      Stats       `json:"stats"`
+}
+
+// Interface describes a network interface.
+type Interface struct {
+	Name      string   `json:"name"`
+	Index     int      `json:"index"`
+	MAC       string   `json:"mac"`
+	MTU       int      `json:"mtu"`
+	State     string   `json:"state"`
+	Up        bool     `json:"up"`
+	Addresses []string `json:"addresses"`
+}
+
+// Route describes a route in the container namespace.
+type Route struct {
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	Scope       string `json:"scope"`
+}
+
+// Neighbor describes an entry of the neighbor table in the container
+// namespace.
+type Neighbor struct {
+	IP        string `json:"ip"`
+	MAC       string `json:"mac,omitempty"`
+	State     string `json:"state"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// BridgePort describes the local side of the veth pair and its state as a
+// port of the bridge.
+type BridgePort struct {
+	Interface
+	Bridge    string `json:"bridge"`
+	PortState string `json:"port_state"`
+	Hairpin   bool   `json:"hairpin"`
+}
+
+// NATInfo holds the iptables nat rules that apply to a container.
+type NATInfo struct {
+	Rules        []string      `json:"rules"`
+	PortMappings []PortMapping `json:"port_mappings"`
+}
+
+// PortMapping is a port of the host forwarded to the container.
+type PortMapping struct {
+	Proto         string `json:"proto"`
+	HostIP        string `json:"host_ip,omitempty"`
+	HostPort      string `json:"host_port"`
+	ContainerPort string `json:"container_port,omitempty"`
+}
+
+// Inspect returns the network of the container identified by its container
+// ID, PID or IP address.
+func (c *Client) Inspect(ctx context.Context, target string) (*Inspection, error) {
+	// Open the database.
+	if err := c.openDB(ctx, true); err != nil {
+		return nil, err
+	}
+	defer c.closeDB()
+
+	ip, a, err := c.findAllocation(target)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Inspection{
+		IP:         ip,
+		Status:     c.status(a),
+		Allocation: a,
+	}
+
+	// Get the container side, the namespace of the pid belongs to another
+	// process if it is not running.
+	if i.Status == StatusRunning {
+		if err := i.inspectNamespace(c.kernel, a.PID, c.containerInterface(a)); err != nil {
+			c.log.Debugf("inspecting namespace of pid %d failed: %v", a.PID, err)
+		}
+	}
+
+	// Get the host side.
+	localVethPair, err := c.vethPair(a.PID, c.opt.BridgeName)
+	if err != nil {
+		return nil, fmt.Errorf("getting vethpair for pid %d failed: %v", a.PID, err)
+	}
+	if link, err := c.kernel.LinkByName(localVethPair.Name); err == nil {
+		i.Host = bridgePort(c.kernel, link, c.opt.BridgeName)
+		i.Stats, _ = linkStats(c.kernel, localVethPair.Name)
+	}
+
+	// Get the nat rules, iptables is not required to inspect a network.
+	rules, err := c.kernel.NATRules()
+	if err != nil {
+		c.log.Debugf("getting nat rules failed: %v", err)
+	}
+	i.NAT = natInfo(rules, ip)
+
+	return i, nil
+}
+
+// inspectNamespace fills in the interface, routes and neighbors from the
+// network namespace of pid.
+func (i *Inspection) inspectNamespace(k kernel.Kernel, pid int, name string) error {
+	return k.InNamespace(pid, func() error {
+		return i.inspectLinks(k, name)
+	})
+}
+
+// inspectLinks fills in the interface, routes and neighbors from the current
+// network namespace.
+func (i *Inspection) inspectLinks(k kernel.Kernel, name string) error {
+	// The interface is missing if the container was not set up by us, the
+	// routes and neighbors are still useful then.
+	if link, err := k.LinkByName(name); err == nil {
+		i.Interface = linkInterface(k, link)
+	}
+
+	routes, err := k.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("listing routes failed: %v", err)
+	}
+	for _, r := range routes {
+		route := Route{
+			Destination: "default",
+			Interface:   linkName(k, r.LinkIndex),
+			Scope:       scopeName(r.Scope),
+		}
+		if r.Dst != nil {
+			route.Destination = r.Dst.String()
+		}
+		if r.Gw != nil {
+			route.Gateway = r.Gw.String()
+		}
+		if r.Src != nil {
+			route.Source = r.Src.String()
+		}
+		i.Routes = append(i.Routes, route)
+	}
+
+	neighbors, err := k.NeighList(0, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("listing neighbors failed: %v", err)
+	}
+	for _, n := range neighbors {
+		neighbor := Neighbor{
+			IP:        n.IP.String(),
+			State:     neighborState(n.State),
+			Interface: linkName(k, n.LinkIndex),
+		}
+		if len(n.HardwareAddr) > 0 {
+			neighbor.MAC = n.HardwareAddr.String()
+		}
+		i.Neighbors = append(i.Neighbors, neighbor)
+	}
+
+	return nil
+}
+
+// linkInterface returns the description of a link.
+func linkInterface(k kernel.Kernel, link netlink.Link) *Interface {
+	attrs := link.Attrs()
+	iface := &Interface{
+		Name:      attrs.Name,
+		Index:     attrs.Index,
+		MAC:       attrs.HardwareAddr.String(),
+		MTU:       attrs.MTU,
+		State:     attrs.OperState.String(),
+		Up:        attrs.Flags&net.FlagUp != 0,
+		Addresses: []string{},
+	}
+
+	addrs, err := k.AddrList(link, netlink.FAMILY_ALL)
+	if err == nil {
+		for _, addr := range addrs {
+			iface.Addresses = append(iface.Addresses, addr.IPNet.String())
+		}
+	}
+
+	return iface
+}
+
+// bridgePort returns the description of the local side of a veth pair.
+func bridgePort(k kernel.Kernel, link netlink.Link, bridgeName string) *BridgePort {
+	port := &BridgePort{
+		Interface: *linkInterface(k, link),
+		Bridge:    bridgeName,
+		PortState: "unknown",
+	}
+
+	// The port state is not part of the protinfo exposed by netlink.
+	b, err := ioutil.ReadFile(filepath.Join("/sys/class/net", link.Attrs().Name, "brport", "state"))
+	if err == nil {
+		if state, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			port.PortState = portState(state)
+		}
+	}
+
+	if protinfo, err := k.LinkGetProtinfo(link); err == nil {
+		port.Hairpin = protinfo.Hairpin
+	}
+
+	return port
+}
+
+// natInfo returns the nat rules that apply to ip and the ports forwarded to
+// it.
+func natInfo(rules []string, ip net.IP) NATInfo {
+	info := NATInfo{
+		Rules:        []string{},
+		PortMappings: []PortMapping{},
+	}
+
+	for _, rule := range rules {
+		args := strings.Fields(rule)
+		if !ruleMatches(args, ip) {
+			continue
+		}
+		info.Rules = append(info.Rules, rule)
+
+		if m, ok := portMapping(args); ok {
+			info.PortMappings = append(info.PortMappings, m)
+		}
+	}
+
+	return info
+}
+
+// ruleMatches returns true if the source, destination or the destination nat
+// address of the rule arguments contains ip.
+func ruleMatches(args []string, ip net.IP) bool {
+	for j := 0; j < len(args)-1; j++ {
+		switch args[j] {
+		case "-s", "--source", "-d", "--destination":
+			if addrContains(args[j+1], ip) {
+				return true
+			}
+		case "--to-destination":
+			host, _ := splitHostPort(args[j+1])
+			if addrContains(host, ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// portMapping returns the port mapping for a DNAT rule.
+func portMapping(args []string) (PortMapping, bool) {
+	var (
+		m    PortMapping
+		dnat bool
+	)
+
+	for j := 0; j < len(args)-1; j++ {
+		value := args[j+1]
+		switch args[j] {
+		case "-j":
+			dnat = value == "DNAT"
+		case "-p", "--protocol":
+			m.Proto = value
+		case "-d", "--destination":
+			m.HostIP = strings.TrimSuffix(value, "/32")
+		case "--dport", "--destination-port":
+			m.HostPort = value
+		case "--to-destination":
+			_, m.ContainerPort = splitHostPort(value)
+		}
+	}
+
+	if !dnat || len(m.HostPort) == 0 {
+		return m, false
+	}
+	if len(m.ContainerPort) == 0 {
+		m.ContainerPort = m.HostPort
+	}
+
+	return m, true
+}
+
+// addrContains returns true if s, an address or a CIDR, contains ip.
+func addrContains(s string, ip net.IP) bool {
+	if _, cidr, err := net.ParseCIDR(s); err == nil {
+		return cidr.Contains(ip)
+	}
+	return ip.Equal(net.ParseIP(s))
+}
+
+// splitHostPort splits the address of an iptables nat target, which does not
+// always have a port.
+func splitHostPort(s string) (host, port string) {
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		return h, p
+	}
+	return s, ""
+}
+
+// linkName returns the name of the link with the given index.
+func linkName(k kernel.Kernel, index int) string {
+	if index == 0 {
+		return ""
+	}
+	link, err := k.LinkByIndex(index)
+	if err != nil {
+		return strconv.Itoa(index)
+	}
+	return link.Attrs().Name
+}
+
+func scopeName(scope netlink.Scope) string {
+	switch scope {
+	case netlink.SCOPE_UNIVERSE:
+		return "universe"
+	case netlink.SCOPE_SITE:
+		return "site"
+	case netlink.SCOPE_LINK:
+		return "link"
+	case netlink.SCOPE_HOST:
+		return "host"
+	case netlink.SCOPE_NOWHERE:
+		return "nowhere"
+	}
+	return strconv.Itoa(int(scope))
+}
+
+func neighborState(state int) string {
+	switch state {
+	case netlink.NUD_NONE:
+		return "none"
+	case netlink.NUD_INCOMPLETE:
+		return "incomplete"
+	case netlink.NUD_REACHABLE:
+		return "reachable"
+	case netlink.NUD_STALE:
+		return "stale"
+	case netlink.NUD_DELAY:
+		return "delay"
+	case netlink.NUD_PROBE:
+		return "probe"
+	case netlink.NUD_FAILED:
+		return "failed"
+	case netlink.NUD_NOARP:
+		return "noarp"
+	case netlink.NUD_PERMANENT:
+		return "permanent"
+	}
+	return strconv.Itoa(state)
+}
+
+// portState returns the name of a bridge port state from
+// /sys/class/net/<port>/brport/state.
+func portState(state int) string {
+	switch state {
+	case 0:
+		return "disabled"
+	case 1:
+		return "listening"
+	case 2:
+		return "learning"
+	case 3:
+		return "forwarding"
+	case 4:
+		return "blocking"
+	}
+	return strconv.Itoa(state)
+}
@@ -0,0 +1,48 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNATInfo(t *testing.T) {
+	rules := []string{
+		"-A POSTROUTING -s 172.19.0.0/16 ! -o netns0 -j MASQUERADE",
+		"-A POSTROUTING -s 10.0.0.0/8 -j MASQUERADE",
+		"-A PREROUTING -d 192.168.1.10/32 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.19.0.3:80",
+		"-A PREROUTING -p udp -m udp --dport 53 -j DNAT --to-destination 172.19.0.3",
+		"-A PREROUTING -p tcp -m tcp --dport 8081 -j DNAT --to-destination 172.19.0.4:80",
+	}
+
+	info := natInfo(rules, net.ParseIP("172.19.0.3"))
+
+	expectedRules := []string{rules[0], rules[2], rules[3]}
+	if len(info.Rules) != len(expectedRules) {
+		t.Fatalf("expected rules %v got %v", expectedRules, info.Rules)
+	}
+	for i := range expectedRules {
+		if info.Rules[i] != expectedRules[i] {
+			t.Fatalf("expected rule %d to be %q got %q", i, expectedRules[i], info.Rules[i])
+		}
+	}
+
+	expectedMappings := []PortMapping{
+		{Proto: "tcp", HostIP: "192.168.1.10", HostPort: "8080", ContainerPort: "80"},
+		{Proto: "udp", HostPort: "53", ContainerPort: "53"},
+	}
+	if len(info.PortMappings) != len(expectedMappings) {
+		t.Fatalf("expected port mappings %v got %v", expectedMappings, info.PortMappings)
+	}
+	for i := range expectedMappings {
+		if info.PortMappings[i] != expectedMappings[i] {
+			t.Fatalf("expected port mapping %d to be %#v got %#v", i, expectedMappings[i], info.PortMappings[i])
+		}
+	}
+}
+
+func TestNATInfoEmpty(t *testing.T) {
+	info := natInfo(nil, net.ParseIP("172.19.0.3"))
+	if info.Rules == nil || info.PortMappings == nil {
+		t.Fatal("expected empty lists, not nil")
+	}
+}
@@ -0,0 +1,199 @@
+package network
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AnnotationEgress is the container annotation holding the egress
+	// bandwidth limit, it overrides Opt.Limits.
+	AnnotationEgress = "netns.egress"
+	// AnnotationIngress is the container annotation holding the ingress
+	// bandwidth limit, it overrides Opt.Limits.
+	AnnotationIngress = "netns.ingress"
+
+	// minBurst is the smallest burst we allow, it has to be bigger than the
+	// MTU of the interface or no packet would ever be sent.
+	minBurst = 64 * 1024
+)
+
+// Limits holds the bandwidth limits for a container. Egress is the traffic
+// sent by the container and Ingress the traffic it receives.
+type Limits struct {
+	Egress  *RateLimit `json:"egress,omitempty"`
+	Ingress *RateLimit `json:"ingress,omitempty"`
+}
+
+// RateLimit holds a token bucket rate limit.
+type RateLimit struct {
+	// Rate is the rate in bytes per second.
+	Rate uint64 `json:"rate"`
+	// Burst is the size of the bucket in bytes.
+	Burst uint32 `json:"burst"`
+}
+
+// ParseRateLimit parses a rate limit in the form of "rate=100mbit,burst=1mb".
+// Rates and sizes use the same units as tc(8). The burst is optional.
+func ParseRateLimit(s string) (*RateLimit, error) {
+	r := &RateLimit{}
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("parsing rate limit %q failed: expected key=value, got %q", s, kv)
+		}
+
+		var err error
+		switch parts[0] {
+		case "rate":
+			r.Rate, err = parseRate(parts[1])
+		case "burst":
+			var size uint64
+			size, err = parseSize(parts[1])
+			if err == nil && size > 1<<32-1 {
+				err = fmt.Errorf("burst %q is too large", parts[1])
+			}
+			r.Burst = uint32(size)
+		default:
+			err = fmt.Errorf("unknown key %q", parts[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing rate limit %q failed: %v", s, err)
+		}
+	}
+
+	if r.Rate == 0 {
+		return nil, fmt.Errorf("parsing rate limit %q failed: rate is required", s)
+	}
+
+	// Default the burst to 10ms worth of traffic.
+	if r.Burst == 0 {
+		r.Burst = uint32(r.Rate / 100)
+	}
+	if r.Burst < minBurst {
+		r.Burst = minBurst
+	}
+
+	return r, nil
+}
+
+// String returns the rate limit in the form accepted by ParseRateLimit.
+func (r RateLimit) String() string {
+	return fmt.Sprintf("rate=%s,burst=%s", formatRate(r.Rate), formatSize(uint64(r.Burst)))
+}
+
+var (
+	// rateUnits are the units for rates in bytes per second.
+	rateUnits = []struct {
+		suffix string
+		mult   uint64
+	}{
+		{"tbit", 1000 * 1000 * 1000 * 1000 / 8},
+		{"gbit", 1000 * 1000 * 1000 / 8},
+		{"mbit", 1000 * 1000 / 8},
+		{"kbit", 1000 / 8},
+		{"tbps", 1000 * 1000 * 1000 * 1000},
+		{"gbps", 1000 * 1000 * 1000},
+		{"mbps", 1000 * 1000},
+		{"kbps", 1000},
+		{"bps", 1},
+	}
+	// sizeUnits are the units for sizes in bytes.
+	sizeUnits = []struct {
+		suffix string
+		mult   uint64
+	}{
+		{"gb", 1024 * 1024 * 1024},
+		{"mb", 1024 * 1024},
+		{"kb", 1024},
+		{"g", 1024 * 1024 * 1024},
+		{"m", 1024 * 1024},
+		{"k", 1024},
+		{"b", 1},
+	}
+)
+
+// parseRate parses a rate into bytes per second.
+func parseRate(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	for _, u := range rateUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.ParseUint(strings.TrimSuffix(s, u.suffix), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid rate %q", s)
+			}
+			return n * u.mult, nil
+		}
+	}
+
+	// Plain bits are rounded down to bytes.
+	if strings.HasSuffix(s, "bit") {
+		n, err := strconv.ParseUint(strings.TrimSuffix(s, "bit"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rate %q", s)
+		}
+		return n / 8, nil
+	}
+
+	// Like tc, a rate without unit is in bytes per second.
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n, nil
+}
+
+// parseSize parses a size into bytes.
+func parseSize(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.ParseUint(strings.TrimSuffix(s, u.suffix), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return n * u.mult, nil
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n, nil
+}
+
+// formatRate formats a rate in bytes per second using the largest bit unit
+// that represents it exactly.
+func formatRate(rate uint64) string {
+	bits := rate * 8
+	for _, u := range []struct {
+		suffix string
+		mult   uint64
+	}{
+		{"tbit", 1000 * 1000 * 1000 * 1000},
+		{"gbit", 1000 * 1000 * 1000},
+		{"mbit", 1000 * 1000},
+		{"kbit", 1000},
+	} {
+		if bits >= u.mult && bits%u.mult == 0 {
+			return fmt.Sprintf("%d%s", bits/u.mult, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dbit", bits)
+}
+
+// formatSize formats a size in bytes using the largest unit that represents
+// it exactly.
+func formatSize(size uint64) string {
+	for _, u := range sizeUnits[:3] {
+		if size >= u.mult && size%u.mult == 0 {
+			return fmt.Sprintf("%d%s", size/u.mult, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%db", size)
+}
@@ -0,0 +1,146 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/genuinetools/netns/kernel"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	testcases := map[string]RateLimit{
+		"rate=100mbit,burst=1mb": {Rate: 12500000, Burst: 1024 * 1024},
+		"rate=1gbit":             {Rate: 125000000, Burst: 1250000},
+		"rate=10kbps":            {Rate: 10000, Burst: minBurst},
+		"rate=800bit,burst=128k": {Rate: 100, Burst: 128 * 1024},
+		"rate=2000, burst=100kb": {Rate: 2000, Burst: 100 * 1024},
+	}
+
+	for in, expected := range testcases {
+		l, err := ParseRateLimit(in)
+		if err != nil {
+			t.Fatalf("parsing %q failed: %v", in, err)
+		}
+
+		if *l != expected {
+			t.Fatalf("expected %q to parse as %#v got %#v", in, expected, *l)
+		}
+	}
+
+	for _, in := range []string{"", "100mbit", "burst=1mb", "rate=fast", "rate=1mbit,burst=1xb", "rate=1mbit,ceil=2mbit"} {
+		if _, err := ParseRateLimit(in); err == nil {
+			t.Fatalf("expected an error parsing %q", in)
+		}
+	}
+}
+
+func TestRateLimitString(t *testing.T) {
+	for _, in := range []string{"rate=100mbit,burst=1mb", "rate=1500kbit,burst=64kb", "rate=16bit,burst=65537b"} {
+		l, err := ParseRateLimit(in)
+		if err != nil {
+			t.Fatalf("parsing %q failed: %v", in, err)
+		}
+
+		if l.String() != in {
+			t.Fatalf("expected %q got %q", in, l.String())
+		}
+	}
+}
+
+func TestCreateLimits(t *testing.T) {
+	c, k := newTestClient(t)
+	c.opt.Limits = Limits{
+		Egress:  &RateLimit{Rate: 12500000, Burst: 1024 * 1024},
+		Ingress: &RateLimit{Rate: 1250000, Burst: minBurst},
+	}
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+
+	// The traffic received by the veth on the host is redirected to the ifb
+	// device, which is shaped by the egress limit.
+	veth, err := k.LinkByName("netnsv0-1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifb, err := k.LinkByName("netnsv0i-1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ifb.Attrs().Flags&net.FlagUp == 0 {
+		t.Fatal("expected the ifb device to be up")
+	}
+	filters, err := k.FilterList(veth, ingressHandle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 1 || filters[0].(*netlink.U32).RedirIndex != ifb.Attrs().Index {
+		t.Fatalf("expected the traffic of the veth to be redirected to the ifb device, got %v", filters)
+	}
+	if rate := tbfRate(t, k, ifb); rate != 12500000 {
+		t.Fatalf("expected the egress rate on the ifb device got %d", rate)
+	}
+	if rate := tbfRate(t, k, veth); rate != 1250000 {
+		t.Fatalf("expected the ingress rate on the veth got %d", rate)
+	}
+
+	// Nothing is left in the container to remove.
+	if err := k.InNamespace(1234, func() error {
+		iface, err := k.LinkByName(DefaultContainerInterface)
+		if err != nil {
+			return err
+		}
+		qdiscs, err := k.QdiscList(iface)
+		if err != nil {
+			return err
+		}
+		if len(qdiscs) != 0 {
+			t.Fatalf("expected no qdiscs in the container got %v", qdiscs)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ifb device is deleted with the network.
+	if err := c.Delete(context.Background(), "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if links := k.Links(0); !reflect.DeepEqual(links, []string{defaultBridgeName}) {
+		t.Fatalf("expected only the bridge after the delete, got %v", links)
+	}
+}
+
+func TestCreateLimitsRollback(t *testing.T) {
+	c, k := newTestClient(t)
+	c.opt.Limits = Limits{
+		Egress: &RateLimit{Rate: 12500000, Burst: 1024 * 1024},
+	}
+
+	// Redirecting the traffic fails once the ifb device is set up.
+	k.Fail("FilterAdd", errors.New("operation not supported"))
+	if _, err := testCreate(c, k, 1234); err == nil {
+		t.Fatal("expected an error")
+	}
+	if links := k.Links(0); !reflect.DeepEqual(links, []string{defaultBridgeName}) {
+		t.Fatalf("expected only the bridge after the rollback, got %v", links)
+	}
+}
+
+// tbfRate returns the rate of the root tbf qdisc of the link.
+func tbfRate(t *testing.T, k *kernel.Fake, link netlink.Link) uint64 {
+	qdiscs, err := k.QdiscList(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range qdiscs {
+		if tbf, ok := q.(*netlink.Tbf); ok && q.Attrs().Parent == netlink.HANDLE_ROOT {
+			return tbf.Rate
+		}
+	}
+	return 0
+}
@@ -0,0 +1,197 @@
+package network
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/genuinetools/netns/kernel"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// AnnotationMTU is the container annotation holding the MTU of the veth
+	// pair, it overrides Opt.Link.
+	AnnotationMTU = "netns.mtu"
+	// AnnotationTxQueueLen is the container annotation holding the length
+	// of the transmit queue of the veth pair, it overrides Opt.Link.
+	AnnotationTxQueueLen = "netns.txqueuelen"
+	// AnnotationOffloads is the container annotation holding the offloads
+	// of the veth pair, they are added to the ones of Opt.Link.
+	AnnotationOffloads = "netns.offloads"
+
+	// minMTU and maxMTU are the bounds of the MTU of a veth.
+	minMTU = 68
+	maxMTU = 65535
+
+	// maxLinkName is the longest name of a link, IFNAMSIZ without the
+	// terminating null byte.
+	maxLinkName = 15
+	// shortPrefixLen is the length of the shortened port prefixes, it
+	// leaves room for the dash and a pid up to 4194304, the largest pid_max.
+	shortPrefixLen = maxLinkName - 8
+)
+
+// LinkOpt holds the settings of the veth pairs of the containers.
+type LinkOpt struct {
+	// MTU of both sides of the veth pair, the MTU of the bridge if 0.
+	MTU int `json:"mtu,omitempty"`
+	// TxQueueLen is the length of the transmit queue of both sides, the
+	// default of the kernel if 0.
+	TxQueueLen int `json:"txqueuelen,omitempty"`
+	// Offloads turns the offloads of both sides on or off, the others keep
+	// the default of the kernel.
+	Offloads Offloads `json:"offloads,omitempty"`
+}
+
+// Offloads are offloads of a network interface, see the kernel.Offload
+// constants, and whether they are on.
+type Offloads map[string]bool
+
+// ParseOffloads parses offloads in the form of "tso=off,gso=off,tx-checksum=on".
+func ParseOffloads(s string) (Offloads, error) {
+	o := Offloads{}
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("parsing offloads %q failed: expected key=value, got %q", s, kv)
+		}
+
+		switch parts[0] {
+		case kernel.OffloadTSO, kernel.OffloadGSO, kernel.OffloadTxChecksum, kernel.OffloadRxChecksum:
+		default:
+			return nil, fmt.Errorf("parsing offloads %q failed: unknown offload %q, must be one of %s, %s, %s or %s", s, parts[0], kernel.OffloadTSO, kernel.OffloadGSO, kernel.OffloadTxChecksum, kernel.OffloadRxChecksum)
+		}
+		switch parts[1] {
+		case "on":
+			o[parts[0]] = true
+		case "off":
+			o[parts[0]] = false
+		default:
+			return nil, fmt.Errorf("parsing offloads %q failed: %s must be on or off, got %q", s, parts[0], parts[1])
+		}
+	}
+
+	return o, nil
+}
+
+func (o Offloads) String() string {
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []string
+	for _, name := range names {
+		state := "off"
+		if o[name] {
+			state = "on"
+		}
+		fields = append(fields, name+"="+state)
+	}
+	return strings.Join(fields, ",")
+}
+
+// linkOpt returns the settings of the veth pair for a container from the
+// defaults and the container annotations.
+func (c *Client) linkOpt(annotations map[string]string) (LinkOpt, error) {
+	opt := c.opt.Link
+
+	if s, ok := annotations[AnnotationMTU]; ok {
+		mtu, err := strconv.Atoi(s)
+		if err != nil {
+			return opt, fmt.Errorf("parsing annotation %s failed: %v", AnnotationMTU, err)
+		}
+		opt.MTU = mtu
+	}
+	if opt.MTU != 0 && (opt.MTU < minMTU || opt.MTU > maxMTU) {
+		return opt, fmt.Errorf("mtu %d must be between %d and %d", opt.MTU, minMTU, maxMTU)
+	}
+
+	if s, ok := annotations[AnnotationTxQueueLen]; ok {
+		qlen, err := strconv.Atoi(s)
+		if err != nil {
+			return opt, fmt.Errorf("parsing annotation %s failed: %v", AnnotationTxQueueLen, err)
+		}
+		opt.TxQueueLen = qlen
+	}
+	if opt.TxQueueLen < 0 {
+		return opt, fmt.Errorf("txqueuelen %d cannot be negative", opt.TxQueueLen)
+	}
+
+	if s, ok := annotations[AnnotationOffloads]; ok {
+		o, err := ParseOffloads(s)
+		if err != nil {
+			return opt, fmt.Errorf("parsing annotation %s failed: %v", AnnotationOffloads, err)
+		}
+		offloads := Offloads{}
+		for name, on := range opt.Offloads {
+			offloads[name] = on
+		}
+		for name, on := range o {
+			offloads[name] = on
+		}
+		opt.Offloads = offloads
+	}
+
+	return opt, nil
+}
+
+// setOffloads sets the offloads of the links in the current network
+// namespace.
+func setOffloads(k kernel.Kernel, offloads Offloads, links ...netlink.Link) error {
+	names := make([]string, 0, len(offloads))
+	for name := range offloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, link := range links {
+		for _, name := range names {
+			if err := k.LinkSetOffload(link, name, offloads[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pidLinkName returns the name of a link for pid, prefix-pid. The prefix is
+// shortened if the name would not fit in IFNAMSIZ.
+func pidLinkName(prefix string, pid int) string {
+	name := fmt.Sprintf("%s-%d", prefix, pid)
+	if len(name) <= maxLinkName {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", shortPrefix(prefix), pid)
+}
+
+// shortPrefix returns the start of prefix followed by a hash of it, so the
+// shortened prefixes of different networks do not collide.
+func shortPrefix(prefix string) string {
+	if len(prefix) <= shortPrefixLen {
+		return prefix
+	}
+	h := fnv.New32a()
+	h.Write([]byte(prefix))
+	return fmt.Sprintf("%s%05x", prefix[:shortPrefixLen-5], h.Sum32()&0xfffff)
+}
+
+// vethPID returns the pid of the local side of a veth pair from its name, or
+// false if it is not one of the client.
+func (c *Client) vethPID(name string) (int, bool) {
+	for _, prefix := range []string{c.opt.PortPrefix, shortPrefix(c.opt.PortPrefix)} {
+		if !strings.HasPrefix(name, prefix+"-") {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimPrefix(name, prefix+"-"))
+		if err == nil && c.vethName(pid) == name {
+			return pid, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/kernel"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+func TestParseOffloads(t *testing.T) {
+	o, err := ParseOffloads("tso=off, gso=off,tx-checksum=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(o, Offloads{"tso": false, "gso": false, "tx-checksum": true}) {
+		t.Fatalf("unexpected offloads %v", o)
+	}
+	if o.String() != "gso=off,tso=off,tx-checksum=on" {
+		t.Fatalf("unexpected string %s", o.String())
+	}
+
+	for _, s := range []string{"tso", "lro=off", "tso=yes"} {
+		if _, err := ParseOffloads(s); err == nil {
+			t.Fatalf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestPidLinkName(t *testing.T) {
+	testcases := []struct {
+		prefix   string
+		pid      int
+		expected string
+	}{
+		{"netnsv0", 1234, "netnsv0-1234"},
+		{"netnsv0", 4194304, "netnsv0-4194304"},
+		{"frontend", 1234, "frontend-1234"},
+		{"frontend", 4194304, "fr9377d-4194304"},
+		{"frontend-network", 1, "fr905f6-1"},
+	}
+	for _, tc := range testcases {
+		got := pidLinkName(tc.prefix, tc.pid)
+		if got != tc.expected {
+			t.Fatalf("expected %s for %s and %d got %s", tc.expected, tc.prefix, tc.pid, got)
+		}
+		if len(got) > maxLinkName {
+			t.Fatalf("%s is longer than %d", got, maxLinkName)
+		}
+	}
+
+	if shortPrefix("frontend") == shortPrefix("frontend2") {
+		t.Fatal("expected the shortened prefixes to differ")
+	}
+
+	c, _ := newTestClient(t)
+	c.opt.PortPrefix = "frontend"
+	for _, pid := range []int{1234, 4194304} {
+		if got, ok := c.vethPID(c.vethName(pid)); !ok || got != pid {
+			t.Fatalf("expected pid %d from %s got %d", pid, c.vethName(pid), got)
+		}
+	}
+	if _, ok := c.vethPID("frontend-web"); ok {
+		t.Fatal("expected frontend-web not to be a veth of the client")
+	}
+}
+
+func TestCreateLinkOpt(t *testing.T) {
+	c, k := newTestClient(t)
+	c.opt.Link.Offloads = Offloads{kernel.OffloadTSO: false}
+	brOpt := bridge.Opt{IPAddr: defaultBridgeIP, Name: defaultBridgeName, MTU: 9000}
+
+	// The veths get the MTU of the bridge by default.
+	k.AddProcess(1234)
+	if _, err := c.Create(context.Background(), configs.HookState{Pid: 1234}, brOpt, ""); err != nil {
+		t.Fatal(err)
+	}
+	local, err := k.LinkByName("netnsv0-1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local.Attrs().MTU != 9000 {
+		t.Fatalf("expected mtu 9000 on the host got %d", local.Attrs().MTU)
+	}
+	if err := k.InNamespace(1234, func() error {
+		peer, err := k.LinkByName(DefaultContainerInterface)
+		if err != nil {
+			return err
+		}
+		if peer.Attrs().MTU != 9000 {
+			t.Fatalf("expected mtu 9000 in the container got %d", peer.Attrs().MTU)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if o := k.Offloads(1234, DefaultContainerInterface); !reflect.DeepEqual(o, map[string]bool{"tso": false}) {
+		t.Fatalf("unexpected offloads in the container %v", o)
+	}
+
+	// The annotations override the defaults.
+	k.AddProcess(1235)
+	if _, err := c.Create(context.Background(), configs.HookState{
+		Pid: 1235,
+		Annotations: map[string]string{
+			AnnotationMTU:        "1450",
+			AnnotationTxQueueLen: "5000",
+			AnnotationOffloads:   "gso=off,tx-checksum=off",
+		},
+	}, brOpt, ""); err != nil {
+		t.Fatal(err)
+	}
+	local, err = k.LinkByName("netnsv0-1235")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local.Attrs().MTU != 1450 || local.Attrs().TxQLen != 5000 {
+		t.Fatalf("expected mtu 1450 and txqueuelen 5000 got %d and %d", local.Attrs().MTU, local.Attrs().TxQLen)
+	}
+	expected := map[string]bool{"tso": false, "gso": false, "tx-checksum": false}
+	if o := k.Offloads(0, "netnsv0-1235"); !reflect.DeepEqual(o, expected) {
+		t.Fatalf("unexpected offloads on the host %v", o)
+	}
+	if o := k.Offloads(1235, DefaultContainerInterface); !reflect.DeepEqual(o, expected) {
+		t.Fatalf("unexpected offloads in the container %v", o)
+	}
+
+	k.AddProcess(1236)
+	if _, err := c.Create(context.Background(), configs.HookState{
+		Pid:         1236,
+		Annotations: map[string]string{AnnotationMTU: "20"},
+	}, brOpt, ""); err == nil {
+		t.Fatal("expected an error for a mtu of 20")
+	}
+}
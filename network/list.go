@@ -1,43 +1,53 @@
 package network
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
-	"os"
-	"strconv"
-	"strings"
 
-	"github.com/vishvananda/netns"
 	bolt "go.etcd.io/bbolt"
 )
 
+// errNoNetworks is the error listing the networks before any was created.
+var errNoNetworks = errors.New("no networks found")
+
 // List returns the ip addresses being used from the database for the networks
 // with the specified bridge name.
-func (c *Client) List() ([]Network, error) {
+func (c *Client) List(ctx context.Context) ([]Network, error) {
+	networks, err := c.list(ctx)
+	// When it cannot write to the db because it has not been created return
+	// early.
+	if err == ErrDatabaseDoesNotExist {
+		return nil, errNoNetworks
+	}
+	return networks, err
+}
+
+// list returns the networks, or ErrDatabaseDoesNotExist if the database has
+// not been created.
+func (c *Client) list(ctx context.Context) ([]Network, error) {
 	// Open the database.
-	if err := c.openDB(true); err != nil {
-		// When it cannot write to the db because it has not been created return
-		// early.
-		if strings.Contains(err.Error(), "bad file descriptor") {
-			return nil, errors.New("no networks found")
-		}
+	if err := c.openDB(ctx, true); err != nil {
 		return nil, err
 	}
-	defer c.db.Close()
+	defer c.closeDB()
 
 	//We should check after openDB, or the db field will be nil forever.
 	if c.db == nil {
-		return nil, errors.New("no networks found")
+		return nil, errNoNetworks
 	}
 
-	var (
-		networks = []Network{}
-		err      error
-	)
+	// The holders of the namespaces are looked up once for all the networks.
+	holders := &namespaceHolders{kernel: c.kernel}
+
+	networks := []Network{}
 	if err := c.db.View(func(tx *bolt.Tx) error {
 		// Retrieve the networks from the bucket.
 		b := tx.Bucket(ipBucket)
+		if b == nil {
+			return nil
+		}
 
 		return b.ForEach(func(k, v []byte) error {
 			// skip last ip
@@ -47,22 +57,21 @@ func (c *Client) List() ([]Network, error) {
 
 			//If we use k directly, the pointer to k will be lost after the List function returns
 			n := Network{
-				IP: net.ParseIP(((net.IP)(k)).String()),
-			}
-
-			// Get the pid.
-			n.PID, err = strconv.Atoi(string(v))
-			if err != nil {
-				return fmt.Errorf("parsing pid %s as int failed: %v", v, err)
+				IP:     net.ParseIP(((net.IP)(k)).String()),
+				Bridge: c.opt.BridgeName,
 			}
 
-			// Check the process.
-			_, err := os.FindProcess(n.PID)
+			// Get the allocation record.
+			a, err := getAllocation(tx, k, v)
 			if err != nil {
-				n.Status = "does not exist"
-			} else {
-				n.Status = "running"
+				return err
 			}
+			n.PID = a.PID
+			n.ContainerID = a.ContainerID
+			n.Hostname = a.Hostname
+			n.Limits = a.Limits
+			n.Netem = a.Netem
+			n.Status = c.statusWith(a, holders)
 
 			// Get the veth pair from the pid.
 			n.VethPair, err = c.vethPair(n.PID, c.opt.BridgeName)
@@ -70,11 +79,9 @@ func (c *Client) List() ([]Network, error) {
 				return fmt.Errorf("getting vethpair %d failed: %v", n.PID, err)
 			}
 
-			// Try to get the namespace handle.
-			n.FD, _ = netns.GetFromPid(n.PID)
-			if n.FD <= 0 {
-				n.Status = "destroyed"
-			}
+			// Get the traffic counters, the link is gone if the container
+			// was destroyed.
+			n.Stats, _ = linkStats(c.kernel, n.VethPair.Name)
 
 			networks = append(networks, n)
 
@@ -0,0 +1,78 @@
+package network
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// steps records the duration of the steps of an operation so they can be
+// logged in a single summary record when the operation finishes.
+type steps struct {
+	bridge    string
+	log       *logrus.Entry
+	start     time.Time
+	names     []string
+	durations map[string]time.Duration
+
+	current      string
+	currentStart time.Time
+}
+
+func newSteps(bridge string, log *logrus.Entry) *steps {
+	return &steps{
+		bridge:    bridge,
+		log:       log,
+		start:     time.Now(),
+		durations: map[string]time.Duration{},
+	}
+}
+
+// begin ends the current step and starts a new one. It returns the logger
+// for the step, with the phase of the operation set.
+func (s *steps) begin(name string) *logrus.Entry {
+	s.end()
+
+	s.current = name
+	s.currentStart = time.Now()
+	s.names = append(s.names, name)
+	return s.log.WithField("phase", name)
+}
+
+// end ends the current step.
+func (s *steps) end() {
+	if len(s.current) < 1 {
+		return
+	}
+
+	s.durations[s.current] += time.Since(s.currentStart)
+	s.current = ""
+}
+
+// summary logs the outcome and the duration of each step of the operation,
+// and records them in the metrics.
+func (s *steps) summary(operation string, err error) {
+	s.end()
+	s.observe(operation, err)
+
+	fields := logrus.Fields{
+		"phase":       "summary",
+		"operation":   operation,
+		"outcome":     "success",
+		"duration_ms": milliseconds(time.Since(s.start)),
+	}
+	for _, name := range s.names {
+		fields[name+"_ms"] = milliseconds(s.durations[name])
+	}
+
+	if err != nil {
+		fields["outcome"] = "failure"
+		s.log.WithFields(fields).WithError(err).Errorf("%s failed", operation)
+		return
+	}
+	s.log.WithFields(fields).Infof("%s succeeded", operation)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,48 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStepsSummary(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	st := newSteps("netns0", logger.WithField("container", "web"))
+	st.begin("bridge_init").Info("initializing")
+	st.begin("allocate")
+	st.summary("create", errors.New("no ip left"))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines got %d: %s", len(lines), buf.String())
+	}
+
+	var step map[string]interface{}
+	if err := json.Unmarshal(lines[0], &step); err != nil {
+		t.Fatal(err)
+	}
+	if step["phase"] != "bridge_init" || step["container"] != "web" {
+		t.Fatalf("expected the phase and container fields to be set got %v", step)
+	}
+
+	var summary map[string]interface{}
+	if err := json.Unmarshal(lines[1], &summary); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"bridge_init_ms", "allocate_ms", "duration_ms"} {
+		if _, ok := summary[key].(float64); !ok {
+			t.Fatalf("expected %s in the summary got %v", key, summary)
+		}
+	}
+	if summary["outcome"] != "failure" || summary["error"] != "no ip left" || summary["container"] != "web" {
+		t.Fatalf("expected a failed summary for web got %v", summary)
+	}
+}
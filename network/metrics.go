@@ -0,0 +1,89 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/genuinetools/netns/kernel"
+	"github.com/genuinetools/netns/metrics"
+)
+
+var (
+	allocationsTotal = metrics.NewCounterVec("netns_allocations_total",
+		"Number of ip addresses allocated to containers.", "bridge")
+	releasesTotal = metrics.NewCounterVec("netns_releases_total",
+		"Number of ip addresses released by deleting networks.", "bridge")
+	failuresTotal = metrics.NewCounterVec("netns_failures_total",
+		"Number of failed operations by the step that failed.", "bridge", "operation", "reason")
+	operationDuration = metrics.NewHistogramVec("netns_operation_duration_seconds",
+		"Duration of the operations by outcome.", metrics.DefaultBuckets, "operation", "outcome")
+	stepDuration = metrics.NewHistogramVec("netns_step_duration_seconds",
+		"Duration of the steps of the operations.", metrics.DefaultBuckets, "operation", "step")
+	probesTotal = metrics.NewCounterVec("netns_probes_total",
+		"Number of checks of whether an ip address is in use, by type (arp for the neighbor table, icmp for ping) and result.", "type", "result")
+	gcRunsTotal = metrics.NewCounterVec("netns_gc_runs_total",
+		"Number of garbage collections of the allocations.", "bridge")
+	gcReclaimedTotal = metrics.NewCounterVec("netns_gc_reclaimed_total",
+		"Number of ip addresses reclaimed from containers whose process is gone.", "bridge")
+	poolSize = metrics.NewGaugeVec("netns_pool_size",
+		"Number of ip addresses of the bridge network that can be allocated.", "bridge")
+	poolUsed = metrics.NewGaugeVec("netns_pool_used",
+		"Number of ip addresses of the bridge network that are allocated.", "bridge")
+)
+
+// observe records the duration of the operation and of its steps, and the
+// step that failed if any.
+func (s *steps) observe(operation string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+		reason := "unknown"
+		if len(s.names) > 0 {
+			reason = s.names[len(s.names)-1]
+		}
+		failuresTotal.Inc(s.bridge, operation, reason)
+	}
+
+	operationDuration.Observe(time.Since(s.start).Seconds(), operation, outcome)
+	for _, name := range s.names {
+		stepDuration.Observe(s.durations[name].Seconds(), operation, name)
+	}
+}
+
+// UpdatePoolMetrics sets the size and the usage of the pool of ip addresses
+// of the bridge.
+func (c *Client) UpdatePoolMetrics(ctx context.Context) error {
+	allocations, err := c.Allocations(ctx)
+	if err != nil {
+		return err
+	}
+	poolUsed.Set(float64(len(allocations)), c.opt.BridgeName)
+
+	// The size is only known once the bridge exists.
+	addr, err := kernel.InterfaceAddr(c.kernel, c.opt.BridgeName)
+	if err != nil {
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(addr.String())
+	if err != nil {
+		return fmt.Errorf("parsing CIDR for %s failed: %v", addr.String(), err)
+	}
+	poolSize.Set(addressCount(ipNet), c.opt.BridgeName)
+
+	return nil
+}
+
+// addressCount returns the number of ip addresses of the network that can be
+// allocated, that is all of them but the network and broadcast addresses and
+// the address of the bridge.
+func addressCount(ipNet *net.IPNet) float64 {
+	ones, bits := ipNet.Mask.Size()
+	n := math.Pow(2, float64(bits-ones)) - 3
+	if n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,46 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAddressCount(t *testing.T) {
+	for cidr, expected := range map[string]float64{
+		"172.19.0.1/16": 65533,
+		"10.0.0.1/24":   253,
+		"10.0.0.1/30":   1,
+		"10.0.0.1/32":   0,
+		"fd00::1/120":   253,
+	} {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := addressCount(ipNet); n != expected {
+			t.Fatalf("expected %v addresses in %s got %v", expected, cidr, n)
+		}
+	}
+}
+
+func TestStepsObserve(t *testing.T) {
+	failures := failuresTotal.Value("metrics0", "create", "veth_add")
+
+	st := newSteps("metrics0", logrus.NewEntry(logrus.New()))
+	st.begin("setup")
+	st.begin("veth_add")
+	st.observe("create", errors.New("no such device"))
+
+	if v := failuresTotal.Value("metrics0", "create", "veth_add"); v != failures+1 {
+		t.Fatalf("expected %v failures of veth_add got %v", failures+1, v)
+	}
+	if n := stepDuration.Count("create", "setup"); n < 1 {
+		t.Fatal("expected the duration of setup to be observed")
+	}
+	if n := operationDuration.Count("create", "failure"); n < 1 {
+		t.Fatal("expected the duration of the failed create to be observed")
+	}
+}
@@ -0,0 +1,314 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/kernel"
+	"github.com/genuinetools/netns/resolvconf"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Migration is the renumbering of a bridge to a new subnet.
+type Migration struct {
+	Bridge string `json:"bridge"`
+	// From and To are the addresses of the bridge, with the prefix length
+	// of their subnet.
+	From *net.IPNet `json:"from"`
+	To   *net.IPNet `json:"to"`
+	// Addresses are the new addresses of the containers.
+	Addresses []Renumbering `json:"addresses"`
+}
+
+// Renumbering is the change of the address of a container.
+type Renumbering struct {
+	PID         int    `json:"pid"`
+	ContainerID string `json:"container_id,omitempty"`
+	From        net.IP `json:"from"`
+	To          net.IP `json:"to"`
+	// Running is false for the containers whose process is gone, only
+	// their allocation is updated.
+	Running bool `json:"running"`
+}
+
+// Migrate renumbers the bridge and the containers on it to the subnet of
+// ipAddr, the new address of the bridge. Each container keeps its offset in
+// the subnet, so 172.19.0.5 becomes 10.20.0.5 when moving from
+// 172.19.0.1/16 to 10.20.0.1/16. The address and the default route of the
+// running containers are changed in their namespace, and the allocations
+// are moved in the database. Nothing is changed if dryRun is true.
+func (c *Client) Migrate(ctx context.Context, brOpt bridge.Opt, ipAddr string, dryRun bool) (*Migration, error) {
+	if brOpt.Kernel == nil {
+		brOpt.Kernel = c.kernel
+	}
+
+	// Open the database.
+	if err := c.openDB(ctx, false); err != nil {
+		return nil, err
+	}
+	defer c.closeDB()
+
+	from, err := kernel.InterfaceAddr(c.kernel, c.opt.BridgeName)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving IP/network of bridge %s failed: %v", c.opt.BridgeName, err)
+	}
+	toIP, to, err := net.ParseCIDR(ipAddr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing address %s failed: %v", ipAddr, err)
+	}
+	if (toIP.To4() == nil) != (from.IP.To4() == nil) {
+		return nil, fmt.Errorf("cannot migrate %s to %s, the address families differ", from.String(), ipAddr)
+	}
+	if len(to.IP) == net.IPv4len {
+		toIP = toIP.To4()
+	}
+	to.IP = toIP
+
+	m := &Migration{
+		Bridge:    c.opt.BridgeName,
+		From:      from,
+		To:        to,
+		Addresses: []Renumbering{},
+	}
+
+	// Map the addresses of the containers.
+	allocations, err := c.allocations()
+	if err != nil {
+		return nil, err
+	}
+	holders := &namespaceHolders{kernel: c.kernel}
+	for _, a := range allocations {
+		ip, err := renumberIP(a.IP, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("renumbering ip %s of pid %d failed: %v", a.IP.String(), a.PID, err)
+		}
+		m.Addresses = append(m.Addresses, Renumbering{
+			PID:         a.PID,
+			ContainerID: a.ContainerID,
+			From:        a.IP,
+			To:          ip,
+			Running:     c.statusWith(a, holders) == StatusRunning,
+		})
+	}
+
+	if err := bridge.CheckRenumber(brOpt, ipAddr); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return m, nil
+	}
+
+	// Move the allocations to their new address first, the transaction is
+	// committed once the bridge is renumbered so nothing changes if they
+	// cannot be moved.
+	tx, err := c.db.Begin(true)
+	if err != nil {
+		return nil, fmt.Errorf("updating allocations failed: %v", err)
+	}
+	defer tx.Rollback()
+	if err := renumberBuckets(tx, m.Addresses, from, to); err != nil {
+		return nil, fmt.Errorf("updating allocations failed: %v", err)
+	}
+
+	// Renumber the bridge before the containers so the new gateway answers
+	// when they switch to it.
+	if err := bridge.Renumber(brOpt, ipAddr); err != nil {
+		return nil, fmt.Errorf("renumbering bridge %s failed: %v", c.opt.BridgeName, err)
+	}
+
+	// A container that cannot be renumbered keeps its old address until
+	// it is restarted, the others are still moved so the database matches
+	// the bridge.
+	var failed []string
+	for i, r := range m.Addresses {
+		a := allocations[i]
+		if !r.Running {
+			continue
+		}
+		if err := c.kernel.InNamespace(a.PID, func() error {
+			return c.renumberLink(c.containerInterface(a), from, to, r.From, r.To)
+		}); err != nil {
+			logrus.Warnf("renumbering interface of pid %d failed: %v", a.PID, err)
+			failed = append(failed, strconv.Itoa(a.PID))
+			continue
+		}
+		if err := c.renumberHosts(a, r.To); err != nil {
+			logrus.Warnf("updating hosts file of %s failed: %v", a.ContainerID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("saving allocations failed, the bridge is renumbered already: %v", err)
+	}
+
+	if len(failed) > 0 {
+		return m, fmt.Errorf("renumbering the interfaces of pids %s failed, restart them to use their new address", strings.Join(failed, ", "))
+	}
+	return m, nil
+}
+
+// renumberIP returns the address at the same offset in the subnet to as ip
+// in the subnet from. It must be a unicast address that is not the gateway.
+func renumberIP(ip net.IP, from, to *net.IPNet) (net.IP, error) {
+	base := &net.IPNet{IP: from.IP.Mask(from.Mask), Mask: from.Mask}
+	if !base.Contains(ip) {
+		return nil, fmt.Errorf("ip is not in subnet %s", base.String())
+	}
+	offset := new(big.Int).Sub(ipToBigInt(ip), ipToBigInt(base.IP))
+
+	newBase := to.IP.Mask(to.Mask)
+	v := new(big.Int).Add(ipToBigInt(newBase), offset)
+	newIP := make(net.IP, len(newBase))
+	v.FillBytes(newIP)
+
+	subnet := &net.IPNet{IP: newBase, Mask: to.Mask}
+	switch {
+	case !subnet.Contains(newIP):
+		return nil, fmt.Errorf("%s is outside of subnet %s", newIP.String(), subnet.String())
+	case newIP.Equal(to.IP):
+		return nil, fmt.Errorf("%s is the address of the bridge", newIP.String())
+	case !isUnicastIP(newIP, to.Mask):
+		return nil, fmt.Errorf("%s is not a unicast address", newIP.String())
+	}
+	return newIP, nil
+}
+
+// renumberLink replaces the address of the interface in the current network
+// namespace and moves the default route to the new gateway.
+func (c *Client) renumberLink(name string, from, to *net.IPNet, oldIP, newIP net.IP) error {
+	link, err := c.kernel.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("getting link %s failed: %v", name, err)
+	}
+
+	// The kernel drops the routes through the old gateway with the old
+	// address, so find them first.
+	routes, err := c.kernel.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("listing routes of interface %s failed: %v", name, err)
+	}
+
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: newIP, Mask: to.Mask}}
+	if err := c.kernel.AddrAdd(link, addr); err != nil {
+		return fmt.Errorf("setting %s interface ip to %s failed: %v", name, addr.IPNet.String(), err)
+	}
+	old := &netlink.Addr{IPNet: &net.IPNet{IP: oldIP, Mask: from.Mask}}
+	if err := c.kernel.AddrDel(link, old); err != nil {
+		return fmt.Errorf("removing ip %s from interface %s failed: %v", old.IPNet.String(), name, err)
+	}
+
+	for _, r := range routes {
+		if !r.Gw.Equal(from.IP) {
+			continue
+		}
+		route := r
+		if err := c.kernel.RouteDel(&route); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("removing route %s of interface %s failed: %v", r.Gw.String(), name, err)
+		}
+		route.Gw = to.IP
+		if err := c.kernel.RouteAdd(&route); err != nil {
+			return fmt.Errorf("adding route %s to interface %s failed: %v", to.IP.String(), name, err)
+		}
+	}
+
+	return nil
+}
+
+// renumberHosts updates the address of the container in the hosts file kept
+// in the state directory. The files written in the root filesystems keep the
+// old address until the container is restarted.
+func (c *Client) renumberHosts(a Allocation, ip net.IP) error {
+	dir := c.dnsDir(a)
+	if c.opt.DNS.Mode != ResolvState || len(dir) < 1 {
+		return nil
+	}
+	path := filepath.Join(dir, "hosts")
+	hosts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, resolvconf.UpdateHosts(hosts, ip, a.Names()...))
+}
+
+// renumberBuckets moves the allocations to their new address and the last
+// ip of the allocator to the new subnet.
+func renumberBuckets(tx *bolt.Tx, addresses []Renumbering, from, to *net.IPNet) error {
+	ips := tx.Bucket(ipBucket)
+	if ips == nil {
+		return nil
+	}
+	records := tx.Bucket(allocationBucket)
+
+	// Remove all the old keys first since the subnets may overlap.
+	type entry struct {
+		pid, record []byte
+	}
+	entries := make([]entry, len(addresses))
+	for i, r := range addresses {
+		from := dbKey(r.From)
+		e := entry{pid: append([]byte(nil), ips.Get(from)...)}
+		if err := ips.Delete(from); err != nil {
+			return err
+		}
+		if records != nil {
+			if v := records.Get(from); v != nil {
+				var a Allocation
+				if err := json.Unmarshal(v, &a); err != nil {
+					return fmt.Errorf("unmarshaling allocation for %s failed: %v", r.From.String(), err)
+				}
+				a.IP = r.To
+				b, err := json.Marshal(a)
+				if err != nil {
+					return fmt.Errorf("marshaling allocation for %s failed: %v", r.To.String(), err)
+				}
+				e.record = b
+				if err := records.Delete(from); err != nil {
+					return err
+				}
+			}
+		}
+		entries[i] = e
+	}
+
+	for i, r := range addresses {
+		if err := ips.Put(dbKey(r.To), entries[i].pid); err != nil {
+			return err
+		}
+		if entries[i].record != nil {
+			if err := records.Put(dbKey(r.To), entries[i].record); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Keep the position of the allocator in the new subnet.
+	if last := ips.Get([]byte{0}); last != nil {
+		ip, err := renumberIP(net.IP(last), from, to)
+		if err != nil {
+			return ips.Delete([]byte{0})
+		}
+		return ips.Put([]byte{0}, ip)
+	}
+	return nil
+}
+
+// dbKey returns the key of an ip in the buckets, the IPv4 addresses are
+// saved in their 4 bytes form.
+func dbKey(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
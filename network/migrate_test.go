@@ -0,0 +1,186 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/kernel"
+	"github.com/vishvananda/netlink"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMigrate(t *testing.T) {
+	c, k := newTestClient(t)
+	for _, pid := range []int{1234, 1235} {
+		if _, err := testCreate(c, k, pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second container is gone, only its allocation is moved.
+	k.RemoveProcess(1235)
+
+	brOpt := bridge.Opt{Name: defaultBridgeName, IPAddr: defaultBridgeIP}
+
+	m, err := c.Migrate(context.Background(), brOpt, "10.20.0.1/24", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Addresses) != 2 || m.Addresses[0].To.String() != "10.20.0.2" || !m.Addresses[0].Running || m.Addresses[1].Running {
+		t.Fatalf("unexpected migration %+v", m.Addresses)
+	}
+	if addr, _ := kernel.InterfaceAddr(k, defaultBridgeName); addr.String() != defaultBridgeIP {
+		t.Fatalf("expected the dry run to keep the bridge address, got %s", addr.String())
+	}
+
+	if _, err := c.Migrate(context.Background(), brOpt, "10.20.0.1/24", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if addr, _ := kernel.InterfaceAddr(k, defaultBridgeName); addr.String() != "10.20.0.1/24" {
+		t.Fatalf("expected the bridge to be renumbered, got %s", addr.String())
+	}
+
+	// The container has its new address and default route.
+	if err := k.InNamespace(1234, func() error {
+		addr, err := kernel.InterfaceAddr(k, DefaultContainerInterface)
+		if err != nil {
+			return err
+		}
+		if addr.String() != "10.20.0.2/24" {
+			t.Fatalf("expected 10.20.0.2/24 in the container got %s", addr.String())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	routes := k.Routes(1234)
+	if len(routes) != 1 || routes[0].Gw.String() != "10.20.0.1" {
+		t.Fatalf("expected a default route through 10.20.0.1, got %v", routes)
+	}
+
+	// The allocations moved.
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 2 || allocations[0].IP.String() != "10.20.0.2" || allocations[0].PID != 1234 || allocations[1].IP.String() != "10.20.0.3" {
+		t.Fatalf("unexpected allocations %+v", allocations)
+	}
+
+	// The next container gets the next address of the new subnet.
+	ip, err := testCreate(c, k, 1236)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "10.20.0.4" {
+		t.Fatalf("expected 10.20.0.4 got %s", ip.String())
+	}
+}
+
+func TestMigrateConflict(t *testing.T) {
+	c, k := newTestClient(t)
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+
+	la := netlink.NewLinkAttrs()
+	la.Name = "eth0"
+	if err := k.LinkAdd(&netlink.Device{LinkAttrs: la}); err != nil {
+		t.Fatal(err)
+	}
+	link, _ := k.LinkByName("eth0")
+	addr, _ := netlink.ParseAddr("10.20.0.10/16")
+	if err := k.AddrAdd(link, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	brOpt := bridge.Opt{Name: defaultBridgeName, IPAddr: defaultBridgeIP}
+	if _, err := c.Migrate(context.Background(), brOpt, "10.20.0.1/24", true); err == nil {
+		t.Fatal("expected a conflict with eth0")
+	}
+
+	// Renumbering within the old subnet of the bridge is fine.
+	if _, err := c.Migrate(context.Background(), brOpt, "172.19.0.1/24", true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateDatabaseFailure(t *testing.T) {
+	c, k := newTestClient(t)
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bucket at the new address of the container makes moving its
+	// allocation fail.
+	if err := c.openDB(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(allocationBucket).CreateBucket(net.ParseIP("10.20.0.2").To4())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.closeDB()
+
+	brOpt := bridge.Opt{Name: defaultBridgeName, IPAddr: defaultBridgeIP}
+	if _, err := c.Migrate(context.Background(), brOpt, "10.20.0.1/24", false); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Nothing was renumbered.
+	if addr, _ := kernel.InterfaceAddr(k, defaultBridgeName); addr.String() != defaultBridgeIP {
+		t.Fatalf("expected the bridge to keep its address, got %s", addr.String())
+	}
+	if rules := fmt.Sprint(k.NAT[defaultBridgeIP]); rules != "[masquerade]" {
+		t.Fatalf("expected the nat rules of the bridge to be kept, got %s", rules)
+	}
+	routes := k.Routes(1234)
+	if len(routes) != 1 || routes[0].Gw.String() != "172.19.0.1" {
+		t.Fatalf("expected the default route of the container to be kept, got %v", routes)
+	}
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].IP.String() != "172.19.0.2" {
+		t.Fatalf("expected the allocation to be kept, got %+v", allocations)
+	}
+}
+
+func TestRenumberIP(t *testing.T) {
+	_, from, _ := net.ParseCIDR("172.19.0.1/16")
+	from.IP = net.ParseIP("172.19.0.1").To4()
+	_, to, _ := net.ParseCIDR("10.20.0.1/24")
+	to.IP = net.ParseIP("10.20.0.1").To4()
+
+	testcases := map[string]string{
+		"172.19.0.2":   "10.20.0.2",
+		"172.19.0.254": "10.20.0.254",
+		// Out of the new subnet.
+		"172.19.1.2": "",
+		// The broadcast address of the new subnet.
+		"172.19.0.255": "",
+		// The address of the new bridge.
+		"172.19.0.1": "",
+	}
+	for ip, expected := range testcases {
+		got, err := renumberIP(net.ParseIP(ip).To4(), from, to)
+		if len(expected) < 1 {
+			if err == nil {
+				t.Fatalf("expected an error for %s, got %s", ip, got.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != expected {
+			t.Fatalf("expected %s for %s got %s", expected, ip, got.String())
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package network
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnnotationNetem is the container annotation holding the network impairment
+// profile, it overrides Opt.Netem.
+const AnnotationNetem = "netns.netem"
+
+// Netem holds a network impairment profile applied with the netem queueing
+// discipline. Percentages are between 0 and 100.
+type Netem struct {
+	Delay     time.Duration `json:"delay,omitempty"`
+	Jitter    time.Duration `json:"jitter,omitempty"`
+	Loss      float32       `json:"loss,omitempty"`
+	Duplicate float32       `json:"duplicate,omitempty"`
+	Reorder   float32       `json:"reorder,omitempty"`
+	Corrupt   float32       `json:"corrupt,omitempty"`
+	// Rate is the rate in bytes per second.
+	Rate uint64 `json:"rate,omitempty"`
+}
+
+// ParseNetem parses an impairment profile in the form of
+// "delay=100ms,jitter=10ms,loss=1%,duplicate=0.5%,reorder=25%,corrupt=0.1%,rate=1mbit".
+func ParseNetem(s string) (*Netem, error) {
+	n := &Netem{}
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("parsing netem profile %q failed: expected key=value, got %q", s, kv)
+		}
+
+		var err error
+		switch parts[0] {
+		case "delay":
+			n.Delay, err = time.ParseDuration(parts[1])
+		case "jitter":
+			n.Jitter, err = time.ParseDuration(parts[1])
+		case "loss":
+			n.Loss, err = parsePercentage(parts[1])
+		case "duplicate":
+			n.Duplicate, err = parsePercentage(parts[1])
+		case "reorder":
+			n.Reorder, err = parsePercentage(parts[1])
+		case "corrupt":
+			n.Corrupt, err = parsePercentage(parts[1])
+		case "rate":
+			n.Rate, err = parseRate(parts[1])
+		default:
+			err = fmt.Errorf("unknown key %q", parts[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing netem profile %q failed: %v", s, err)
+		}
+	}
+
+	if n.Delay < 0 || n.Jitter < 0 {
+		return nil, fmt.Errorf("parsing netem profile %q failed: delay and jitter cannot be negative", s)
+	}
+	if n.Jitter > 0 && n.Delay == 0 {
+		return nil, fmt.Errorf("parsing netem profile %q failed: jitter requires a delay", s)
+	}
+	if n.Reorder > 0 && n.Delay == 0 {
+		return nil, fmt.Errorf("parsing netem profile %q failed: reorder requires a delay", s)
+	}
+
+	return n, nil
+}
+
+// String returns the profile in the form accepted by ParseNetem.
+func (n Netem) String() string {
+	var parts []string
+	if n.Delay > 0 {
+		parts = append(parts, "delay="+n.Delay.String())
+	}
+	if n.Jitter > 0 {
+		parts = append(parts, "jitter="+n.Jitter.String())
+	}
+	for _, p := range []struct {
+		name  string
+		value float32
+	}{
+		{"loss", n.Loss},
+		{"duplicate", n.Duplicate},
+		{"reorder", n.Reorder},
+		{"corrupt", n.Corrupt},
+	} {
+		if p.value > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%s%%", p.name, strconv.FormatFloat(float64(p.value), 'f', -1, 32)))
+		}
+	}
+	if n.Rate > 0 {
+		parts = append(parts, "rate="+formatRate(n.Rate))
+	}
+	return strings.Join(parts, ",")
+}
+
+func parsePercentage(s string) (float32, error) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 32)
+	if err != nil || f < 0 || f > 100 {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	return float32(f), nil
+}
@@ -0,0 +1,105 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseNetem(t *testing.T) {
+	n, err := ParseNetem("delay=100ms,jitter=10ms,loss=1%,duplicate=0.5%,reorder=25,rate=1mbit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Netem{
+		Delay:     100 * time.Millisecond,
+		Jitter:    10 * time.Millisecond,
+		Loss:      1,
+		Duplicate: 0.5,
+		Reorder:   25,
+		Rate:      125000,
+	}
+	if *n != expected {
+		t.Fatalf("expected %#v got %#v", expected, *n)
+	}
+
+	s := "delay=100ms,jitter=10ms,loss=1%,duplicate=0.5%,reorder=25%,rate=1mbit"
+	if n.String() != s {
+		t.Fatalf("expected %q got %q", s, n.String())
+	}
+}
+
+func TestParseNetemInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"delay",
+		"delay=fast",
+		"loss=101%",
+		"loss=-1%",
+		"jitter=10ms",
+		"reorder=10%",
+		"latency=10ms",
+	} {
+		if _, err := ParseNetem(in); err == nil {
+			t.Fatalf("expected an error parsing %q", in)
+		}
+	}
+}
+
+func TestImpairQdiscs(t *testing.T) {
+	c, k := newTestClient(t)
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+	c.opt.Limits.Ingress = &RateLimit{Rate: 1250000, Burst: minBurst}
+	if _, err := testCreate(c, k, 1235); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		pid      int
+		profile  *Netem
+		expected string
+	}{
+		{1234, &Netem{Delay: 100 * time.Millisecond, Rate: 125000}, "netem 1:0 root, tbf a:0 1:1 rate 125000"},
+		// The rate limit of the previous profile is removed.
+		{1234, &Netem{Delay: 50 * time.Millisecond}, "netem 1:0 root"},
+		{1234, &Netem{Rate: 250000}, "netem 1:0 root, tbf a:0 1:1 rate 250000"},
+		{1234, nil, ""},
+		// The token bucket of a rate limit is replaced by netem and back.
+		{1235, &Netem{Delay: 100 * time.Millisecond}, "netem 1:0 root, tbf a:0 1:1 rate 1250000"},
+		{1235, nil, "tbf 1:0 root rate 1250000"},
+		{1235, &Netem{Delay: 100 * time.Millisecond, Rate: 125000}, "netem 1:0 root, tbf a:0 1:1 rate 125000"},
+		{1235, nil, "tbf 1:0 root rate 1250000"},
+	} {
+		if err := c.Impair(context.Background(), strconv.Itoa(tc.pid), tc.profile); err != nil {
+			t.Fatal(err)
+		}
+		veth, err := k.LinkByName(c.vethName(tc.pid))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		qdiscs, err := k.QdiscList(veth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, q := range qdiscs {
+			s := fmt.Sprintf("%s %s %s", q.Type(), netlink.HandleStr(q.Attrs().Handle), netlink.HandleStr(q.Attrs().Parent))
+			if tbf, ok := q.(*netlink.Tbf); ok {
+				s += fmt.Sprintf(" rate %d", tbf.Rate)
+			}
+			got = append(got, s)
+		}
+		if strings.Join(got, ", ") != tc.expected {
+			t.Fatalf("expected qdiscs %q for %+v got %q", tc.expected, tc.profile, strings.Join(got, ", "))
+		}
+	}
+}
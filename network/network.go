@@ -1,12 +1,17 @@
 package network
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/genuinetools/netns/kernel"
+	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 	bolt "go.etcd.io/bbolt"
@@ -15,6 +20,12 @@ import (
 const (
 	// dbFile is the file the bolt database is stored in.
 	dbFile = "bolt.db"
+	// networksDir is the directory in the state directory holding the
+	// databases of the named networks.
+	networksDir = "networks"
+	// dbLockInterval is how long we wait for the lock of the database
+	// before checking whether the operation was canceled.
+	dbLockInterval = 100 * time.Millisecond
 
 	// DefaultContainerInterface is the default container interface.
 	DefaultContainerInterface = "eth0"
@@ -25,6 +36,9 @@ const (
 var (
 	// ipBucket is the bolt database bucket for ip key value store.
 	ipBucket = []byte("ipallocator")
+	// allocationBucket is the bolt database bucket holding the allocation
+	// records keyed by ip.
+	allocationBucket = []byte("allocations")
 
 	// ErrBridgeNameEmpty holds the error for when the bridge name is empty.
 	ErrBridgeNameEmpty = errors.New("bridge name cannot be empty")
@@ -34,6 +48,9 @@ var (
 	// ErrDatabaseDoesNotExist holds the error for when the database does not
 	// exit.
 	ErrDatabaseDoesNotExist = errors.New("database does not exist")
+	// ErrDatabaseLocked holds the error for when the database is locked by
+	// another process.
+	ErrDatabaseLocked = errors.New("database is locked by another process")
 )
 
 // Opt holds the options for holding networks state, etc.
@@ -42,15 +59,120 @@ type Opt struct {
 	ContainerInterface string
 	PortPrefix         string
 	BridgeName         string
+
+	// Network is the name of the network. The allocations of a named network
+	// are kept in their own database under the state directory so several
+	// networks can share it.
+	Network string
+	// DefaultNetwork is set for the default network, its allocations stay
+	// in the database at the root of the state directory where they were
+	// kept before the networks were named.
+	DefaultNetwork bool
+
+	// Limits are the default bandwidth limits for the containers, they can
+	// be overridden by the container annotations.
+	Limits Limits
+	// Netem is the default network impairment profile for the containers,
+	// it can be overridden by the container annotations.
+	Netem *Netem
+
+	// DNS holds the name resolution options for the containers.
+	DNS DNSOpt
+
+	// Link holds the default settings of the veth pairs, they can be
+	// overridden by the container annotations.
+	Link LinkOpt
+
+	// Kernel is the kernel the networks are set up in, kernel.Host if nil.
+	Kernel kernel.Kernel
 }
 
-// Network holds information about a network.
+// Network holds information about a network. List does not open the
+// namespace of the container, FD is set by the callers that need it.
 type Network struct {
-	VethPair *netlink.Veth
-	IP       net.IP
-	PID      int
-	Status   string
-	FD       netns.NsHandle
+	VethPair    *netlink.Veth  `json:"-"`
+	IP          net.IP         `json:"ip"`
+	PID         int            `json:"pid"`
+	ContainerID string         `json:"container_id"`
+	Hostname    string         `json:"hostname"`
+	Bridge      string         `json:"bridge"`
+	Status      string         `json:"status"`
+	FD          netns.NsHandle `json:"ns_fd"`
+	Limits      Limits         `json:"limits"`
+	Netem       *Netem         `json:"netem"`
+	Stats       Stats          `json:"stats"`
+}
+
+// Veth returns the name of the local side of the veth pair.
+func (n Network) Veth() string {
+	if n.VethPair == nil {
+		return ""
+	}
+	return n.VethPair.Name
+}
+
+// MarshalJSON returns the JSON encoding of the network with the name of the
+// local side of the veth pair instead of the whole link.
+func (n Network) MarshalJSON() ([]byte, error) {
+	type network Network
+
+	return json.Marshal(struct {
+		network
+		Veth string `json:"veth"`
+	}{
+		network: network(n),
+		Veth:    n.Veth(),
+	})
+}
+
+// UnmarshalJSON decodes a network encoded by MarshalJSON.
+func (n *Network) UnmarshalJSON(b []byte) error {
+	type network Network
+
+	v := struct {
+		*network
+		Veth string `json:"veth"`
+	}{
+		network: (*network)(n),
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	if len(v.Veth) > 0 {
+		la := netlink.NewLinkAttrs()
+		la.Name = v.Veth
+		n.VethPair = &netlink.Veth{LinkAttrs: la}
+	}
+
+	return nil
+}
+
+// Allocation holds the information saved with an allocated ip address.
+type Allocation struct {
+	IP          net.IP `json:"ip"`
+	ContainerID string `json:"container_id,omitempty"`
+	Hostname    string `json:"hostname,omitempty"`
+	PID         int    `json:"pid"`
+	Limits      Limits `json:"limits"`
+	Netem       *Netem `json:"netem,omitempty"`
+
+	// Interface is the name of the interface in the container, the
+	// interface of the client if empty.
+	Interface string `json:"interface,omitempty"`
+	// DNSDir is the directory in the state directory holding the name
+	// resolution files of the container, if any.
+	DNSDir string `json:"dns_dir,omitempty"`
+	// VethIndex and IfbIndex are the indexes of the local side of the veth
+	// pair and of the ifb device when the network was created. The links
+	// are named after the pid, the indexes tell them from the links of a
+	// container that got the pid after this one exited.
+	VethIndex int `json:"veth_index,omitempty"`
+	IfbIndex  int `json:"ifb_index,omitempty"`
+
+	// Process is the process of the container when the network was
+	// created, to notice when its pid is reused.
+	Process *kernel.Process `json:"process,omitempty"`
 }
 
 // Client is the object used for interacting with networks.
@@ -58,9 +180,12 @@ type Client struct {
 	dbPath string
 	db     *bolt.DB
 	opt    Opt
+	kernel kernel.Kernel
 
 	bridge *net.Interface
 	ipNet  *net.IPNet
+
+	log *logrus.Entry
 }
 
 // New creates a new Client for interacting with networks.
@@ -80,37 +205,86 @@ func New(opt Opt) (*Client, error) {
 	if len(opt.PortPrefix) < 1 {
 		opt.PortPrefix = DefaultPortPrefix
 	}
+	if len(opt.DNS.Mode) < 1 {
+		opt.DNS.Mode = ResolvNone
+	}
+	if opt.Kernel == nil {
+		opt.Kernel = kernel.Host
+	}
+	if opt.DNS.Mode != ResolvNone && opt.DNS.Mode != ResolvRootfs && opt.DNS.Mode != ResolvState {
+		return nil, fmt.Errorf("unknown resolv mode %q, must be one of %s, %s or %s", opt.DNS.Mode, ResolvNone, ResolvRootfs, ResolvState)
+	}
 
 	// Create the state directory in case it does not exist.
 	if err := os.MkdirAll(opt.StateDir, 0666); err != nil {
 		return nil, fmt.Errorf("creating state directory %s failed: %v", opt.StateDir, err)
 	}
 
+	dbDir := opt.StateDir
+	if len(opt.Network) > 0 && !opt.DefaultNetwork {
+		dbDir = filepath.Join(opt.StateDir, networksDir, opt.Network)
+		if err := os.MkdirAll(dbDir, 0755); err != nil {
+			return nil, fmt.Errorf("creating state directory for network %s failed: %v", opt.Network, err)
+		}
+	}
+
 	return &Client{
-		dbPath: filepath.Join(opt.StateDir, dbFile),
+		dbPath: filepath.Join(dbDir, dbFile),
 		opt:    opt,
+		kernel: opt.Kernel,
+		log:    logrus.NewEntry(logrus.StandardLogger()),
 	}, nil
 }
 
-func (c *Client) openDB(readonly bool) (err error) {
+func (c *Client) openDB(ctx context.Context, readonly bool) (err error) {
 	if c.db != nil {
 		// The database is already opened.
 		return nil
 	}
 
-	// This will block until other operations on it are closed which is fine
-	// for our use case of assigning one IP and being done.
-	c.db, err = bolt.Open(c.dbPath, 0666, &bolt.Options{
-		ReadOnly: readonly,
-	})
-	if err != nil {
-		if os.IsNotExist(err) {
+	// Opening the database read-only would create an empty file it cannot
+	// initialize, so check that it exists first.
+	if readonly {
+		if fi, err := os.Stat(c.dbPath); os.IsNotExist(err) || (err == nil && fi.Size() == 0) {
 			return ErrDatabaseDoesNotExist
 		}
+	}
+
+	// Wait for the other operations on it to close it until the context is
+	// done. Bolt only takes a timeout for the lock so we retry with a short
+	// one to notice the cancellation.
+	for {
+		c.db, err = bolt.Open(c.dbPath, 0666, &bolt.Options{
+			ReadOnly: readonly,
+			Timeout:  dbLockInterval,
+		})
+		if err == nil {
+			return c.openedDB(readonly)
+		}
+		if err != bolt.ErrTimeout {
+			if os.IsNotExist(err) {
+				return ErrDatabaseDoesNotExist
+			}
+			return fmt.Errorf("opening database at %s failed: %v", c.dbPath, err)
+		}
 
-		return fmt.Errorf("opening database at %s failed: %v", c.dbPath, err)
+		if ctx.Err() != nil {
+			return &DatabaseLockedError{Path: c.dbPath, Err: ctx.Err()}
+		}
 	}
+}
 
+// openedDB checks the schema of the database just opened, or upgrades it when
+// it is opened for writing. The database is closed if it cannot be used.
+func (c *Client) openedDB(readonly bool) error {
+	check := c.upgradeSchema
+	if readonly {
+		check = c.checkSchema
+	}
+	if err := check(); err != nil {
+		c.closeDB()
+		return err
+	}
 	return nil
 }
 
@@ -1,6 +1,19 @@
 package network
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	bolt "go.etcd.io/bbolt"
+)
 
 const (
 	defaultBridgeName = "netns0"
@@ -49,3 +62,126 @@ func TestNewNetworkDefaults(t *testing.T) {
 		t.Fatalf("expected port prefix to be %s got %s", DefaultPortPrefix, c.opt.PortPrefix)
 	}
 }
+
+func TestNewNetworkDBPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		opt      Opt
+		expected string
+	}{
+		{Opt{}, filepath.Join(dir, "bolt.db")},
+		{Opt{Network: "frontend", DefaultNetwork: true}, filepath.Join(dir, "bolt.db")},
+		{Opt{Network: "backend"}, filepath.Join(dir, "networks", "backend", "bolt.db")},
+	} {
+		tc.opt.BridgeName = defaultBridgeName
+		tc.opt.StateDir = dir
+		c, err := New(tc.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.dbPath != tc.expected {
+			t.Fatalf("expected the database of network %q at %s got %s", tc.opt.Network, tc.expected, c.dbPath)
+		}
+	}
+}
+
+func TestNetworkMarshalJSON(t *testing.T) {
+	n := Network{
+		VethPair: &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "netnsv0-1234"}},
+		IP:       net.ParseIP("172.19.0.2"),
+		PID:      1234,
+		Bridge:   defaultBridgeName,
+		Status:   "running",
+		Stats:    Stats{RxBytes: 10},
+	}
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]interface{}{
+		"ip":     "172.19.0.2",
+		"pid":    float64(1234),
+		"bridge": defaultBridgeName,
+		"status": "running",
+		"veth":   "netnsv0-1234",
+	} {
+		if m[key] != expected {
+			t.Fatalf("expected %s to be %v got %v", key, expected, m[key])
+		}
+	}
+	if _, ok := m["VethPair"]; ok {
+		t.Fatal("expected the veth pair link to be omitted")
+	}
+	stats, ok := m["stats"].(map[string]interface{})
+	if !ok || stats["rx_bytes"] != float64(10) {
+		t.Fatalf("expected stats.rx_bytes to be 10 got %v", m["stats"])
+	}
+}
+
+func TestNetworkUnmarshalJSON(t *testing.T) {
+	b := []byte(`{"ip":"172.19.0.2","pid":1234,"container_id":"web","status":"running","veth":"netnsv0-1234","stats":{"tx_bytes":20}}`)
+
+	var n Network
+	if err := json.Unmarshal(b, &n); err != nil {
+		t.Fatal(err)
+	}
+
+	if !n.IP.Equal(net.ParseIP("172.19.0.2")) || n.PID != 1234 || n.ContainerID != "web" || n.Status != "running" {
+		t.Fatalf("unexpected network %#v", n)
+	}
+	if n.Veth() != "netnsv0-1234" {
+		t.Fatalf("expected veth netnsv0-1234 got %q", n.Veth())
+	}
+	if n.Stats.TxBytes != 20 {
+		t.Fatalf("expected 20 tx bytes got %d", n.Stats.TxBytes)
+	}
+}
+
+func TestOpenDBLockedTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(Opt{
+		BridgeName: defaultBridgeName,
+		StateDir:   dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the lock of the database like a hung hook would.
+	db, err := bolt.Open(c.dbPath, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err = c.openDB(ctx, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v got %v", context.DeadlineExceeded, err)
+	}
+	if !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("expected %v got %v", ErrDatabaseLocked, err)
+	}
+	if c.db != nil {
+		t.Fatal("expected the database to not be opened")
+	}
+}
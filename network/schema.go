@@ -0,0 +1,119 @@
+package network
+
+import (
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// metaBucket is the bolt database bucket holding the information about
+	// the database itself.
+	metaBucket = []byte("meta")
+	// schemaVersionKey is the key of the schema version in the meta bucket.
+	schemaVersionKey = []byte("schema_version")
+)
+
+// migration upgrades the database from the previous schema version to
+// version.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *bolt.Tx) error
+}
+
+// migrations are the upgrades of the database, in order. The databases
+// created before the meta bucket are version 0. Add a migration with the
+// next version to change the layout of the database, they are run when the
+// database is opened for writing.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create the ip allocator and the allocation records buckets",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{ipBucket, allocationBucket} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return fmt.Errorf("creating bucket %s failed: %v", name, err)
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// schemaVersion is the version of the schema of the databases this version
+// reads and writes.
+var schemaVersion = migrations[len(migrations)-1].version
+
+// getSchemaVersion returns the schema version saved in the meta bucket, 0 if
+// there is none.
+func getSchemaVersion(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket(metaBucket)
+	if b == nil {
+		return 0, nil
+	}
+	v := b.Get(schemaVersionKey)
+	if v == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, fmt.Errorf("parsing schema version %q failed: %v", v, err)
+	}
+	return version, nil
+}
+
+// checkSchema returns a SchemaVersionError if the database was written by a
+// newer version with a schema this one does not know.
+func (c *Client) checkSchema() error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		version, err := getSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version > schemaVersion {
+			return &SchemaVersionError{Path: c.dbPath, Version: version, Supported: schemaVersion}
+		}
+		return nil
+	})
+}
+
+// upgradeSchema runs the migrations the database has not had yet and saves
+// the new schema version, all in one transaction so an interrupted upgrade
+// leaves the database as it was.
+func (c *Client) upgradeSchema() error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		version, err := getSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version > schemaVersion {
+			return &SchemaVersionError{Path: c.dbPath, Version: version, Supported: schemaVersion}
+		}
+		if version == schemaVersion {
+			return nil
+		}
+
+		for _, m := range migrations {
+			if m.version <= version {
+				continue
+			}
+			c.log.Debugf("upgrading database at %s to schema version %d: %s", c.dbPath, m.version, m.description)
+			if err := m.up(tx); err != nil {
+				return fmt.Errorf("upgrading database at %s to schema version %d failed: %v", c.dbPath, m.version, err)
+			}
+		}
+
+		return putSchemaVersion(tx, schemaVersion)
+	})
+}
+
+// putSchemaVersion saves the schema version in the meta bucket.
+func putSchemaVersion(tx *bolt.Tx, version int) error {
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return fmt.Errorf("creating bucket %s failed: %v", metaBucket, err)
+	}
+	return b.Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+}
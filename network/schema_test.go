@@ -0,0 +1,125 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeTestDB writes the buckets with their entries in the database of the
+// client as an older or newer version would.
+func writeTestDB(t *testing.T, c *Client, buckets map[string]map[string]string) {
+	db, err := bolt.Open(c.DatabasePath(), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for name, entries := range buckets {
+			b, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for k, v := range entries {
+				if err := b.Put([]byte(k), []byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSchemaVersion(t *testing.T, c *Client) int {
+	db, err := bolt.Open(c.DatabasePath(), 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var version int
+	if err := db.View(func(tx *bolt.Tx) (err error) {
+		version, err = getSchemaVersion(tx)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestUpgradeSchema(t *testing.T) {
+	c, k := newTestClient(t)
+
+	// The first versions only had the ip allocator bucket.
+	writeTestDB(t, c, map[string]map[string]string{
+		string(ipBucket): {
+			string(net.ParseIP("172.19.0.2").To4()): "1234",
+		},
+	})
+
+	// Reading does not upgrade the database.
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].PID != 1234 {
+		t.Fatalf("unexpected allocations %+v", allocations)
+	}
+	if version := testSchemaVersion(t, c); version != 0 {
+		t.Fatalf("expected schema version 0 got %d", version)
+	}
+
+	ip, err := testCreate(c, k, 1235)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "172.19.0.3" {
+		t.Fatalf("expected 172.19.0.3 got %s", ip.String())
+	}
+	if version := testSchemaVersion(t, c); version != schemaVersion {
+		t.Fatalf("expected schema version %d got %d", schemaVersion, version)
+	}
+}
+
+func TestListWithoutBuckets(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	// A database written before any allocation has no ip bucket.
+	writeTestDB(t, c, map[string]map[string]string{})
+
+	networks, err := c.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 0 {
+		t.Fatalf("expected no networks got %+v", networks)
+	}
+}
+
+func TestUnknownSchema(t *testing.T) {
+	c, k := newTestClient(t)
+	writeTestDB(t, c, map[string]map[string]string{
+		string(metaBucket): {string(schemaVersionKey): "99"},
+		string(ipBucket):   {},
+	})
+
+	if _, err := c.List(context.Background()); !errors.Is(err, ErrUnknownSchema) {
+		t.Fatalf("expected ErrUnknownSchema from list got %v", err)
+	}
+	if _, err := testCreate(c, k, 1234); !errors.Is(err, ErrUnknownSchema) {
+		t.Fatalf("expected ErrUnknownSchema from create got %v", err)
+	}
+	if version := testSchemaVersion(t, c); version != 99 {
+		t.Fatalf("expected the database to keep schema version 99 got %d", version)
+	}
+
+	if _, err := c.Import(context.Background(), &State{SchemaVersion: 99}, true); !errors.Is(err, ErrUnknownSchema) {
+		t.Fatalf("expected ErrUnknownSchema from import got %v", err)
+	}
+}
@@ -0,0 +1,317 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+const (
+	// AnnotationNetworks is the container annotation holding the names of
+	// the networks to attach the container to, separated by commas. It
+	// overrides the default networks of the set.
+	AnnotationNetworks = "netns.networks"
+	// AnnotationDefaultRoute is the container annotation holding the name of
+	// the network supplying the default route of the container.
+	AnnotationDefaultRoute = "netns.default-route"
+)
+
+// Member is a named network of a set.
+type Member struct {
+	Name   string
+	Client *Client
+	Bridge bridge.Opt
+	// Interface is the name of the interface in the containers. It is
+	// eth<n> for the n-th network of a container if empty.
+	Interface string
+}
+
+// Set is a set of named networks a container can be attached to together.
+// Each network gives the container its own veth pair, interface, address
+// and route to its subnet, one of them supplies the default route.
+type Set struct {
+	members map[string]Member
+	names   []string
+
+	defaults     []string
+	defaultRoute string
+}
+
+// NewSet returns a set of the networks. The containers are attached to the
+// networks named by defaults unless their annotations say otherwise, the
+// default route goes through defaultRoute or the first network if it is
+// empty.
+func NewSet(defaults []string, defaultRoute string, members ...Member) (*Set, error) {
+	s := &Set{
+		members:      map[string]Member{},
+		defaults:     defaults,
+		defaultRoute: defaultRoute,
+	}
+
+	bridges := map[string]string{}
+	prefixes := map[string]string{}
+	for _, m := range members {
+		if len(m.Name) < 1 {
+			return nil, errors.New("network name cannot be empty")
+		}
+		if _, ok := s.members[m.Name]; ok {
+			return nil, fmt.Errorf("network %s is defined twice", m.Name)
+		}
+		if other, ok := bridges[m.Client.opt.BridgeName]; ok {
+			return nil, fmt.Errorf("networks %s and %s use the same bridge %s", other, m.Name, m.Client.opt.BridgeName)
+		}
+		if other, ok := prefixes[m.Client.opt.PortPrefix]; ok {
+			return nil, fmt.Errorf("networks %s and %s use the same port prefix %s", other, m.Name, m.Client.opt.PortPrefix)
+		}
+		bridges[m.Client.opt.BridgeName] = m.Name
+		prefixes[m.Client.opt.PortPrefix] = m.Name
+
+		s.members[m.Name] = m
+		s.names = append(s.names, m.Name)
+	}
+	sort.Strings(s.names)
+
+	if _, _, err := s.attachments(nil); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Names returns the names of the networks, sorted.
+func (s *Set) Names() []string {
+	return s.names
+}
+
+// attachments returns the networks to attach a container with the
+// annotations to, in order, and the index of the one supplying the default
+// route.
+func (s *Set) attachments(annotations map[string]string) ([]Member, int, error) {
+	names := s.defaults
+	if v, ok := annotations[AnnotationNetworks]; ok {
+		names = splitNames(v)
+	}
+	defaultRoute := s.defaultRoute
+	if v, ok := annotations[AnnotationDefaultRoute]; ok {
+		defaultRoute = strings.TrimSpace(v)
+	}
+	if len(names) < 1 {
+		return nil, 0, errors.New("no network to attach the container to")
+	}
+
+	members := make([]Member, 0, len(names))
+	route := -1
+	interfaces := map[string]bool{}
+	for i, name := range names {
+		m, ok := s.members[name]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown network %s, must be one of %s", name, strings.Join(s.names, ", "))
+		}
+		if len(m.Interface) < 1 {
+			m.Interface = fmt.Sprintf("eth%d", i)
+		}
+		if interfaces[m.Interface] {
+			return nil, 0, fmt.Errorf("network %s uses interface %s of another network", name, m.Interface)
+		}
+		interfaces[m.Interface] = true
+
+		if name == defaultRoute {
+			route = i
+		}
+		members = append(members, m)
+	}
+
+	if len(defaultRoute) < 1 {
+		route = 0
+	}
+	if route < 0 {
+		return nil, 0, fmt.Errorf("network %s supplying the default route is not one of the networks %s", defaultRoute, strings.Join(names, ", "))
+	}
+
+	return members, route, nil
+}
+
+// splitNames splits a list of names separated by commas.
+func splitNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Create attaches the container to its networks and returns the ip of the
+// network supplying the default route, which is the one the static ip is
+// for. If attaching it to one of them fails it is detached from the others.
+func (s *Set) Create(ctx context.Context, hook configs.HookState, staticip string) (net.IP, error) {
+	members, route, err := s.attachments(hook.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ip       net.IP
+		attached []Member
+		ips      []net.IP
+	)
+	for i, m := range members {
+		var static string
+		if i == route {
+			static = staticip
+		}
+
+		nsip, err := m.Client.create(ctx, hook, m.Bridge, static, m.Interface, i == route)
+		if err != nil {
+			for j := len(attached) - 1; j >= 0; j-- {
+				if derr := attached[j].Client.Delete(ctx, ips[j].String()); derr != nil {
+					m.Client.log.Warnf("detaching pid %d from network %s failed: %v", hook.Pid, attached[j].Name, derr)
+				}
+			}
+			return nil, fmt.Errorf("attaching to network %s failed: %w", m.Name, err)
+		}
+		attached = append(attached, m)
+		ips = append(ips, nsip)
+
+		if i == route {
+			ip = nsip
+		}
+	}
+
+	return ip, nil
+}
+
+// Delete releases the networks of the container identified by its container
+// ID, PID or IP address in all the networks of the set.
+func (s *Set) Delete(ctx context.Context, target string) error {
+	found := false
+	for _, name := range s.names {
+		err := s.members[name].Client.Delete(ctx, target)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("deleting network %s failed: %w", name, err)
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("%w for %s", ErrNotFound, target)
+	}
+	return nil
+}
+
+// List returns the networks of the containers in all the networks of the
+// set.
+func (s *Set) List(ctx context.Context) ([]Network, error) {
+	all := []Network{}
+	created := false
+	for _, name := range s.names {
+		networks, err := s.members[name].Client.list(ctx)
+		if err == ErrDatabaseDoesNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		created = true
+		all = append(all, networks...)
+	}
+
+	if !created {
+		return nil, errNoNetworks
+	}
+	return all, nil
+}
+
+// Inspect returns the network of the container identified by its container
+// ID, PID or IP address in the first network of the set it is attached to.
+func (s *Set) Inspect(ctx context.Context, target string) (*Inspection, error) {
+	for _, name := range s.names {
+		i, err := s.members[name].Client.Inspect(ctx, target)
+		if errors.Is(err, ErrNotFound) || err == ErrDatabaseDoesNotExist {
+			continue
+		}
+		return i, err
+	}
+
+	return nil, fmt.Errorf("%w for %s", ErrNotFound, target)
+}
+
+// Exec starts the command in the network namespace of the container
+// identified by its container ID, PID or IP address, found in the first
+// network of the set it is attached to.
+func (s *Set) Exec(ctx context.Context, target string, cmd *exec.Cmd) error {
+	for _, name := range s.names {
+		err := s.members[name].Client.Exec(ctx, target, cmd)
+		if errors.Is(err, ErrNotFound) || err == ErrDatabaseDoesNotExist {
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("%w for %s", ErrNotFound, target)
+}
+
+// Impair sets the network impairment profile of the container identified
+// by its container ID, PID or IP address in all the networks of the set it
+// is attached to. A nil profile clears the impairment.
+func (s *Set) Impair(ctx context.Context, target string, profile *Netem) error {
+	found := false
+	for _, name := range s.names {
+		err := s.members[name].Client.Impair(ctx, target, profile)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("impairing network %s failed: %w", name, err)
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("%w for %s", ErrNotFound, target)
+	}
+	return nil
+}
+
+// Migrate renumbers the bridge of the first of the default networks of the
+// set and its containers to the subnet of ipAddr.
+func (s *Set) Migrate(ctx context.Context, ipAddr string, dryRun bool) (*Migration, error) {
+	m := s.members[s.defaults[0]]
+	return m.Client.Migrate(ctx, m.Bridge, ipAddr, dryRun)
+}
+
+// GC releases the networks of the containers whose process is gone in all
+// the networks of the set.
+func (s *Set) GC(ctx context.Context) ([]Allocation, error) {
+	reclaimed := []Allocation{}
+	for _, name := range s.names {
+		r, err := s.members[name].Client.GC(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("collecting network %s failed: %w", name, err)
+		}
+		reclaimed = append(reclaimed, r...)
+	}
+
+	return reclaimed, nil
+}
+
+// UpdatePoolMetrics sets the pool metrics of all the networks of the set.
+func (s *Set) UpdatePoolMetrics(ctx context.Context) error {
+	for _, name := range s.names {
+		if err := s.members[name].Client.UpdatePoolMetrics(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/genuinetools/netns/bridge"
+	"github.com/genuinetools/netns/kernel"
+	"github.com/opencontainers/runc/libcontainer/configs"
+)
+
+// newTestSet returns a set of a frontend and a backend network sharing a
+// fake kernel and a temporary state directory.
+func newTestSet(t *testing.T, defaultRoute string) (*Set, *kernel.Fake) {
+	dir, err := ioutil.TempDir("", "netns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	k := kernel.NewFake()
+	var members []Member
+	for i, n := range []struct{ name, ip string }{
+		{"frontend", "172.20.0.1/16"},
+		{"backend", "172.21.0.1/16"},
+	} {
+		c, err := New(Opt{
+			Network:    n.name,
+			BridgeName: n.name,
+			PortPrefix: fmt.Sprintf("netnsv%d", i),
+			StateDir:   dir,
+			Kernel:     k,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, Member{
+			Name:   n.name,
+			Client: c,
+			Bridge: bridge.Opt{Name: n.name, IPAddr: n.ip},
+		})
+	}
+
+	s, err := NewSet([]string{"frontend", "backend"}, defaultRoute, members...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, k
+}
+
+func TestSetCreate(t *testing.T) {
+	s, k := newTestSet(t, "backend")
+	k.AddProcess(1234)
+
+	ip, err := s.Create(context.Background(), configs.HookState{ID: "web", Pid: 1234}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "172.21.0.2" {
+		t.Fatalf("expected the ip of the backend network, got %s", ip.String())
+	}
+
+	// The container gets an interface per network in order and only the
+	// backend supplies the default route.
+	if links := k.Links(1234); !reflect.DeepEqual(links, []string{"eth0", "eth1"}) {
+		t.Fatalf("expected eth0 and eth1 in the container, got %v", links)
+	}
+	if links := k.Links(0); !reflect.DeepEqual(links, []string{"backend", "frontend", "netnsv0-1234", "netnsv1-1234"}) {
+		t.Fatalf("expected the bridges and a veth per network on the host, got %v", links)
+	}
+	routes := k.Routes(1234)
+	if len(routes) != 1 || routes[0].Gw.String() != "172.21.0.1" {
+		t.Fatalf("expected a single default route through the backend, got %v", routes)
+	}
+
+	i, err := s.Inspect(context.Background(), "172.20.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Allocation.Interface != "eth0" {
+		t.Fatalf("expected the frontend on eth0, got %s", i.Allocation.Interface)
+	}
+
+	networks, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 2 {
+		t.Fatalf("expected 2 networks got %d", len(networks))
+	}
+
+	// The networks are torn down together.
+	if err := s.Delete(context.Background(), "web"); err != nil {
+		t.Fatal(err)
+	}
+	if links := k.Links(0); !reflect.DeepEqual(links, []string{"backend", "frontend"}) {
+		t.Fatalf("expected only the bridges on the host, got %v", links)
+	}
+	if err := s.Delete(context.Background(), "web"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+}
+
+func TestSetCreateAnnotations(t *testing.T) {
+	s, k := newTestSet(t, "")
+	k.AddProcess(1234)
+
+	ip, err := s.Create(context.Background(), configs.HookState{
+		Pid: 1234,
+		Annotations: map[string]string{
+			AnnotationNetworks: "backend",
+		},
+	}, "172.21.0.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "172.21.0.10" {
+		t.Fatalf("expected the static ip on the backend network, got %s", ip.String())
+	}
+	if links := k.Links(1234); !reflect.DeepEqual(links, []string{"eth0"}) {
+		t.Fatalf("expected only eth0 in the container, got %v", links)
+	}
+
+	k.AddProcess(1235)
+	if _, err := s.Create(context.Background(), configs.HookState{
+		Pid: 1235,
+		Annotations: map[string]string{
+			AnnotationDefaultRoute: "database",
+		},
+	}, ""); err == nil {
+		t.Fatal("expected an error for a default route through an unknown network")
+	}
+}
+
+func TestSetCreateRollback(t *testing.T) {
+	s, k := newTestSet(t, "backend")
+	k.AddProcess(1234)
+
+	// Only the backend adds a route, so the frontend is attached first.
+	k.Fail("RouteAdd", errors.New("network is unreachable"))
+	if _, err := s.Create(context.Background(), configs.HookState{Pid: 1234}, ""); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if links := k.Links(0); !reflect.DeepEqual(links, []string{"backend", "frontend"}) {
+		t.Fatalf("expected only the bridges on the host, got %v", links)
+	}
+	if links := k.Links(1234); len(links) != 0 {
+		t.Fatalf("expected no links in the container, got %v", links)
+	}
+	if _, err := s.Inspect(context.Background(), "1234"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+}
+
+func TestSetImpairExec(t *testing.T) {
+	s, k := newTestSet(t, "backend")
+	k.AddProcess(1234)
+
+	if _, err := s.Create(context.Background(), configs.HookState{ID: "web", Pid: 1234}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The profile is set on the veths of both networks.
+	profile := &Netem{Delay: 100 * time.Millisecond}
+	if err := s.Impair(context.Background(), "web", profile); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"172.20.0.2", "172.21.0.2"} {
+		i, err := s.Inspect(context.Background(), ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i.Allocation.Netem == nil || *i.Allocation.Netem != *profile {
+			t.Fatalf("expected the profile on %s, got %v", ip, i.Allocation.Netem)
+		}
+	}
+	if err := s.Impair(context.Background(), "db", profile); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+
+	// The container is found in the backend network by its ip.
+	cmd := exec.Command("true")
+	if err := s.Exec(context.Background(), "172.21.0.2", cmd); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Exec(context.Background(), "db", exec.Command("true")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+}
+
+func TestNewSet(t *testing.T) {
+	c, _ := newTestClient(t)
+	m := Member{Name: "frontend", Client: c}
+
+	if _, err := NewSet([]string{"frontend"}, "", m, m); err == nil {
+		t.Fatal("expected an error for a network defined twice")
+	}
+	if _, err := NewSet([]string{"backend"}, "", m); err == nil {
+		t.Fatal("expected an error for an unknown default network")
+	}
+	if _, err := NewSet([]string{"frontend"}, "backend", m); err == nil {
+		t.Fatal("expected an error for an unknown default route")
+	}
+}
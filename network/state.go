@@ -0,0 +1,526 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/genuinetools/netns/kernel"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// State is a dump of the database of a network.
+type State struct {
+	Bridge        string `json:"bridge"`
+	Network       string `json:"network,omitempty"`
+	SchemaVersion int    `json:"schema_version"`
+	// LastIP is the last ip handed out by the allocator.
+	LastIP      net.IP       `json:"last_ip,omitempty"`
+	Allocations []Allocation `json:"allocations"`
+	// Buckets holds the raw content of every bucket by name.
+	Buckets map[string][]Entry `json:"buckets"`
+}
+
+// Entry is a key and its value in a bucket.
+type Entry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Export returns the content of the database. The entries that cannot be
+// decoded are left out of the allocations but kept in the buckets.
+func (c *Client) Export(ctx context.Context) (*State, error) {
+	s := &State{
+		Bridge:      c.opt.BridgeName,
+		Network:     c.opt.Network,
+		Allocations: []Allocation{},
+		Buckets:     map[string][]Entry{},
+	}
+
+	// Return early if the database has not been created yet.
+	if _, err := os.Stat(c.dbPath); os.IsNotExist(err) {
+		return s, nil
+	}
+
+	// Open the database.
+	if err := c.openDB(ctx, true); err != nil {
+		return nil, err
+	}
+	defer c.closeDB()
+
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		var err error
+		if s.SchemaVersion, err = getSchemaVersion(tx); err != nil {
+			return err
+		}
+
+		if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			entries := []Entry{}
+			if err := b.ForEach(func(k, v []byte) error {
+				entries = append(entries, Entry{
+					Key:   append([]byte(nil), k...),
+					Value: append([]byte(nil), v...),
+				})
+				return nil
+			}); err != nil {
+				return err
+			}
+			s.Buckets[string(name)] = entries
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		b := tx.Bucket(ipBucket)
+		if b == nil {
+			return nil
+		}
+		if last := b.Get([]byte{0}); len(last) == net.IPv4len || len(last) == net.IPv6len {
+			s.LastIP = net.ParseIP(net.IP(last).String())
+		}
+		return b.ForEach(func(k, v []byte) error {
+			// skip last ip and the keys that are not ips
+			if len(k) != net.IPv4len && len(k) != net.IPv6len {
+				return nil
+			}
+
+			a, err := getAllocation(tx, k, v)
+			if err != nil {
+				logrus.Warnf("exporting entry %x failed: %v", k, err)
+				return nil
+			}
+			s.Allocations = append(s.Allocations, a)
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("exporting database at %s failed: %v", c.dbPath, err)
+	}
+
+	return s, nil
+}
+
+// Import replaces the content of the database with s. The allocations are
+// validated against the bridge and the kernel first: the import fails if an
+// ip is not usable in the subnet of the bridge or is given twice, and the
+// allocations of the containers whose namespace or veth is gone are skipped
+// and returned. A database with allocations is only replaced if force is
+// true. The dump is saved with the current schema, it must not come from a
+// newer version.
+func (c *Client) Import(ctx context.Context, s *State, force bool) ([]Inconsistency, error) {
+	if s.SchemaVersion > schemaVersion {
+		return nil, fmt.Errorf("%w: the dump has schema version %d but only up to %d is supported", ErrUnknownSchema, s.SchemaVersion, schemaVersion)
+	}
+
+	bridgeAddr, err := kernel.InterfaceAddr(c.kernel, c.opt.BridgeName)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving IP/network of bridge %s failed: %v", c.opt.BridgeName, err)
+	}
+
+	// Open the database.
+	if err := c.openDB(ctx, false); err != nil {
+		return nil, err
+	}
+	defer c.closeDB()
+
+	if !force {
+		allocations, err := c.allocations()
+		if err != nil {
+			return nil, err
+		}
+		if len(allocations) > 0 {
+			return nil, fmt.Errorf("database at %s has %d allocations, force the import to replace them", c.dbPath, len(allocations))
+		}
+	}
+
+	skipped := []Inconsistency{}
+	allocations := []Allocation{}
+	ips := map[string]bool{}
+	pids := map[int]bool{}
+	for _, a := range s.Allocations {
+		if a.IP == nil || a.PID < 1 {
+			return nil, fmt.Errorf("allocation %+v has no ip or pid", a)
+		}
+		if reason := usableIP(a.IP, bridgeAddr); len(reason) > 0 {
+			return nil, fmt.Errorf("ip %s of pid %d %s", a.IP.String(), a.PID, reason)
+		}
+		if ips[a.IP.String()] {
+			return nil, fmt.Errorf("ip %s is allocated twice", a.IP.String())
+		}
+		if pids[a.PID] {
+			return nil, fmt.Errorf("pid %d owns several ips", a.PID)
+		}
+		ips[a.IP.String()] = true
+		pids[a.PID] = true
+
+		if reason := c.checkAllocation(a); len(reason) > 0 {
+			skipped = append(skipped, Inconsistency{
+				IP:     a.IP,
+				PID:    a.PID,
+				Veth:   c.vethName(a.PID),
+				Reason: reason + ", not imported",
+			})
+			continue
+		}
+		allocations = append(allocations, a)
+	}
+
+	lastip := s.LastIP
+	if lastip != nil && len(usableIP(lastip, bridgeAddr)) > 0 {
+		skipped = append(skipped, Inconsistency{
+			IP:     lastip,
+			Reason: "the last ip of the allocator is not in the subnet of the bridge, not imported",
+		})
+		lastip = nil
+	}
+
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		// Drop everything, the dump replaces the whole database.
+		var names [][]byte
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte(nil), name...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+
+		// Restore the other buckets as they are.
+		for name, entries := range s.Buckets {
+			if name == string(ipBucket) || name == string(allocationBucket) || name == string(metaBucket) {
+				continue
+			}
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return fmt.Errorf("creating bucket %s failed: %v", name, err)
+			}
+			for _, e := range entries {
+				if err := b.Put(e.Key, e.Value); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := putSchemaVersion(tx, schemaVersion); err != nil {
+			return err
+		}
+		ipb, err := tx.CreateBucket(ipBucket)
+		if err != nil {
+			return err
+		}
+		records, err := tx.CreateBucket(allocationBucket)
+		if err != nil {
+			return err
+		}
+		if lastip != nil {
+			if err := ipb.Put([]byte{0}, dbKey(lastip)); err != nil {
+				return err
+			}
+		}
+		for _, a := range allocations {
+			ip := dbKey(a.IP)
+			a.IP = ip
+			b, err := json.Marshal(a)
+			if err != nil {
+				return fmt.Errorf("marshaling allocation for %s failed: %v", ip.String(), err)
+			}
+			if err := ipb.Put(ip, []byte(strconv.Itoa(a.PID))); err != nil {
+				return err
+			}
+			if err := records.Put(ip, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("importing database at %s failed: %v", c.dbPath, err)
+	}
+
+	return skipped, nil
+}
+
+// stateFix is how a problem found in the database is repaired.
+type stateFix struct {
+	// keys are removed from the ip allocator and the allocation records.
+	keys [][]byte
+	// records are removed from the allocation records only.
+	records [][]byte
+	// resetLast removes the last ip of the allocator.
+	resetLast bool
+	// release is the ip whose network is released.
+	release string
+}
+
+// CheckState checks the database against the subnet of the bridge and the
+// kernel: the last ip of the allocator, the ips, the owners and the records
+// of the allocations, and the namespace and the veth of each container.
+// With repair the problems are fixed, a corrupt last ip is reset, the
+// entries that cannot be used are removed and the networks of the
+// containers whose namespace or veth is gone are released.
+func (c *Client) CheckState(ctx context.Context, repair bool) ([]Inconsistency, error) {
+	// Return early if the database has not been created yet.
+	if _, err := os.Stat(c.dbPath); os.IsNotExist(err) {
+		return []Inconsistency{}, nil
+	}
+
+	// Without the bridge the ips cannot be checked against its subnet.
+	bridgeAddr, err := kernel.InterfaceAddr(c.kernel, c.opt.BridgeName)
+	if err != nil {
+		bridgeAddr = nil
+	}
+
+	problems, fixes, err := c.checkState(ctx, bridgeAddr, repair)
+	if err != nil || !repair {
+		return problems, err
+	}
+
+	// Release the networks last since it opens the database again.
+	for i, fix := range fixes {
+		if len(fix.release) < 1 {
+			continue
+		}
+		if err := c.release(ctx, fix.release, "repair"); err != nil {
+			logrus.Warnf("releasing ip %s failed: %v", fix.release, err)
+			continue
+		}
+		problems[i].Repaired = true
+	}
+
+	return problems, nil
+}
+
+// checkState finds the problems of the database and repairs the ones that
+// only need the database changed when repair is true.
+func (c *Client) checkState(ctx context.Context, bridgeAddr *net.IPNet, repair bool) ([]Inconsistency, []stateFix, error) {
+	// Open the database.
+	if err := c.openDB(ctx, !repair); err != nil {
+		return nil, nil, err
+	}
+	defer c.closeDB()
+
+	problems := []Inconsistency{}
+	fixes := []stateFix{}
+	add := func(p Inconsistency, fix stateFix) {
+		problems = append(problems, p)
+		fixes = append(fixes, fix)
+	}
+
+	allocations := []Allocation{}
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		ips := tx.Bucket(ipBucket)
+		if ips == nil {
+			return nil
+		}
+
+		if last := ips.Get([]byte{0}); last != nil {
+			p := Inconsistency{Reason: "the last ip of the allocator is corrupt"}
+			if len(last) == net.IPv4len || len(last) == net.IPv6len {
+				p.IP = net.ParseIP(net.IP(last).String())
+				if bridgeAddr != nil && len(usableIP(p.IP, bridgeAddr)) > 0 {
+					p.Reason = "the last ip of the allocator is not in the subnet of the bridge"
+					add(p, stateFix{resetLast: true})
+				}
+			} else {
+				add(p, stateFix{resetLast: true})
+			}
+		}
+
+		if err := ips.ForEach(func(k, v []byte) error {
+			// skip last ip
+			if len(k) == 1 && k[0] == 0 {
+				return nil
+			}
+			key := append([]byte(nil), k...)
+
+			if len(k) != net.IPv4len && len(k) != net.IPv6len {
+				add(Inconsistency{Reason: fmt.Sprintf("the key %x is not an ip", k)}, stateFix{keys: [][]byte{key}})
+				return nil
+			}
+			ip := net.ParseIP(net.IP(k).String())
+			pid, err := strconv.Atoi(string(v))
+			if err != nil || pid < 1 {
+				add(Inconsistency{IP: ip, Reason: fmt.Sprintf("the owner %q is not a pid", v)}, stateFix{keys: [][]byte{key}})
+				return nil
+			}
+			if bridgeAddr != nil {
+				if reason := usableIP(ip, bridgeAddr); len(reason) > 0 {
+					add(Inconsistency{IP: ip, PID: pid, Reason: "the ip " + reason}, stateFix{keys: [][]byte{key}})
+					return nil
+				}
+			}
+
+			a, err := getAllocation(tx, k, v)
+			if err != nil {
+				add(Inconsistency{IP: ip, PID: pid, Reason: "the allocation record is corrupt"}, stateFix{records: [][]byte{key}})
+				a = Allocation{IP: ip, PID: pid}
+			}
+			allocations = append(allocations, a)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		records := tx.Bucket(allocationBucket)
+		if records == nil {
+			return nil
+		}
+		return records.ForEach(func(k, v []byte) error {
+			if ips.Get(k) != nil {
+				return nil
+			}
+			p := Inconsistency{Reason: "the allocation record has no ip in the allocator"}
+			if len(k) == net.IPv4len || len(k) == net.IPv6len {
+				p.IP = net.ParseIP(net.IP(k).String())
+			}
+			add(p, stateFix{records: [][]byte{append([]byte(nil), k...)}})
+			return nil
+		})
+	}); err != nil {
+		return nil, nil, fmt.Errorf("checking database at %s failed: %v", c.dbPath, err)
+	}
+
+	// Keep a single ip per pid, the one the container uses if any.
+	byPID := map[int][]Allocation{}
+	for _, a := range allocations {
+		byPID[a.PID] = append(byPID[a.PID], a)
+	}
+	owners := []Allocation{}
+	for _, a := range allocations {
+		owned := byPID[a.PID]
+		if owned == nil {
+			continue
+		}
+		delete(byPID, a.PID)
+
+		keep := 0
+		if len(owned) > 1 {
+			keep = c.usedAllocation(owned)
+		}
+		owners = append(owners, owned[keep])
+		for i, dup := range owned {
+			if i == keep {
+				continue
+			}
+			add(Inconsistency{
+				IP:     dup.IP,
+				PID:    dup.PID,
+				Reason: fmt.Sprintf("the pid owns ip %s as well", owned[keep].IP.String()),
+			}, stateFix{keys: [][]byte{dbKey(dup.IP)}})
+		}
+	}
+
+	for _, a := range owners {
+		if reason := c.checkAllocation(a); len(reason) > 0 {
+			add(Inconsistency{
+				IP:     a.IP,
+				PID:    a.PID,
+				Veth:   c.vethName(a.PID),
+				Reason: reason,
+			}, stateFix{release: a.IP.String()})
+		}
+	}
+
+	if !repair {
+		return problems, fixes, nil
+	}
+
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		ips := tx.Bucket(ipBucket)
+		records := tx.Bucket(allocationBucket)
+		for _, fix := range fixes {
+			if fix.resetLast {
+				if err := ips.Delete([]byte{0}); err != nil {
+					return err
+				}
+			}
+			for _, k := range fix.keys {
+				if err := ips.Delete(k); err != nil {
+					return err
+				}
+			}
+			if records == nil {
+				continue
+			}
+			for _, k := range append(fix.keys, fix.records...) {
+				if err := records.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, fmt.Errorf("repairing database at %s failed: %v", c.dbPath, err)
+	}
+	for i, fix := range fixes {
+		if len(fix.release) < 1 {
+			problems[i].Repaired = true
+		}
+	}
+
+	return problems, fixes, nil
+}
+
+// checkAllocation returns why the network of the allocation is gone, or an
+// empty string if its namespace and its veth exist.
+func (c *Client) checkAllocation(a Allocation) string {
+	switch c.status(a) {
+	case StatusExited:
+		return "the container namespace is gone"
+	case StatusPIDReused:
+		return "the pid was reused by another process"
+	}
+	if _, err := c.kernel.LinkByName(c.vethName(a.PID)); err != nil {
+		return "the local side of the veth pair is missing"
+	}
+	return ""
+}
+
+// usedAllocation returns the index of the allocation whose ip is on the
+// interface of the container, or of the lowest ip if none is.
+func (c *Client) usedAllocation(owned []Allocation) int {
+	sort.Slice(owned, func(i, j int) bool {
+		return ipToBigInt(owned[i].IP).Cmp(ipToBigInt(owned[j].IP)) < 0
+	})
+
+	pid := owned[0].PID
+	if c.status(owned[0]) != StatusRunning {
+		return 0
+	}
+	var addr *net.IPNet
+	if err := c.kernel.InNamespace(pid, func() (err error) {
+		addr, err = kernel.InterfaceAddr(c.kernel, c.containerInterface(owned[0]))
+		return err
+	}); err != nil {
+		return 0
+	}
+	for i, a := range owned {
+		if a.IP.Equal(addr.IP) {
+			return i
+		}
+	}
+	return 0
+}
+
+// usableIP returns why ip cannot be allocated on the bridge with the address
+// bridgeAddr, or an empty string if it can.
+func usableIP(ip net.IP, bridgeAddr *net.IPNet) string {
+	ip = dbKey(ip)
+	subnet := &net.IPNet{IP: bridgeAddr.IP.Mask(bridgeAddr.Mask), Mask: bridgeAddr.Mask}
+	switch {
+	case !subnet.Contains(ip):
+		return fmt.Sprintf("is outside of subnet %s", subnet.String())
+	case ip.Equal(bridgeAddr.IP):
+		return "is the address of the bridge"
+	case !isUnicastIP(ip, subnet.Mask):
+		return fmt.Sprintf("is not a unicast address of subnet %s", subnet.String())
+	}
+	return ""
+}
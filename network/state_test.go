@@ -0,0 +1,175 @@
+package network
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestExportImport(t *testing.T) {
+	c, k := newTestClient(t)
+	for _, pid := range []int{1234, 1235} {
+		if _, err := testCreate(c, k, pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := c.Export(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Allocations) != 2 || s.LastIP.String() != "172.19.0.3" || len(s.Buckets[string(ipBucket)]) != 3 {
+		t.Fatalf("unexpected export %+v", s)
+	}
+
+	// The database has allocations, the import must be forced.
+	if _, err := c.Import(context.Background(), s, false); err == nil {
+		t.Fatal("expected an error for a database with allocations")
+	}
+
+	// The second container is gone so it is not imported.
+	k.RemoveProcess(1235)
+	skipped, err := c.Import(context.Background(), s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || skipped[0].PID != 1235 {
+		t.Fatalf("expected pid 1235 to be skipped, got %v", skipped)
+	}
+
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].PID != 1234 || allocations[0].IP.String() != "172.19.0.2" {
+		t.Fatalf("unexpected allocations %+v", allocations)
+	}
+
+	// The allocator carries on from the imported position.
+	ip, err := testCreate(c, k, 1236)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "172.19.0.4" {
+		t.Fatalf("expected 172.19.0.4 got %s", ip.String())
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	c, k := newTestClient(t)
+	if _, err := testCreate(c, k, 1234); err != nil {
+		t.Fatal(err)
+	}
+	k.AddProcess(1235)
+
+	for name, allocations := range map[string][]Allocation{
+		"outside of subnet": {{IP: net.ParseIP("10.0.0.2"), PID: 1234}},
+		"bridge address":    {{IP: net.ParseIP("172.19.0.1"), PID: 1234}},
+		"allocated twice":   {{IP: net.ParseIP("172.19.0.2"), PID: 1234}, {IP: net.ParseIP("172.19.0.2"), PID: 1235}},
+		"several ips":       {{IP: net.ParseIP("172.19.0.2"), PID: 1234}, {IP: net.ParseIP("172.19.0.3"), PID: 1234}},
+	} {
+		if _, err := c.Import(context.Background(), &State{Allocations: allocations}, true); err == nil {
+			t.Fatalf("expected an error for %s", name)
+		}
+	}
+
+	// The database was left as it was.
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].PID != 1234 {
+		t.Fatalf("unexpected allocations %+v", allocations)
+	}
+}
+
+func TestCheckState(t *testing.T) {
+	c, k := newTestClient(t)
+	for _, pid := range []int{1234, 1235} {
+		if _, err := testCreate(c, k, pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k.RemoveProcess(1235)
+
+	// Corrupt the database behind the back of the client.
+	db, err := bolt.Open(c.DatabasePath(), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ipBucket)
+		for k, v := range map[string]string{
+			string([]byte{0}):                           "\x0a\x00",
+			string(net.ParseIP("10.0.0.2").To4()):       "1236",
+			string(net.ParseIP("172.19.0.9").To4()):     "1234",
+			string(net.ParseIP("172.19.0.10").To4()):    "init",
+			string(net.ParseIP("172.19.255.255").To4()): "1237",
+		} {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(allocationBucket).Put(net.ParseIP("172.19.0.20").To4(), []byte(`{}`))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	expected := []string{
+		"the last ip of the allocator is corrupt",
+		"10.0.0.2 pid 1236: the ip is outside of subnet 172.19.0.0/16",
+		"172.19.0.10: the owner \"init\" is not a pid",
+		"172.19.255.255 pid 1237: the ip is not a unicast address of subnet 172.19.0.0/16",
+		"172.19.0.20: the allocation record has no ip in the allocator",
+		"172.19.0.9 pid 1234: the pid owns ip 172.19.0.2 as well",
+		"172.19.0.3 netnsv0-1235 pid 1235: the container namespace is gone",
+	}
+
+	problems, err := c.CheckState(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inconsistencies(problems); got != strings.Join(expected, "\n") {
+		t.Fatalf("expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), got)
+	}
+
+	problems, err = c.CheckState(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		if !p.Repaired {
+			t.Fatalf("expected %s to be repaired", p.String())
+		}
+	}
+
+	problems, err = c.CheckState(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems after the repair, got:\n%s", inconsistencies(problems))
+	}
+	allocations, err := c.Allocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocations) != 1 || allocations[0].IP.String() != "172.19.0.2" {
+		t.Fatalf("unexpected allocations %+v", allocations)
+	}
+	if _, err := k.LinkByName("netnsv0-1235"); err == nil {
+		t.Fatal("expected the veth of pid 1235 to be deleted")
+	}
+}
+
+func inconsistencies(problems []Inconsistency) string {
+	var lines []string
+	for _, p := range problems {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n")
+}
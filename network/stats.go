@@ -0,0 +1,48 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/genuinetools/netns/kernel"
+)
+
+// Stats holds the traffic counters of a container network, from the point of
+// view of the container: Rx is the traffic received by the container and Tx
+// the traffic it sent.
+type Stats struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	TxErrors  uint64 `json:"tx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// linkStats returns the traffic counters for the container using the local
+// side of the veth pair with the given name.
+func linkStats(k kernel.Kernel, name string) (Stats, error) {
+	link, err := k.LinkByName(name)
+	if err != nil {
+		return Stats{}, fmt.Errorf("getting link %s failed: %v", name, err)
+	}
+
+	s := link.Attrs().Statistics
+	if s == nil {
+		return Stats{}, nil
+	}
+
+	// The traffic received by the local side of the veth pair was sent by
+	// the container and the other way around.
+	return Stats{
+		RxBytes:   s.TxBytes,
+		TxBytes:   s.RxBytes,
+		RxPackets: s.TxPackets,
+		TxPackets: s.RxPackets,
+		RxErrors:  s.TxErrors,
+		TxErrors:  s.RxErrors,
+		RxDropped: s.TxDropped,
+		TxDropped: s.RxDropped,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package network
+
+import "github.com/genuinetools/netns/kernel"
+
+// The statuses of a container network.
+const (
+	// StatusRunning is the status of the containers whose process is running.
+	StatusRunning = "running"
+	// StatusExited is the status of the containers whose process and
+	// network namespace are gone.
+	StatusExited = "exited"
+	// StatusPIDReused is the status of the containers whose pid was given to
+	// another process after theirs exited.
+	StatusPIDReused = "pid-reused"
+	// StatusNamespaceHeld is the status of the containers whose process is
+	// gone but whose network namespace is kept alive by another process or
+	// a bind mount.
+	StatusNamespaceHeld = "namespace-held-elsewhere"
+)
+
+// status returns the status of the container of the allocation, comparing
+// the process recorded when the network was created with the one running
+// with its pid. The allocations saved by older versions have no process, the
+// pid is trusted for those.
+func (c *Client) status(a Allocation) string {
+	return c.statusWith(a, &namespaceHolders{kernel: c.kernel})
+}
+
+// statusWith returns the status of the container of the allocation, looking
+// up the holders of its namespace in holders, which the operations on all
+// the allocations share.
+func (c *Client) statusWith(a Allocation, holders *namespaceHolders) string {
+	p, err := c.kernel.Process(a.PID)
+	if a.Process == nil {
+		if err != nil {
+			return StatusExited
+		}
+		return StatusRunning
+	}
+
+	if err == nil && p.StartTime == a.Process.StartTime {
+		return StatusRunning
+	}
+	if holders.held(a.Process.NetNS) {
+		return StatusNamespaceHeld
+	}
+	if err == nil {
+		return StatusPIDReused
+	}
+	return StatusExited
+}
+
+// namespaceHolders are the holders of the network namespaces, they are
+// looked up once the first container whose process is gone needs them.
+type namespaceHolders struct {
+	kernel  kernel.Kernel
+	holders map[kernel.Namespace]string
+}
+
+// held returns true if a process or a bind mount keeps the namespace alive.
+func (h *namespaceHolders) held(ns kernel.Namespace) bool {
+	if h.holders == nil {
+		h.holders = h.kernel.NamespaceHolders()
+	}
+	return len(h.holders[ns]) > 0
+}
+
+// isGone returns true if the network of a container with the status can be
+// released.
+func isGone(status string) bool {
+	return status == StatusExited || status == StatusPIDReused
+}
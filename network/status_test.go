@@ -0,0 +1,91 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	c, k := newTestClient(t)
+	for _, pid := range []int{1234, 1235, 1236, 1237} {
+		if _, err := testCreate(c, k, pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1235 exits and its pid is given to another process, the namespace of
+	// 1236 is bind mounted before it exits and 1237 exits.
+	k.RemoveProcess(1235)
+	k.AddProcess(1235)
+	k.HoldNamespace(1236)
+	k.RemoveProcess(1236)
+	k.RemoveProcess(1237)
+
+	expected := map[int]string{
+		1234: StatusRunning,
+		1235: StatusPIDReused,
+		1236: StatusNamespaceHeld,
+		1237: StatusExited,
+	}
+	k.HolderLookups = 0
+	networks, err := c.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != len(expected) {
+		t.Fatalf("expected %d networks got %d", len(expected), len(networks))
+	}
+	for _, n := range networks {
+		if n.Status != expected[n.PID] {
+			t.Fatalf("expected pid %d to be %s, got %s", n.PID, expected[n.PID], n.Status)
+		}
+	}
+	// The holders are looked up once for all the containers that are gone.
+	if k.HolderLookups != 1 {
+		t.Fatalf("expected the holders of the namespaces to be looked up once, got %d", k.HolderLookups)
+	}
+
+	// Nothing runs in the namespace of a reused pid.
+	if err := c.Exec(context.Background(), "1235", exec.Command("true")); !errors.Is(err, ErrNamespaceGone) {
+		t.Fatalf("expected exec in a reused pid to fail with %v, got %v", ErrNamespaceGone, err)
+	}
+	if err := c.Impair(context.Background(), "1236", nil); !errors.Is(err, ErrNamespaceGone) {
+		t.Fatalf("expected impair of a held namespace to fail with %v, got %v", ErrNamespaceGone, err)
+	}
+
+	i, err := c.Inspect(context.Background(), "1235")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Status != StatusPIDReused || i.Interface != nil {
+		t.Fatalf("expected the namespace of the reused pid not to be inspected, got %+v", i)
+	}
+
+	// Only the containers that are gone are released.
+	k.HolderLookups = 0
+	reclaimed, err := c.GC(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reclaimed) != 2 || reclaimed[0].PID != 1235 || reclaimed[1].PID != 1237 {
+		t.Fatalf("expected pids 1235 and 1237 to be reclaimed, got %+v", reclaimed)
+	}
+	if k.HolderLookups != 1 {
+		t.Fatalf("expected gc to look up the holders of the namespaces once, got %d", k.HolderLookups)
+	}
+}
+
+func TestStatusWithoutProcess(t *testing.T) {
+	c, k := newTestClient(t)
+	k.AddProcess(1234)
+
+	// The allocations saved by older versions trust the pid.
+	if status := c.status(Allocation{PID: 1234}); status != StatusRunning {
+		t.Fatalf("expected %s got %s", StatusRunning, status)
+	}
+	if status := c.status(Allocation{PID: 1235}); status != StatusExited {
+		t.Fatalf("expected %s got %s", StatusExited, status)
+	}
+}
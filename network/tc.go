@@ -0,0 +1,204 @@
+package network
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/genuinetools/netns/kernel"
+	"github.com/vishvananda/netlink"
+)
+
+// tbfLatency is the maximum time in microseconds a packet can wait in the
+// token bucket before being dropped.
+const tbfLatency = 25 * 1000
+
+var (
+	// rootHandle is the handle of the root qdisc we add to links.
+	rootHandle = netlink.MakeHandle(1, 0)
+	// netemChildHandle is the handle of the tbf qdisc we add under netem to
+	// limit its rate.
+	netemChildHandle = netlink.MakeHandle(10, 0)
+	// ingressHandle is the handle of the ingress qdisc we add to links.
+	ingressHandle = netlink.MakeHandle(0xffff, 0)
+)
+
+// setQdiscs sets the queueing disciplines for the traffic sent out of the
+// link. The traffic is shaped by a token bucket filter when there is a rate
+// limit and impaired by netem when there is a profile. When both are set the
+// token bucket is attached under netem. When neither is set the root qdisc is
+// removed and the link falls back to the kernel default.
+func setQdiscs(k kernel.Kernel, link netlink.Link, l *RateLimit, n *Netem) error {
+	if n == nil {
+		if l == nil {
+			return deleteRootQdisc(k, link)
+		}
+
+		if err := replaceRootQdisc(k, link, tbfQdisc(link, rootHandle, netlink.HANDLE_ROOT, l)); err != nil {
+			return fmt.Errorf("adding tbf qdisc to %s failed: %v", link.Attrs().Name, err)
+		}
+		return nil
+	}
+
+	if err := replaceRootQdisc(k, link, netemQdisc(link, n)); err != nil {
+		return fmt.Errorf("adding netem qdisc to %s failed: %v", link.Attrs().Name, err)
+	}
+
+	// The netem rate is applied by the token bucket under it, keeping the
+	// lowest of the two rates.
+	if n.Rate > 0 && (l == nil || n.Rate < l.Rate) {
+		l = &RateLimit{Rate: n.Rate, Burst: minBurst}
+	}
+	if l == nil {
+		// Remove the token bucket of a previous profile with a rate, it
+		// is kept when netem is replaced.
+		return deleteQdisc(k, link, netemChildHandle, netlink.MakeHandle(1, 1))
+	}
+
+	if err := k.QdiscReplace(tbfQdisc(link, netemChildHandle, netlink.MakeHandle(1, 1), l)); err != nil {
+		return fmt.Errorf("adding tbf qdisc under netem to %s failed: %v", link.Attrs().Name, err)
+	}
+
+	return nil
+}
+
+// setEgressLimit limits the traffic the container sends, which is the
+// traffic received by the host side of its veth pair. The traffic is
+// redirected from the ingress of the link to an ifb device shaped by a token
+// bucket filter, out of the reach of the container. Without a limit the ifb
+// device is removed.
+func setEgressLimit(k kernel.Kernel, link netlink.Link, ifbName string, l *RateLimit) error {
+	// Remove the ifb device left behind by a container with the same pid.
+	if err := deleteIfb(k, ifbName); err != nil {
+		return err
+	}
+	if l == nil {
+		return nil
+	}
+
+	la := netlink.NewLinkAttrs()
+	la.Name = ifbName
+	if err := k.LinkAdd(&netlink.Ifb{LinkAttrs: la}); err != nil {
+		return fmt.Errorf("adding ifb device %s failed: %v", ifbName, err)
+	}
+	ifb, err := k.LinkByName(ifbName)
+	if err != nil {
+		return fmt.Errorf("getting ifb device %s failed: %v", ifbName, err)
+	}
+	if err := k.LinkSetUp(ifb); err != nil {
+		return fmt.Errorf("bringing ifb device %s up failed: %v", ifbName, err)
+	}
+	if err := setQdiscs(k, ifb, l, nil); err != nil {
+		return err
+	}
+
+	// Redirect all the traffic received by the link to the ifb device.
+	if err := k.QdiscReplace(&netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    ingressHandle,
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}); err != nil {
+		return fmt.Errorf("adding ingress qdisc to %s failed: %v", link.Attrs().Name, err)
+	}
+	if err := k.FilterAdd(&netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    ingressHandle,
+			Priority:  1,
+			Protocol:  syscall.ETH_P_ALL,
+		},
+		RedirIndex: ifb.Attrs().Index,
+	}); err != nil {
+		return fmt.Errorf("redirecting the traffic of %s to %s failed: %v", link.Attrs().Name, ifbName, err)
+	}
+
+	return nil
+}
+
+// deleteIfb removes the ifb device if it exists.
+func deleteIfb(k kernel.Kernel, name string) error {
+	link, err := k.LinkByName(name)
+	if err != nil {
+		return nil
+	}
+	if link.Type() != "ifb" {
+		return fmt.Errorf("link %s is not an ifb device", name)
+	}
+	if err := k.LinkDel(link); err != nil && !kernel.IsLinkNotFound(err) {
+		return fmt.Errorf("deleting ifb device %s failed: %v", name, err)
+	}
+	return nil
+}
+
+// replaceRootQdisc sets the root qdisc of the link. The kernel does not
+// change the kind of a qdisc in place, so the root qdisc we added is deleted
+// first when it is of another kind, with the qdiscs under it.
+func replaceRootQdisc(k kernel.Kernel, link netlink.Link, qdisc netlink.Qdisc) error {
+	qdiscs, err := k.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("listing qdiscs for %s failed: %v", link.Attrs().Name, err)
+	}
+	for _, q := range qdiscs {
+		if q.Attrs().Parent == netlink.HANDLE_ROOT && q.Attrs().Handle == rootHandle && q.Type() != qdisc.Type() {
+			if err := k.QdiscDel(q); err != nil {
+				return fmt.Errorf("deleting %s qdisc from %s failed: %v", q.Type(), link.Attrs().Name, err)
+			}
+		}
+	}
+
+	return k.QdiscReplace(qdisc)
+}
+
+// deleteRootQdisc removes the root qdisc we added to the link if it exists.
+func deleteRootQdisc(k kernel.Kernel, link netlink.Link) error {
+	return deleteQdisc(k, link, rootHandle, netlink.HANDLE_ROOT)
+}
+
+// deleteQdisc removes the qdisc of the link with the handle and parent if it
+// exists.
+func deleteQdisc(k kernel.Kernel, link netlink.Link, handle, parent uint32) error {
+	qdiscs, err := k.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("listing qdiscs for %s failed: %v", link.Attrs().Name, err)
+	}
+
+	for _, q := range qdiscs {
+		if q.Attrs().Parent == parent && q.Attrs().Handle == handle {
+			if err := k.QdiscDel(q); err != nil {
+				return fmt.Errorf("deleting %s qdisc from %s failed: %v", q.Type(), link.Attrs().Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func tbfQdisc(link netlink.Link, handle, parent uint32, l *RateLimit) *netlink.Tbf {
+	return &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    handle,
+			Parent:    parent,
+		},
+		Rate:   l.Rate,
+		Buffer: uint32(netlink.Xmittime(l.Rate, l.Burst)),
+		Limit:  uint32(l.Rate*tbfLatency/1000000) + l.Burst,
+	}
+}
+
+func netemQdisc(link netlink.Link, n *Netem) *netlink.Netem {
+	return netlink.NewNetem(netlink.QdiscAttrs{
+		LinkIndex: link.Attrs().Index,
+		Handle:    rootHandle,
+		Parent:    netlink.HANDLE_ROOT,
+	}, netlink.NetemQdiscAttrs{
+		Latency:     uint32(n.Delay / time.Microsecond),
+		Jitter:      uint32(n.Jitter / time.Microsecond),
+		Loss:        n.Loss,
+		Duplicate:   n.Duplicate,
+		ReorderProb: n.Reorder,
+		CorruptProb: n.Corrupt,
+	})
+}
@@ -0,0 +1,40 @@
+package resolvconf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// hostsMarker marks the entries managed by netns in a hosts file.
+const hostsMarker = "# netns"
+
+// DefaultHosts is the content of a hosts file for a container before any
+// entries are added.
+var DefaultHosts = []byte(`127.0.0.1	localhost
+::1	localhost ip6-localhost ip6-loopback
+fe00::0	ip6-localnet
+ff00::0	ip6-mcastprefix
+ff02::1	ip6-allnodes
+ff02::2	ip6-allrouters
+`)
+
+// UpdateHosts returns the content of the hosts file b with the entry for ip
+// and names replacing any entry previously added by UpdateHosts.
+func UpdateHosts(b []byte, ip net.IP, names ...string) []byte {
+	var out bytes.Buffer
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if strings.HasSuffix(s.Text(), hostsMarker) {
+			continue
+		}
+		out.WriteString(s.Text())
+		out.WriteByte('\n')
+	}
+
+	fmt.Fprintf(&out, "%s\t%s\t%s\n", ip.String(), strings.Join(names, " "), hostsMarker)
+	return out.Bytes()
+}
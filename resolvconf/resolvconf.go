@@ -0,0 +1,119 @@
+package resolvconf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	// Path is the path of the resolv.conf file on the host.
+	Path = "/etc/resolv.conf"
+	// SystemdResolvedPath is the path of the resolv.conf file holding the
+	// upstream nameservers when systemd-resolved is running.
+	SystemdResolvedPath = "/run/systemd/resolve/resolv.conf"
+)
+
+var (
+	// DefaultNameservers are the nameservers used when the host has none
+	// that can be reached from a container.
+	DefaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+)
+
+// Config holds the settings of a resolv.conf file.
+type Config struct {
+	Nameservers []string
+	Search      []string
+	Options     []string
+}
+
+// Parse parses the content of a resolv.conf file.
+func Parse(b []byte) Config {
+	var c Config
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 1 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			if len(fields) > 1 {
+				c.Nameservers = append(c.Nameservers, fields[1])
+			}
+		case "domain", "search":
+			// The last search or domain line wins.
+			c.Search = fields[1:]
+		case "options":
+			c.Options = append(c.Options, fields[1:]...)
+		}
+	}
+
+	return c
+}
+
+// Host returns the configuration of the host usable from a container network
+// namespace. When the host points to a local resolver, like the
+// systemd-resolved stub at 127.0.0.53, the upstream nameservers are used
+// instead. Loopback nameservers are dropped since they cannot be reached from
+// a container and the default nameservers are used if none are left.
+func Host() (Config, error) {
+	b, err := ioutil.ReadFile(Path)
+	if err != nil && !os.IsNotExist(err) {
+		return Config{}, fmt.Errorf("reading %s failed: %v", Path, err)
+	}
+	c := Parse(b)
+
+	if len(FilterLoopback(c.Nameservers)) == 0 {
+		// Try the upstream nameservers of systemd-resolved.
+		if b, err := ioutil.ReadFile(SystemdResolvedPath); err == nil {
+			upstream := Parse(b)
+			c.Nameservers = upstream.Nameservers
+			if len(c.Search) == 0 {
+				c.Search = upstream.Search
+			}
+		}
+	}
+
+	c.Nameservers = FilterLoopback(c.Nameservers)
+	if len(c.Nameservers) == 0 {
+		c.Nameservers = DefaultNameservers
+	}
+
+	return c, nil
+}
+
+// FilterLoopback returns the nameservers that are not loopback addresses.
+func FilterLoopback(nameservers []string) []string {
+	filtered := []string{}
+	for _, ns := range nameservers {
+		ip := net.ParseIP(ns)
+		if ip == nil || ip.IsLoopback() {
+			continue
+		}
+		filtered = append(filtered, ns)
+	}
+	return filtered
+}
+
+// Bytes returns the content of the resolv.conf file for the configuration.
+func (c Config) Bytes() []byte {
+	var b bytes.Buffer
+	b.WriteString("# Generated by netns.\n")
+	for _, ns := range c.Nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if len(c.Search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(c.Search, " "))
+	}
+	if len(c.Options) > 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(c.Options, " "))
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,49 @@
+package resolvconf
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c := Parse([]byte(`# comment
+nameserver 127.0.0.53
+nameserver 10.0.0.2
+domain example.org
+search corp.example.com example.com
+options edns0 trust-ad
+options ndots:2
+`))
+
+	expected := Config{
+		Nameservers: []string{"127.0.0.53", "10.0.0.2"},
+		Search:      []string{"corp.example.com", "example.com"},
+		Options:     []string{"edns0", "trust-ad", "ndots:2"},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Fatalf("expected %#v got %#v", expected, c)
+	}
+
+	if !reflect.DeepEqual(Parse(c.Bytes()), expected) {
+		t.Fatalf("expected %q to parse as %#v", c.Bytes(), expected)
+	}
+}
+
+func TestFilterLoopback(t *testing.T) {
+	filtered := FilterLoopback([]string{"127.0.0.53", "::1", "10.0.0.2", "foo", "2001:db8::1"})
+	expected := []string{"10.0.0.2", "2001:db8::1"}
+	if !reflect.DeepEqual(filtered, expected) {
+		t.Fatalf("expected %v got %v", expected, filtered)
+	}
+}
+
+func TestUpdateHosts(t *testing.T) {
+	b := UpdateHosts(DefaultHosts, net.ParseIP("172.19.0.2"), "web", "abc123")
+	b = UpdateHosts(b, net.ParseIP("172.19.0.3"), "web")
+
+	expected := string(DefaultHosts) + "172.19.0.3\tweb\t# netns\n"
+	if string(b) != expected {
+		t.Fatalf("expected %q got %q", expected, b)
+	}
+}
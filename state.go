@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/genuinetools/netns/network"
+)
+
+const stateHelp = `Export, import or check the database of the network.
+
+  export [file]   write the allocations and the raw content of every bucket
+                  as JSON to the file, or to stdout
+  import <file>   replace the database with an export, - reads stdin. The
+                  ips must be usable in the subnet of the bridge and given
+                  once, the containers whose namespace or veth is gone are
+                  skipped. A database with allocations is only replaced
+                  with --force.
+  check           check the last ip of the allocator, the ips, owners and
+                  records of the allocations, and the namespace and veth of
+                  each container. With --repair the problems are fixed.`
+
+func (cmd *stateCommand) Name() string      { return "state" }
+func (cmd *stateCommand) Args() string      { return "[OPTIONS] export|import|check [file]" }
+func (cmd *stateCommand) ShortHelp() string { return `Export, import or check the database.` }
+func (cmd *stateCommand) LongHelp() string  { return stateHelp }
+func (cmd *stateCommand) Hidden() bool      { return false }
+
+func (cmd *stateCommand) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.force, "force", false, "replace a database with allocations on import")
+	fs.BoolVar(&cmd.repair, "repair", false, "fix the problems found by check")
+	fs.StringVar(&cmd.format, "format", "table", "output format of check (table, json)")
+}
+
+type stateCommand struct {
+	force  bool
+	repair bool
+	format string
+}
+
+func (cmd *stateCommand) Run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("must pass one of export, import or check")
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	switch args[0] {
+	case "export":
+		return timeoutError(cmd.export(ctx, args[1:]))
+	case "import":
+		return timeoutError(cmd.importState(ctx, args[1:]))
+	case "check":
+		return timeoutError(cmd.check(ctx))
+	}
+	return fmt.Errorf("unknown state command %q, must be one of export, import or check", args[0])
+}
+
+func (cmd *stateCommand) export(ctx context.Context, args []string) error {
+	s, err := client.Export(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 || args[0] == "-" {
+		return writeJSON(os.Stdout, s)
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (cmd *stateCommand) importState(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("must pass the file to import, - for stdin")
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var s network.State
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return fmt.Errorf("decoding state from %s failed: %v", args[0], err)
+	}
+
+	skipped, err := client.Import(ctx, &s, cmd.force)
+	if err != nil {
+		return err
+	}
+	for _, p := range skipped {
+		fmt.Println(p.String())
+	}
+	fmt.Printf("imported %d of %d allocations\n", len(s.Allocations)-len(skippedAllocations(skipped)), len(s.Allocations))
+	return nil
+}
+
+func (cmd *stateCommand) check(ctx context.Context) error {
+	if cmd.format != "table" && cmd.format != "json" {
+		return fmt.Errorf("unknown format %q, must be one of table or json", cmd.format)
+	}
+
+	problems, err := client.CheckState(ctx, cmd.repair)
+	if err != nil {
+		return err
+	}
+
+	if cmd.format == "json" {
+		if err := writeJSON(os.Stdout, problems); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range problems {
+			fmt.Println(p.String())
+		}
+	}
+
+	left := 0
+	for _, p := range problems {
+		if !p.Repaired {
+			left++
+		}
+	}
+	if left > 0 {
+		return fmt.Errorf("%d problems found in the database", left)
+	}
+	return nil
+}
+
+// skippedAllocations returns the skipped allocations, leaving out the last
+// ip of the allocator.
+func skippedAllocations(skipped []network.Inconsistency) []network.Inconsistency {
+	var allocations []network.Inconsistency
+	for _, p := range skipped {
+		if p.PID > 0 {
+			allocations = append(allocations, p)
+		}
+	}
+	return allocations
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/genuinetools/netns/network"
+)
+
+const statsHelp = `Show the traffic statistics of the container networks.
+
+The rates are computed between two samples taken an interval apart.`
+
+func (cmd *statsCommand) Name() string      { return "stats" }
+func (cmd *statsCommand) Args() string      { return "[OPTIONS]" }
+func (cmd *statsCommand) ShortHelp() string { return `Show the traffic statistics of networks.` }
+func (cmd *statsCommand) LongHelp() string  { return statsHelp }
+func (cmd *statsCommand) Hidden() bool      { return false }
+
+func (cmd *statsCommand) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.watch, "watch", false, "keep refreshing the statistics")
+	fs.DurationVar(&cmd.interval, "interval", time.Second, "interval between samples")
+}
+
+type statsCommand struct {
+	watch    bool
+	interval time.Duration
+}
+
+func (cmd *statsCommand) Run(ctx context.Context, args []string) error {
+	if cmd.interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", cmd.interval)
+	}
+
+	prev, err := sampleStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cmd.interval):
+		}
+
+		cur, err := sampleStats(ctx)
+		if err != nil {
+			return err
+		}
+
+		if cmd.watch {
+			// Clear the screen.
+			fmt.Print("\033[2J\033[H")
+		}
+		printStats(prev, cur, cmd.interval)
+
+		if !cmd.watch {
+			return nil
+		}
+		prev = cur
+	}
+}
+
+// sampleStats returns the networks keyed by ip.
+func sampleStats(ctx context.Context) (map[string]network.Network, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	list, err := networks.List(ctx)
+	if err != nil {
+		return nil, timeoutError(err)
+	}
+
+	sample := make(map[string]network.Network, len(list))
+	for _, n := range list {
+		sample[n.IP.String()] = n
+	}
+	return sample, nil
+}
+
+func printStats(prev, cur map[string]network.Network, interval time.Duration) {
+	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "IP\tCONTAINER\tRX/S\tTX/S\tRX\tTX\tRX PKTS\tTX PKTS\tERRORS\tDROPS\n")
+	for _, ip := range sortedKeys(cur) {
+		n := cur[ip]
+
+		var rxRate, txRate float64
+		if p, ok := prev[ip]; ok && p.PID == n.PID {
+			rxRate = rate(p.Stats.RxBytes, n.Stats.RxBytes, interval)
+			txRate = rate(p.Stats.TxBytes, n.Stats.TxBytes, interval)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s/s\t%s/s\t%s\t%s\t%d\t%d\t%d\t%d\n",
+			ip, containerName(n),
+			formatBytes(rxRate), formatBytes(txRate),
+			formatBytes(float64(n.Stats.RxBytes)), formatBytes(float64(n.Stats.TxBytes)),
+			n.Stats.RxPackets, n.Stats.TxPackets,
+			n.Stats.RxErrors+n.Stats.TxErrors, n.Stats.RxDropped+n.Stats.TxDropped)
+	}
+	w.Flush()
+}
+
+// rate returns the rate per second between two counters, or zero if the
+// counter was reset.
+func rate(prev, cur uint64, interval time.Duration) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / interval.Seconds()
+}
+
+// containerName returns the name to display for the container of a network.
+func containerName(n network.Network) string {
+	switch {
+	case len(n.Hostname) > 0:
+		return n.Hostname
+	case len(n.ContainerID) > 0:
+		return n.ContainerID
+	}
+	return "-"
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+func formatBytes(b float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", b, units[i])
+}
+
+func sortedKeys(m map[string]network.Network) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(keys[i]).To16(), net.ParseIP(keys[j]).To16()) < 0
+	})
+	return keys
+}